@@ -0,0 +1,138 @@
+package address
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+/*
+This file contains the address encoding, which is a shorter, checksummed way to write a public key for people to use.
+The address is the base58 of the public key, followed by a 4 byte checksum of the public key.
+The checksum is the first 4 bytes of the shake256 hash of the public key, so a typo in an address is caught when it is decoded.
+Txs still use the public keys, addresses are only for UIs.
+*/
+
+// The 58 characters of an address, which leave out 0, O, I and l as they are easy to mix up.
+const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// The amount of bytes of the checksum at the end of an address.
+const checksumLen = 4
+
+// The error returned when an address has a character that is not in the alphabet.
+var ErrBadCharacter = errors.New("address has an invalid character")
+
+// The error returned when an address is too short to have a checksum.
+var ErrTooShort = errors.New("address is too short")
+
+// The error returned when the checksum of an address does not match its public key, like from a typo.
+var ErrBadChecksum = errors.New("address checksum does not match")
+
+// Encodes a public key into an address.
+// Input is the bytes of the public key.
+// Returns the address.
+func Encode(pubKey []byte) string {
+
+	return encodeBase58(append(append([]byte{}, pubKey...), checksum(pubKey)...))
+}
+
+// Decodes an address back into its public key.
+// Input is the address.
+// Returns the bytes of the public key, or an error if the address is malformed or its checksum is wrong.
+func Decode(addr string) ([]byte, error) {
+
+	decoded, err := decodeBase58(addr)
+
+	if err != nil {
+
+		return nil, err
+	}
+
+	if len(decoded) <= checksumLen {
+
+		return nil, ErrTooShort
+	}
+
+	pubKey := decoded[:len(decoded)-checksumLen]
+
+	if !bytes.Equal(decoded[len(decoded)-checksumLen:], checksum(pubKey)) {
+
+		return nil, ErrBadChecksum
+	}
+
+	return pubKey, nil
+}
+
+// Calculates the checksum of a public key.
+// Returns the checksum bytes.
+func checksum(pubKey []byte) []byte {
+
+	hash := make([]byte, checksumLen)
+	sha3.ShakeSum256(hash, pubKey)
+
+	return hash
+}
+
+// Encodes bytes into base58.
+// Each leading zero byte is written as a leading "1", as the number itself would lose them.
+// Returns the base58 string.
+func encodeBase58(data []byte) string {
+
+	number := new(big.Int).SetBytes(data)
+	base := big.NewInt(58)
+	remainder := new(big.Int)
+
+	encoded := []byte{}
+
+	for number.Sign() != 0 {
+
+		number.DivMod(number, base, remainder)
+		encoded = append(encoded, alphabet[remainder.Int64()])
+	}
+
+	for index := 0; index < len(data) && data[index] == 0; index += 1 {
+
+		encoded = append(encoded, alphabet[0])
+	}
+
+	// The digits were found from the smallest to the biggest
+	for left, right := 0, len(encoded)-1; left < right; left, right = left+1, right-1 {
+
+		encoded[left], encoded[right] = encoded[right], encoded[left]
+	}
+
+	return string(encoded)
+}
+
+// Decodes a base58 string into bytes.
+// Returns the bytes, or ErrBadCharacter if the string is not base58.
+func decodeBase58(encoded string) ([]byte, error) {
+
+	number := new(big.Int)
+	base := big.NewInt(58)
+
+	for index := 0; index < len(encoded); index += 1 {
+
+		digit := strings.IndexByte(alphabet, encoded[index])
+
+		if digit == -1 {
+
+			return nil, ErrBadCharacter
+		}
+
+		number.Mul(number, base)
+		number.Add(number, big.NewInt(int64(digit)))
+	}
+
+	leadingZeros := 0
+
+	for leadingZeros < len(encoded) && encoded[leadingZeros] == alphabet[0] {
+
+		leadingZeros += 1
+	}
+
+	return append(make([]byte, leadingZeros), number.Bytes()...), nil
+}
@@ -0,0 +1,75 @@
+package address
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+
+	key, err := crypto.GenerateKey()
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	pubKeys := [][]byte{
+		elliptic.Marshal(crypto.S256(), key.X, key.Y),
+		crypto.CompressPubkey(&key.PublicKey),
+		// Leading zero bytes are kept
+		{0, 0, 1, 2, 3},
+		{0},
+	}
+
+	for _, pubKey := range pubKeys {
+
+		addr := Encode(pubKey)
+		decoded, err := Decode(addr)
+
+		if err != nil || !bytes.Equal(decoded, pubKey) {
+
+			t.Errorf("Expected %x to round trip through %s, got %x (%v)", pubKey, addr, decoded, err)
+		}
+	}
+}
+
+func TestDecodeBadAddress(t *testing.T) {
+
+	addr := Encode([]byte("some public key"))
+
+	// Change one character, like a typo
+	typo := []byte(addr)
+
+	if typo[5] == 'a' {
+
+		typo[5] = 'b'
+	} else {
+
+		typo[5] = 'a'
+	}
+
+	tests := []struct {
+		name     string
+		addr     string
+		expected error
+	}{
+		{"typo", string(typo), ErrBadChecksum},
+		{"cut short", addr[:len(addr)-1], ErrBadChecksum},
+		{"bad character", "0" + addr[1:], ErrBadCharacter},
+		{"too short", Encode(nil), ErrTooShort},
+		{"empty", "", ErrTooShort},
+	}
+
+	for _, test := range tests {
+
+		if _, err := Decode(test.addr); !errors.Is(err, test.expected) {
+
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+}
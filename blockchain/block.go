@@ -1,8 +1,14 @@
 package blockchain
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
 
 	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
@@ -16,22 +22,39 @@ type Block struct {
 	PackedTarget    uint32
 	Miner           string
 
+	// Optional bytes miners put in the coinbase to mark their blocks, like the name of a pool (up to MaxCoinbaseTagLen bytes)
+	CoinbaseData []byte `json:",omitempty"`
+
 	MerkleRoot string
 	Txs        []transactions.LuTx
 
 	Nonce     uint32
 	Timestamp uint64
 
+	// Rolled by miners once every nonce has been tried, to get a new set of hashes
+	ExtraNonce uint64
+
+	// The bits of the soft forks the miner of the block is ready for, one bit for each fork
+	VersionBits uint32 `json:",omitempty"`
+
 	BlockHash string
 }
 
+// The most bytes a block's coinbase tag can be.
+var MaxCoinbaseTagLen = 32
+
+// The error returned when a coinbase tag is longer than MaxCoinbaseTagLen.
+var ErrCoinbaseTagTooLong = errors.New("coinbase tag is too long")
+
 // Creates a new block.
 // Only input is the mining address that will be rewarded if the block is solved.
 // Returns the newly created block.
 func (b *Blockchain) CreateBlock(blockMinerId string) Block {
 
+	tip, found := b.Tip()
+
 	// If the blockchain has not been initialized, return an empty block
-	if len(b.Blocks) == 0 {
+	if !found {
 
 		return Block{}
 	}
@@ -40,13 +63,64 @@ func (b *Blockchain) CreateBlock(blockMinerId string) Block {
 
 	// Pack in the block information
 	block.SoftwareVersion = utilities.SoftwareVersion
-	block.PrevHash = b.Blocks[b.GetHeight()].BlockHash
+	block.PrevHash = tip.BlockHash
 	block.PackedTarget = b.CalculatePackedTarget(uint(len(b.Blocks)))
 	block.Miner = blockMinerId
+	block.Timestamp = b.NextTimestamp(tip.BlockHash, new(utilities.Time).CurrentUnix())
 
 	return *block
 }
 
+// Gets the timestamp a block building on the block inputted can have, as every block has to be after the block before it.
+// Inputs are the hash of the block being built on, and the current time.
+// Returns the current time, or one second after the block being built on if the current time is not after it.
+func (b *Blockchain) NextTimestamp(prevHash string, now uint64) uint64 {
+
+	// Most blocks build on the tip, so it is checked first
+	for index := len(b.Blocks) - 1; index >= 0; index -= 1 {
+
+		if b.Blocks[index].BlockHash != prevHash {
+
+			continue
+		}
+
+		if now <= b.Blocks[index].Timestamp {
+
+			return b.Blocks[index].Timestamp + 1
+		}
+
+		break
+	}
+
+	return now
+}
+
+// Sets the tag the miner puts in the coinbase of the block.
+// Returns ErrCoinbaseTagTooLong if the tag is longer than MaxCoinbaseTagLen, leaving the block unchanged.
+func (b *Block) SetCoinbaseTag(tag []byte) error {
+
+	if len(tag) > MaxCoinbaseTagLen {
+
+		return ErrCoinbaseTagTooLong
+	}
+
+	b.CoinbaseData = append([]byte{}, tag...)
+
+	return nil
+}
+
+// Gets the tag the miner put in the coinbase of the block.
+// Returns a copy of the tag, or nil if the block has none.
+func (b *Block) CoinbaseTag() []byte {
+
+	if len(b.CoinbaseData) == 0 {
+
+		return nil
+	}
+
+	return append([]byte{}, b.CoinbaseData...)
+}
+
 // This function adds a slice of tx to the block.
 // Input is the tx slice.
 // Returns a bool, true if the tx were added, false if not.
@@ -58,13 +132,64 @@ func (b *Block) AddTx(tx transactions.LuTx) bool {
 		return false
 	}
 
-	b.Txs = append(b.Txs, tx)
+	// Insert the tx where the tx ordering rule puts it, so the block is always in order
+	index := sort.Search(len(b.Txs), func(index int) bool { return txBefore(&tx, &b.Txs[index]) })
+
+	b.Txs = append(b.Txs, transactions.LuTx{})
+	copy(b.Txs[index+1:], b.Txs[index:])
+	b.Txs[index] = tx
 
 	b.MerkleRoot = b.GetMerkleRoot()
 
 	return true
 }
 
+// Sorts txs into the order they have to be in a block.
+// The coinbase (block reward) is not a tx in Luncheon, so only the txs are ordered.
+// Txs paying the highest fee rate (fee per weight) are first, and txs paying the same fee rate are ordered by txid.
+// Returns nothing, the txs are sorted in place.
+func SortTxs(txs []transactions.LuTx) {
+
+	sort.SliceStable(txs, func(i, j int) bool { return txBefore(&txs[i], &txs[j]) })
+}
+
+// Checks that the txs of the block are in the order SortTxs puts them in.
+// A well defined order lets peers rebuild a block from the txs in their mempool.
+// Returns true if the txs are in order.
+func (b *Block) TxsOrdered() bool {
+
+	for index := 1; index < len(b.Txs); index += 1 {
+
+		if !txBefore(&b.Txs[index-1], &b.Txs[index]) {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks if the first tx inputted goes before the second in a block.
+// The fee rates are compared as a.Fee * b.Weight against b.Fee * a.Weight, as 128 bit products so they can not overflow.
+// Returns true if a goes before b.
+func txBefore(a *transactions.LuTx, b *transactions.LuTx) bool {
+
+	aHigh, aLow := bits.Mul64(a.Fee, uint64(b.GetWeight()))
+	bHigh, bLow := bits.Mul64(b.Fee, uint64(a.GetWeight()))
+
+	if aHigh != bHigh {
+
+		return aHigh > bHigh
+	}
+
+	if aLow != bLow {
+
+		return aLow > bLow
+	}
+
+	return a.HashTx() < b.HashTx()
+}
+
 // This function simply removes a tx from the block.
 // Input is the tx index.
 // Returns nothing.
@@ -79,6 +204,34 @@ func (b *Block) RemoveTx(txIndex uint) {
 	b.Txs = append(b.Txs[:txIndex], b.Txs[txIndex+1:]...)
 }
 
+// Adds up the fees of every tx in the block.
+// Returns the total fees, in LUNCHEON.
+func (b *Block) TotalFees() (fees uint64) {
+
+	for index := 0; index < len(b.Txs); index += 1 {
+
+		fees += b.Txs[index].Fee
+	}
+
+	return fees
+}
+
+// Applies the balance changes of a block to the balances inputted, so a light client can keep balances without the whole blockchain.
+// Balances are counted the same way as the ledger of the blockchain (IndexedBalance),
+// as the block rewards a key mined and the tx values it received.
+// The miner is paid the reward inputted (the coinbase), and the receiver of each tx is paid its value.
+// Rewards are added even within the maturity window, so it is up to the caller to take out the rewards of the newest RewardMaturity blocks.
+// Returns nothing, the balances are changed in place.
+func ApplyBlockToBalances(balances map[string]uint64, block *Block, reward uint64) {
+
+	balances[block.Miner] += reward
+
+	for index := 0; index < len(block.Txs); index += 1 {
+
+		balances[block.Txs[index].TxTo] += block.Txs[index].Value
+	}
+}
+
 // Calculates the weight of the block.
 // Returns a uint32 of the block weight.
 func (b *Block) GetWeight() uint {
@@ -93,6 +246,26 @@ func (b *Block) GetWeight() uint {
 	return uint(len(blockAsBytes))
 }
 
+// The weight of the fixed size parts of a block header.
+// PrevHash (32) + MerkleRoot (32) + PackedTarget (4) + Timestamp (8) + ExtraNonce (8) + Nonce (4) + BlockHash (32)
+const BlockHeaderWeight = 32 + 32 + 4 + 8 + 8 + 4 + 32
+
+// Calculates the weight of the whole block, as the header, the coinbase, and every tx.
+// The coinbase is the miner the block reward goes to, weighed as the length of its string like a tx is, and the coinbase tag.
+// The software version and the JSON encoding of the block are excluded, unlike GetWeight.
+// Returns the weight of the block.
+func (b *Block) TotalWeight() uint {
+
+	weight := uint(BlockHeaderWeight) + uint(len(b.Miner)) + uint(len(b.CoinbaseData))
+
+	for index := 0; index < len(b.Txs); index += 1 {
+
+		weight += b.Txs[index].GetWeight()
+	}
+
+	return weight
+}
+
 // Converts the block into its bytes,
 // Returns the byte slice of the block.
 func (b *Block) AsBytes() []byte {
@@ -108,6 +281,109 @@ func (b *Block) AsBytes() []byte {
 	return bAsBytes
 }
 
+// Signals that the miner of the block is ready for the soft fork of a bit.
+// Input is the bit, from 0 to 31.
+// Returns nothing.
+func (b *Block) SignalBit(bit int) {
+
+	b.VersionBits |= 1 << uint(bit)
+}
+
+// Checks if the block signals the bit inputted.
+// Returns true if the bit is set, false if not or if the bit is out of range.
+func (b *Block) Signals(bit int) bool {
+
+	if bit < 0 || bit > 31 {
+
+		return false
+	}
+
+	return b.VersionBits&(1<<uint(bit)) != 0
+}
+
+// Converts the header of the block into the bytes that are hashed when mining, except the nonce.
+// The miner appends the nonce (a little endian uint32) to these bytes to get the full hash input.
+// The layout is:
+// SoftwareVersion (string bytes) + PrevHash (32 bytes) + MerkleRoot (32 bytes, or none if there are no txs) +
+// Miner (the public key bytes, or the string bytes if it is not hex) + PackedTarget (little endian uint32) + Timestamp (little endian uint64) + ExtraNonce (little endian uint64) +
+// VersionBits (little endian uint32, or none if no bits are signaled, so blocks from before version bits hash the same) +
+// CoinbaseData (the tag bytes, or none if there is no tag, so blocks without a tag hash the same)
+// The version bits are always written when there is a tag, so the tag can not be mistaken for version bits.
+// Returns the byte slice of the header.
+func (b *Block) ParseBlockToBytes() []byte {
+
+	bytesUtil := new(utilities.ByteUtil)
+
+	prevBlockHash, _ := hex.DecodeString(b.PrevHash)
+	merkleRoot, _ := hex.DecodeString(b.MerkleRoot)
+
+	// Commits to the miner, so the block reward can not be sent to another key after the block is mined
+	miner, err := hex.DecodeString(b.Miner)
+
+	if err != nil {
+
+		miner = []byte(b.Miner)
+	}
+
+	header := []byte(b.SoftwareVersion)
+	header = append(header, prevBlockHash...)
+	header = append(header, merkleRoot...)
+	header = append(header, miner...)
+	header = append(header, bytesUtil.Uint32toB(b.PackedTarget)...)
+	header = append(header, bytesUtil.Uint64toB(b.Timestamp)...)
+	header = append(header, bytesUtil.Uint64toB(b.ExtraNonce)...)
+
+	if b.VersionBits != 0 || len(b.CoinbaseData) != 0 {
+
+		header = append(header, bytesUtil.Uint32toB(b.VersionBits)...)
+	}
+
+	// Commits to the tag, so it can not be changed after the block is mined
+	header = append(header, b.CoinbaseData...)
+
+	return header
+}
+
+// Hashes the header of the block with its nonce, the same way the miner does.
+// A block was mined correctly if this matches its BlockHash.
+// Returns the hash of the block.
+func (b *Block) CalcHash() []byte {
+
+	hash := make([]byte, 32)
+	hashHeader(hash, b.ParseBlockToBytes(), b.Nonce)
+
+	return hash
+}
+
+// Converts the whole block into a hex string, for sharing a single block.
+// Returns the hex of the block bytes.
+func (b *Block) ToHex() string {
+
+	return hex.EncodeToString(b.AsBytes())
+}
+
+// Converts a hex string made by ToHex back into a block.
+// Surrounding whitespace is ignored.
+// Returns the block, and an error if the hex or the block inside of it is malformed.
+func BlockFromHex(s string) (Block, error) {
+
+	block := new(Block)
+
+	blockBytes, err := hex.DecodeString(strings.TrimSpace(s))
+
+	if err != nil {
+
+		return Block{}, err
+	}
+
+	if err := json.Unmarshal(blockBytes, block); err != nil {
+
+		return Block{}, err
+	}
+
+	return *block, nil
+}
+
 // Prints the block as a string in JSON format.
 // Returns nothing.
 func (b *Block) PrintBlock() {
@@ -118,3 +394,75 @@ func (b *Block) PrintBlock() {
 	// Prints the json string of the block
 	fmt.Println(string(blockJson))
 }
+
+// Converts just the txs of the block into bytes, without the rest of the block.
+// Used for relaying and proving the body of a block seperatly from the header.
+// The format is the tx count as a little endian uint32, then for each tx,
+// the length of the tx as a little endian uint32 followed by the tx JSON bytes.
+// Returns the byte slice of the txs.
+func (b *Block) TxsBytes() []byte {
+
+	byteUtil := new(utilities.ByteUtil)
+
+	txsBytes := byteUtil.Uint32toB(uint32(len(b.Txs)))
+
+	for index := 0; index < len(b.Txs); index += 1 {
+
+		txBytes := b.Txs[index].AsBytes()
+
+		txsBytes = append(txsBytes, byteUtil.Uint32toB(uint32(len(txBytes)))...)
+		txsBytes = append(txsBytes, txBytes...)
+	}
+
+	return txsBytes
+}
+
+// Converts the bytes made by TxsBytes back into the txs.
+// Input is the byte slice of the txs.
+// Returns the txs, and an error if the bytes are not in the TxsBytes format.
+func ParseTxsBytes(txsBytes []byte) ([]transactions.LuTx, error) {
+
+	if len(txsBytes) < 4 {
+
+		return nil, errors.New("txs bytes are missing the tx count")
+	}
+
+	txCount := binary.LittleEndian.Uint32(txsBytes[:4])
+	txsBytes = txsBytes[4:]
+
+	txs := []transactions.LuTx{}
+
+	for index := uint32(0); index < txCount; index += 1 {
+
+		if len(txsBytes) < 4 {
+
+			return nil, errors.New("txs bytes are missing a tx length")
+		}
+
+		txLength := binary.LittleEndian.Uint32(txsBytes[:4])
+		txsBytes = txsBytes[4:]
+
+		if uint32(len(txsBytes)) < txLength {
+
+			return nil, errors.New("txs bytes are shorter than the tx length")
+		}
+
+		tx := transactions.LuTx{}
+
+		if err := json.Unmarshal(txsBytes[:txLength], &tx); err != nil {
+
+			return nil, err
+		}
+
+		txs = append(txs, tx)
+		txsBytes = txsBytes[txLength:]
+	}
+
+	// Extra bytes mean the tx count was wrong
+	if len(txsBytes) != 0 {
+
+		return nil, errors.New("txs bytes have data after the last tx")
+	}
+
+	return txs, nil
+}
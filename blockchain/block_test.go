@@ -0,0 +1,471 @@
+package blockchain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+	"golang.org/x/crypto/sha3"
+)
+
+func TestTxsBytes(t *testing.T) {
+
+	block := new(Block)
+
+	block.Txs = []transactions.LuTx{
+		{TxFrom: "aa", TxTo: "bb", Value: 100, Nonce: 0, Fee: 10, Signature: "cc"},
+		{TxFrom: "bb", TxTo: "aa", Value: 50, Nonce: 3, Fee: 20, Signature: "dd"},
+		{TxFrom: "ee", TxTo: "ff", Value: 1, Nonce: 7, Fee: 30, Script: "TXID 123 "},
+	}
+
+	txs, err := ParseTxsBytes(block.TxsBytes())
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(txs, block.Txs) {
+
+		t.Errorf("Expected %+v, got %+v", block.Txs, txs)
+	}
+}
+
+func TestTxsBytesEmpty(t *testing.T) {
+
+	block := new(Block)
+
+	txs, err := ParseTxsBytes(block.TxsBytes())
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if len(txs) != 0 {
+
+		t.Errorf("Expected no txs, got %d", len(txs))
+	}
+}
+
+func TestParseTxsBytesMalformed(t *testing.T) {
+
+	block := new(Block)
+	block.Txs = []transactions.LuTx{{TxFrom: "aa", TxTo: "bb", Value: 100}}
+
+	txsBytes := block.TxsBytes()
+
+	// Cut off, no count, and extra data on the end
+	for _, malformed := range [][]byte{txsBytes[:len(txsBytes)-1], {1, 0}, append(txsBytes, 0)} {
+
+		if _, err := ParseTxsBytes(malformed); err == nil {
+
+			t.Errorf("Expected an error parsing %x", malformed)
+		}
+	}
+}
+
+func TestParseBlockToBytesExtraNonce(t *testing.T) {
+
+	block := Block{SoftwareVersion: "v1", PrevHash: "aabb", MerkleRoot: "ccdd", PackedTarget: 0x1d0fffff, Timestamp: 100}
+
+	header := block.ParseBlockToBytes()
+
+	// Version, hashes, target, timestamp and extranonce
+	if len(header) != 2+2+2+4+8+8 {
+
+		t.Fatalf("Expected a header of %d bytes, got %d", 2+2+2+4+8+8, len(header))
+	}
+
+	if !bytes.Equal(header, block.ParseBlockToBytes()) {
+
+		t.Error("Expected the same block to always give the same bytes")
+	}
+
+	block.ExtraNonce += 1
+	rolled := block.ParseBlockToBytes()
+
+	if bytes.Equal(header, rolled) {
+
+		t.Error("Expected rolling the extranonce to change the hash input")
+	}
+
+	// Only the extranonce bytes at the end changed
+	if !bytes.Equal(header[:len(header)-8], rolled[:len(rolled)-8]) {
+
+		t.Error("Expected the extranonce to only change its own bytes")
+	}
+}
+
+func TestParseBlockToBytesLayout(t *testing.T) {
+
+	block := Block{
+		SoftwareVersion: "v1",
+		PrevHash:        "aabb",
+		MerkleRoot:      "ccdd",
+		Miner:           "04eeff",
+		PackedTarget:    0x1d0fffff,
+		Timestamp:       0x0102030405060708,
+		ExtraNonce:      9,
+		Nonce:           0x0a0b0c0d,
+	}
+
+	// SoftwareVersion + PrevHash + MerkleRoot + Miner + PackedTarget + Timestamp + ExtraNonce, all little endian
+	expected := []byte("v1")
+	expected = append(expected, 0xaa, 0xbb, 0xcc, 0xdd)
+	expected = append(expected, 0x04, 0xee, 0xff)
+	expected = append(expected, 0xff, 0xff, 0x0f, 0x1d)
+	expected = append(expected, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01)
+	expected = append(expected, 9, 0, 0, 0, 0, 0, 0, 0)
+
+	if header := block.ParseBlockToBytes(); !bytes.Equal(header, expected) {
+
+		t.Fatalf("Expected the header %x, got %x", expected, header)
+	}
+
+	// The nonce goes on the end of the header, little endian
+	hash := make([]byte, 32)
+	sha3.ShakeSum256(hash, append(expected, 0x0d, 0x0c, 0x0b, 0x0a))
+
+	if !bytes.Equal(block.CalcHash(), hash) {
+
+		t.Errorf("Expected the hash %x, got %x", hash, block.CalcHash())
+	}
+
+	// A tag goes on the end of the header, after the version bits
+	block.CoinbaseData = []byte("pool")
+	expected = append(expected, 0, 0, 0, 0)
+	expected = append(expected, "pool"...)
+
+	if header := block.ParseBlockToBytes(); !bytes.Equal(header, expected) {
+
+		t.Errorf("Expected the header with the tag %x, got %x", expected, header)
+	}
+}
+
+func TestCalcHashMiner(t *testing.T) {
+
+	block := Block{SoftwareVersion: "v1", PrevHash: "aabb", PackedTarget: 0x1d0fffff, Miner: "04aabbccdd", Timestamp: 1000}
+	hash := block.CalcHash()
+
+	// A mined block can not have its reward sent to another key without changing its hash
+	for _, miner := range []string{"04aabbccde", "", "kaimorton123"} {
+
+		changed := block
+		changed.Miner = miner
+
+		if bytes.Equal(changed.CalcHash(), hash) {
+
+			t.Errorf("Miner %q: expected changing the miner to change the hash", miner)
+		}
+	}
+}
+
+func TestTotalWeight(t *testing.T) {
+
+	block := Block{Miner: "04aabbccdd"}
+
+	if weight := block.TotalWeight(); weight != BlockHeaderWeight+10 {
+
+		t.Errorf("Expected an empty block to weigh the header and coinbase, %d, got %d", BlockHeaderWeight+10, weight)
+	}
+
+	block.Txs = []transactions.LuTx{
+		{TxFrom: "aa", TxTo: "bb", Value: 100, Fee: 10, Signature: "cc"},
+		{TxFrom: "bb", TxTo: "aa", Value: 50, Nonce: 3, Fee: 20, Signature: "dd"},
+	}
+
+	expected := uint(BlockHeaderWeight) + uint(len(block.Miner)) + block.Txs[0].GetWeight() + block.Txs[1].GetWeight()
+
+	if weight := block.TotalWeight(); weight != expected {
+
+		t.Errorf("Expected a weight of %d, got %d", expected, weight)
+	}
+}
+
+func TestBlockHexRoundTrip(t *testing.T) {
+
+	block := Block{
+		SoftwareVersion: "v1",
+		PrevHash:        "aabb",
+		PackedTarget:    0x1d0fffff,
+		Miner:           "04ccdd",
+		Txs:             []transactions.LuTx{{TxFrom: "aa", TxTo: "bb", Value: 100, Fee: 10, Signature: "cc"}},
+		Nonce:           42,
+		Timestamp:       1000,
+		ExtraNonce:      7,
+		BlockHash:       "eeff",
+	}
+	block.MerkleRoot = block.GetMerkleRoot()
+
+	decoded, err := BlockFromHex(block.ToHex())
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, block) {
+
+		t.Errorf("Expected %+v, got %+v", block, decoded)
+	}
+}
+
+func TestBlockFromHexMalformed(t *testing.T) {
+
+	block := Block{Miner: "04ccdd"}
+	blockHex := block.ToHex()
+
+	for _, malformed := range []string{"", "zz", blockHex[:len(blockHex)-1], blockHex[:len(blockHex)-2], "7b7d7d"} {
+
+		if _, err := BlockFromHex(malformed); err == nil {
+
+			t.Errorf("Expected an error decoding %q", malformed)
+		}
+	}
+}
+
+func TestApplyBlockToBalances(t *testing.T) {
+
+	reward := new(Blockchain).GetBlockReward(0)
+
+	blocks := []Block{
+		{Miner: "alice"},
+		{Miner: "bob", Txs: []transactions.LuTx{{TxFrom: "alice", TxTo: "carol", Value: reward / 2, Fee: 1000}}},
+		{Miner: "alice", Txs: []transactions.LuTx{
+			{TxFrom: "bob", TxTo: "alice", Value: reward / 4, Fee: 2000},
+			{TxFrom: "carol", TxTo: "dave", Value: reward / 8, Fee: 3000},
+		}},
+		{Miner: "carol"},
+	}
+
+	// The ledger of a blockchain the blocks are added to, which the balances have to agree with
+	bc := new(Blockchain)
+	balances := map[string]uint64{}
+
+	for height := range blocks {
+
+		ApplyBlockToBalances(balances, &blocks[height], bc.GetBlockReward(uint32(height)))
+
+		bc.AddBlock(&blocks[height])
+		bc.syncLedger()
+
+		for _, pubKey := range []string{"alice", "bob", "carol", "dave"} {
+
+			if balances[pubKey] != bc.balanceIndex[pubKey] {
+
+				t.Errorf("Height %d, %s: expected the ledger balance of %d, got %d", height, pubKey, bc.balanceIndex[pubKey], balances[pubKey])
+			}
+		}
+	}
+
+	// Rewards and received values are credited, and nothing is taken from senders
+	expected := map[string]uint64{
+		"alice": 2*reward + reward/4,
+		"bob":   reward,
+		"carol": reward + reward/2,
+		"dave":  reward / 8,
+	}
+
+	if !reflect.DeepEqual(balances, expected) {
+
+		t.Errorf("Expected %v, got %v", expected, balances)
+	}
+}
+
+func TestNextTimestamp(t *testing.T) {
+
+	bc := new(Blockchain)
+	bc.Blocks = []Block{{BlockHash: "genesis", Timestamp: 100}, {BlockHash: "tip", PrevHash: "genesis", Timestamp: 200}}
+
+	tests := []struct {
+		prevHash string
+		now      uint64
+		expected uint64
+	}{
+		{"tip", 300, 300},
+		{"tip", 200, 201},
+		{"tip", 150, 201},
+		{"genesis", 100, 101},
+		{"unknown", 50, 50},
+	}
+
+	for index, test := range tests {
+
+		if timestamp := bc.NextTimestamp(test.prevHash, test.now); timestamp != test.expected {
+
+			t.Errorf("Test %d: expected %d, got %d", index, test.expected, timestamp)
+		}
+	}
+
+	// A tip from the future, like one a clock ahead of this one mined
+	bc.Blocks[1].Timestamp = uint64(time.Now().Unix()) + 3600
+
+	if block := bc.CreateBlock("miner"); block.Timestamp != bc.Blocks[1].Timestamp+1 {
+
+		t.Errorf("Expected the new block to be a second after the tip, got %d", block.Timestamp)
+	}
+
+	if template := bc.NewBlockTemplate("miner", nil); template.Timestamp != bc.Blocks[1].Timestamp+1 {
+
+		t.Errorf("Expected the template to be a second after the tip, got %d", template.Timestamp)
+	}
+}
+
+func TestBlockTemplate(t *testing.T) {
+
+	bc := new(Blockchain)
+	bc.Blocks = []Block{{BlockHash: "tip", PackedTarget: 0x1c0fffff}}
+
+	txs := []transactions.LuTx{{TxFrom: "alice", TxTo: "bob", Value: 2000, Fee: 500}, {TxFrom: "bob", TxTo: "alice", Value: 1000, Fee: 700}}
+	template := bc.NewBlockTemplate("miner", txs)
+
+	// The ledger only credits the block reward to the miner, not the fees
+	if template.CoinbaseValue != bc.GetBlockReward(1) || len(template.Txs) != 2 {
+
+		t.Errorf("Expected a coinbase value of only the reward %d, got %d", bc.GetBlockReward(1), template.CoinbaseValue)
+	}
+
+	template.ExtraNonce = 7
+	template.VersionBits = 0b101
+
+	// The fields in the header have to carry over, or the block hashes differently than the miner solved it
+	block := template.Block()
+
+	if block.ExtraNonce != 7 || block.VersionBits != 0b101 || block.Timestamp != template.Timestamp {
+
+		t.Errorf("Expected the block to have the extranonce and version bits of the template, got %+v", block)
+	}
+}
+
+func TestSortTxs(t *testing.T) {
+
+	txs := []transactions.LuTx{
+		{TxFrom: "alice", TxTo: "bob", Value: 100, Fee: 1000},
+		{TxFrom: "alice", TxTo: "bob", Value: 200, Fee: 5000},
+		{TxFrom: "carol", TxTo: "bob", Value: 300, Fee: 1000},
+		{TxFrom: "dave", TxTo: "bob", Value: 400, Fee: 3000},
+	}
+
+	block := Block{}
+
+	// Added out of order, AddTx keeps the block in order
+	for index := range txs {
+
+		block.AddTx(txs[index])
+	}
+
+	SortTxs(txs)
+
+	if !reflect.DeepEqual(block.Txs, txs) {
+
+		t.Errorf("Expected AddTx to put the txs in the same order as SortTxs, got %+v", block.Txs)
+	}
+
+	if !block.TxsOrdered() {
+
+		t.Error("Expected the sorted txs to be in order")
+	}
+
+	// Highest fee rate first
+	if txs[0].Fee != 5000 || txs[1].Fee != 3000 {
+
+		t.Errorf("Expected the highest fee rates first, got %+v", txs)
+	}
+
+	// The same fee rate is ordered by txid
+	if txs[2].HashTx() > txs[3].HashTx() {
+
+		t.Error("Expected txs with the same fee rate to be ordered by txid")
+	}
+
+	// Scrambled
+	block.Txs[0], block.Txs[3] = block.Txs[3], block.Txs[0]
+
+	if block.TxsOrdered() {
+
+		t.Error("Expected the scrambled txs to be out of order")
+	}
+
+	// The same tx twice has no order
+	if (&Block{Txs: []transactions.LuTx{txs[0], txs[0]}}).TxsOrdered() {
+
+		t.Error("Expected a duplicate tx to be out of order")
+	}
+}
+
+func TestCoinbaseTag(t *testing.T) {
+
+	block := Block{Miner: "04ccdd"}
+
+	if block.CoinbaseTag() != nil {
+
+		t.Error("Expected a block without a tag to have no tag")
+	}
+
+	if err := block.SetCoinbaseTag([]byte("LuncheonPool/1")); err != nil {
+
+		t.Fatal(err)
+	}
+
+	decoded, err := BlockFromHex(block.ToHex())
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded.CoinbaseTag(), []byte("LuncheonPool/1")) {
+
+		t.Errorf("Expected the tag to survive serialization, got %q", decoded.CoinbaseTag())
+	}
+
+	if block.TotalWeight() != (&Block{Miner: "04ccdd"}).TotalWeight()+14 {
+
+		t.Error("Expected the tag to add to the weight of the block")
+	}
+
+	// Too long, the old tag is kept
+	if err := block.SetCoinbaseTag(make([]byte, MaxCoinbaseTagLen+1)); err != ErrCoinbaseTagTooLong {
+
+		t.Errorf("Expected ErrCoinbaseTagTooLong, got %v", err)
+	}
+
+	if !bytes.Equal(block.CoinbaseTag(), []byte("LuncheonPool/1")) {
+
+		t.Error("Expected a tag that is too long to leave the block unchanged")
+	}
+
+	if err := block.SetCoinbaseTag(make([]byte, MaxCoinbaseTagLen)); err != nil {
+
+		t.Errorf("Expected a tag of MaxCoinbaseTagLen to be allowed, got %v", err)
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+
+	block := Block{SoftwareVersion: "v1", PrevHash: "aabb", MerkleRoot: "ccdd", PackedTarget: 0x1d0fffff, Timestamp: 100}
+	header := block.ParseBlockToBytes()
+
+	block.SignalBit(3)
+
+	if !block.Signals(3) || block.Signals(4) || block.Signals(-1) || block.Signals(32) {
+
+		t.Errorf("Expected only bit 3 to be signaled, got %032b", block.VersionBits)
+	}
+
+	// The bits are hashed, so they can not be changed after the block is mined
+	if signaled := block.ParseBlockToBytes(); !bytes.Equal(signaled[:len(header)], header) || len(signaled) != len(header)+4 {
+
+		t.Error("Expected the version bits to be added to the end of the header")
+	}
+
+	decoded, err := BlockFromHex(block.ToHex())
+
+	if err != nil || !decoded.Signals(3) {
+
+		t.Errorf("Expected the version bits to survive serialization, got %+v (%v)", decoded, err)
+	}
+}
@@ -1,42 +1,115 @@
 package blockchain
 
 import (
-	"encoding/binary"
+	"bytes"
+	"context"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
 	"os"
+	"path/filepath"
+	"sort"
+	"time"
 
-	"github.com/GoblinBear/beson/types"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/ellip"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
+	"github.com/TwiN/go-color"
 )
 
+// The prev hash of the genisis block, as it has no block before it.
+// Only the genisis block can have it, so no other block can pass itself off as the start of a blockchain.
+const GenesisPrevHash = "CoolGenisisBLock"
+
 // The blockchain struct that will be the chain of blocks.
 type Blockchain struct {
 	Blocks []Block
 
-	height uint
+	params *ChainParams
+
+	// The funcs called when blocks are added to or removed from the tip
+	connectHooks    []*BlockHook
+	disconnectHooks []*BlockHook
+
+	// The heights of the blocks each txid is in, for the first indexedBlocks blocks
+	txHeights     map[string]uint
+	indexedBlocks int
+
+	// The hashes of the blocks that were proven invalid
+	invalidBlocks map[string]bool
+
+	// The total fees of the txs in the first feeBlocks blocks
+	totalFees uint64
+	feeBlocks int
+
+	// The block rewards and tx values received, and the txs sent, by each public key in the first ledgerBlocks blocks
+	balanceIndex map[string]uint64
+	nonceIndex   map[string]uint32
+	ledgerBlocks int
+
+	// The store the full blocks are kept in, and the height the txs of the blocks below are only in the store
+	store       *BlockStore
+	prunedBelow uint
+
+	// The amount of blocks that lost a reorg, and were rolled back off the tip
+	staleBlocks uint
+
+	// The format the blockchain is saved and loaded in
+	format PersistFormat
 }
 
+// A func that is called with a block and its height, when the block is added to or removed from the blockchain.
+type BlockHook func(block Block, height uint)
+
 // 1,000,000 aka one MegaByte, just a little bigger as some values are excluded from the weight factoring
 var MaxWeight uint = 1000000
 
-// Inits the blockchain struct, including defining constants.
+// The amount of blocks a miner has to wait before their block reward can be spent
+var RewardMaturity uint = 10
+
+// The time the network aims to take to mine each block
+var TargetBlockTime = time.Minute
+
+// The amount of blocks between each halving of the block reward, once a year if block time is 1 minute
+var HalvingInterval uint32 = 525600
+
+// The most LUNCHEON that can ever be issued by block rewards, 208,597,500 LNCH
+var MaxSupply uint64 = 208597500 * utilities.LuncheonPerLNCH
+
+// The folder the blockchains of every network are saved in, each network having its own folder inside of it
+var DataDir = "saves"
+
+// The error returned when more coins have been issued than MaxSupply allows.
+var ErrSupplyExceeded = errors.New("issued supply exceeds the max supply")
+
+// Inits the blockchain struct on the mainnet, including defining constants.
 // Creates the genisis block.
 // Returns if any errors occured.
 func InitBlockchain() Blockchain {
 
+	return InitBlockchainWithParams(MainnetParams)
+}
+
+// Inits the blockchain struct with the params of a network, including defining constants.
+// Creates the genisis block.
+// Returns the new blockchain.
+func InitBlockchainWithParams(params ChainParams) Blockchain {
+
 	// Create a blockchain instance
 	b := new(Blockchain)
 
-	b.height = 0
+	b.SetParams(params)
 
 	// Create the genisis block:
 	genisisB := new(Block)
 
 	// Manually sets the variables of the genisis block
 	genisisB.SoftwareVersion = utilities.SoftwareVersion
-	genisisB.PrevHash = "CoolGenisisBLock"
-	genisisB.PackedTarget = 0x1d0fffff
+	genisisB.PrevHash = GenesisPrevHash
+	genisisB.PackedTarget = params.GenesisTarget
 
 	// Get the main public key ready
 	mainKeys := new(ellip.MainKey)
@@ -57,33 +130,227 @@ func InitBlockchain() Blockchain {
 // This means every 525600 blocks, the reward halves.
 // The current code also makes it so the blockchain rewards besides tx fees
 // Will fully dry-up in 7 years, the first block of year 8 will have zero reward.
-// The total amount of coins that can exist is 208,663,200, which means 10 of these coins
+// The total amount of coins that can exist is 208,597,500, which means 10 of these coins
 // can be considered as rare, in terms of total in existance, as 1 btc.
 func (b *Blockchain) GetBlockReward(height uint32) uint64 {
 
-	halvings := height / 525600
+	halvings := height / HalvingInterval
+
+	// If the reward has dried up (this also stops the shift below from overflowing)
+	if halvings >= 7 {
+
+		return 0
+	}
+
+	// The halving is done in LUNCHEON, so the rewards below 1 LNCH (12.5, 6.25, 3.125) are not cut off
+	return (200 * utilities.LuncheonPerLNCH) >> halvings
+}
+
+// Calculates the average amount of txs in the newest blocks of the blockchain.
+// The coinbase (block reward) is not a tx in Luncheon, so it is never counted.
+// Input is the amount of blocks from the tip to average over, which is cut to the length of the blockchain.
+// Returns the average txs per block, or 0 if there are no blocks to average.
+func (b *Blockchain) AverageTxsPerBlock(window uint) float64 {
+
+	if window > uint(len(b.Blocks)) {
+
+		window = uint(len(b.Blocks))
+	}
+
+	if window == 0 {
+
+		return 0
+	}
+
+	txCount := 0
+
+	for index := len(b.Blocks) - int(window); index < len(b.Blocks); index += 1 {
+
+		txCount += len(b.fullBlock(uint(index)).Txs)
+	}
+
+	return float64(txCount) / float64(window)
+}
+
+// The amount of blocks from the tip DiskGrowthPerDay averages the block size over
+var DiskGrowthWindow uint = 1000
+
+// Estimates how many bytes the saved blockchain grows by each day, for planning disk space.
+// The average size of the newest blocks, as the JSON they are saved in by default, is multiplied by the blocks mined in a day at TargetBlockTime.
+// Returns the estimated bytes per day, or 0 if there are no blocks to average.
+func (b *Blockchain) DiskGrowthPerDay() int64 {
+
+	window := DiskGrowthWindow
+
+	if window > uint(len(b.Blocks)) {
+
+		window = uint(len(b.Blocks))
+	}
+
+	if window == 0 || TargetBlockTime <= 0 {
+
+		return 0
+	}
+
+	totalBytes := 0
+
+	for index := len(b.Blocks) - int(window); index < len(b.Blocks); index += 1 {
+
+		block := b.fullBlock(uint(index))
+		totalBytes += len(block.AsBytes())
+	}
+
+	averageSize := float64(totalBytes) / float64(window)
+	blocksPerDay := float64(24*time.Hour) / float64(TargetBlockTime)
+
+	return int64(averageSize * blocksPerDay)
+}
+
+// The amount of blocks AverageBlockTime looks back over for EstimateConfirmTime
+var ConfirmTimeWindow uint = 100
+
+// Something holding txs waiting for a block, like the mempool.
+type FeeBacklog interface {
+
+	// The total weight of the txs paying a fee rate of at least feePerWeight, which would be mined first
+	WeightAtFeeRate(feePerWeight uint64) uint
+}
+
+// Calculates the average time between the newest blocks of the blockchain, from their timestamps.
+// Input is the amount of block times from the tip to average over, which is cut to the length of the blockchain.
+// Returns the average block time, or TargetBlockTime if there are not enough blocks to average.
+func (b *Blockchain) AverageBlockTime(window uint) time.Duration {
+
+	// There has to be a block before the window to measure the first block time from
+	if len(b.Blocks) < 2 || window == 0 {
+
+		return TargetBlockTime
+	}
+
+	if window > uint(len(b.Blocks)-1) {
+
+		window = uint(len(b.Blocks) - 1)
+	}
+
+	newest := b.Blocks[len(b.Blocks)-1].Timestamp
+	oldest := b.Blocks[len(b.Blocks)-1-int(window)].Timestamp
+
+	// If the timestamps do not go forward, they can not be averaged
+	if newest <= oldest {
+
+		return TargetBlockTime
+	}
+
+	return time.Duration(newest-oldest) * time.Second / time.Duration(window)
+}
+
+// Estimates how long a tx paying a fee rate will take to be mined.
+// Every tx paying at least the same fee rate is mined first, filling blocks of MaxWeight, and the tx is then in the next block.
+// Inputs are the fee rate in LUNCHEON per weight, and the txs waiting to be mined (like the mempool).
+// Returns the estimated time until the tx is in a block.
+func (b *Blockchain) EstimateConfirmTime(feePerWeight uint64, pool FeeBacklog) time.Duration {
+
+	blocks := pool.WeightAtFeeRate(feePerWeight)/MaxWeight + 1
+
+	return time.Duration(blocks) * b.AverageBlockTime(ConfirmTimeWindow)
+}
+
+// Calculates how much of the newest blocks signal a soft fork bit, to see how close the soft fork is to being ready.
+// Inputs are the bit of the soft fork, and the amount of blocks from the tip to count over, which is cut to the length of the blockchain.
+// Returns the fraction of the blocks signaling the bit, from 0 to 1, or 0 if there are no blocks to count.
+func (b *Blockchain) SignalPercent(bit int, window uint) float64 {
+
+	if window > uint(len(b.Blocks)) {
+
+		window = uint(len(b.Blocks))
+	}
+
+	if window == 0 {
+
+		return 0
+	}
+
+	signaling := 0
+
+	for index := len(b.Blocks) - int(window); index < len(b.Blocks); index += 1 {
+
+		if b.Blocks[index].Signals(bit) {
+
+			signaling += 1
+		}
+	}
+
+	return float64(signaling) / float64(window)
+}
+
+// Calculates the block rewards left to be issued before the next halving.
+// Counts from the next block to be mined, up to (not including) the first block of the next halving.
+// Returns the total reward in LUNCHEON.
+func (b *Blockchain) CoinsUntilHalving() uint64 {
+
+	nextHeight := uint32(len(b.Blocks))
+	halvingHeight := (nextHeight/HalvingInterval + 1) * HalvingInterval
+
+	// Every block in a halving has the same reward
+	return b.GetBlockReward(nextHeight) * uint64(halvingHeight-nextHeight)
+}
 
-	// If no halvings have happened
-	if halvings == 0 {
+// Checks that the block rewards issued by the blockchain never go over MaxSupply, at any height.
+// A safety net for bugs in the reward schedule.
+// Returns nil if the supply is within the cap, or an ErrSupplyExceeded with the height it was first exceeded at.
+func (b *Blockchain) ValidateSupply() error {
 
-		// The default block reward (the * 1000000 is to convert LNCH to LUNCHEON)
-		return 200 * 1000000
+	var supply uint64
+
+	for index := 0; index < len(b.Blocks); index += 1 {
+
+		reward := b.GetBlockReward(uint32(index))
+
+		// If adding the reward would overflow, it is already past any cap
+		if supply+reward < supply || supply+reward > MaxSupply {
+
+			return fmt.Errorf("%w: at height %d", ErrSupplyExceeded, index)
+		}
+
+		supply += reward
 	}
 
-	// If 1 or more halvings have happened
-	// The << operator here acts as an easy way to do "to the power of" or **
-	// Does not work in substitute for 2**0
-	// Also the * 1 million is to covert LNCH to LUNCHEON
-	return (200 / (2 << (halvings - 1))) * 1000000
+	return nil
+}
+
+// Gets the amount of blocks on the blockchain.
+// Check this before GetHeight, as an empty blockchain and a blockchain of only the genisis block are both at height 0.
+// Returns the amount of blocks.
+func (b *Blockchain) Len() uint {
+
+	return uint(len(b.Blocks))
 }
 
-// Updates and returns the height of the blockchain.
+// Gets the height of the blockchain.
+// An empty blockchain (like a Blockchain{} not made by InitBlockchain) has no height, so 0 is returned, check Len to tell it apart.
+// Only reads the blockchain, so it can be called by many goroutines at once, like the handlers of the node.
 // Returns a uint32 of the blockchain height.
 func (b *Blockchain) GetHeight() uint {
 
-	b.height = uint(len(b.Blocks) - 1)
+	if len(b.Blocks) == 0 {
+
+		return 0
+	}
 
-	return b.height
+	return uint(len(b.Blocks) - 1)
+}
+
+// Gets the newest block of the blockchain.
+// The block is not copied, so changing it changes the blockchain.
+// Returns a pointer to the block, and false if the blockchain is empty.
+func (b *Blockchain) Tip() (*Block, bool) {
+
+	if len(b.Blocks) == 0 {
+
+		return nil, false
+	}
+
+	return &b.Blocks[len(b.Blocks)-1], true
 }
 
 // This function adds a block to the blockchain.
@@ -91,112 +358,832 @@ func (b *Blockchain) GetHeight() uint {
 func (b *Blockchain) AddBlock(block *Block) {
 
 	b.Blocks = append(b.Blocks, *block)
+
+	height := b.GetHeight()
+
+	b.storeBlock(block)
+
+	// Keep the fee total up to date, if it was before the block
+	if b.feeBlocks == len(b.Blocks)-1 {
+
+		b.totalFees += block.TotalFees()
+		b.feeBlocks = len(b.Blocks)
+	}
+
+	// Keep the ledger up to date, if it was before the block
+	if b.balanceIndex != nil && b.ledgerBlocks == len(b.Blocks)-1 {
+
+		b.ledgerAdd(block, height)
+		b.ledgerBlocks = len(b.Blocks)
+	}
+
+	for index := 0; index < len(b.connectHooks); index += 1 {
+
+		(*b.connectHooks[index])(*block, height)
+	}
 }
 
 // This function removes the last block from the blockchain.
 // Returns nothing.
 func (b *Blockchain) RemoveBlock() {
 
-	b.Blocks = append(b.Blocks[:b.GetHeight()], b.Blocks[b.GetHeight()+1:]...)
+	// If there is no block to remove
+	if len(b.Blocks) == 0 {
+
+		return
+	}
+
+	height := b.GetHeight()
+
+	// The full block, with its txs read before the block store forgets them
+	block := b.fullBlock(height)
+
+	// Take the removed block out of the ledger
+	if b.ledgerBlocks > int(height) {
+
+		b.ledgerRemove(&block, height)
+		b.ledgerBlocks = int(height)
+	}
+
+	b.Blocks = append(b.Blocks[:height], b.Blocks[height+1:]...)
+
+	b.unstoreBlocks()
+
+	// Take the txs of the removed block out of the tx index
+	if b.indexedBlocks > len(b.Blocks) {
+
+		for index := 0; index < len(block.Txs); index += 1 {
+
+			if txHeight, found := b.txHeights[block.Txs[index].HashTx()]; found && txHeight == height {
+
+				delete(b.txHeights, block.Txs[index].HashTx())
+			}
+		}
+
+		b.indexedBlocks = len(b.Blocks)
+	}
+
+	// Take the fees of the removed block out of the fee total
+	if b.feeBlocks > len(b.Blocks) {
+
+		b.totalFees -= block.TotalFees()
+		b.feeBlocks = len(b.Blocks)
+	}
+
+	for index := 0; index < len(b.disconnectHooks); index += 1 {
+
+		(*b.disconnectHooks[index])(block, height)
+	}
+}
+
+// Removes every block above the height inputted, starting from the tip.
+// Used in a reorg, to go back to the block the two chains have in common.
+// The removed blocks lost the reorg, so they are counted as stale blocks.
+// Returns nothing.
+func (b *Blockchain) RollbackTo(height uint) {
+
+	b.staleBlocks += b.truncateTo(height)
+}
+
+// Removes every block above the height inputted, starting from the tip.
+// Returns the amount of blocks removed.
+func (b *Blockchain) truncateTo(height uint) (removed uint) {
+
+	for b.Len() != 0 && b.GetHeight() > height {
+
+		b.RemoveBlock()
+		removed += 1
+	}
+
+	return removed
+}
+
+// Gets the amount of blocks that were on the blockchain, but lost a reorg and were rolled back off of it (orphaned blocks).
+// Invalid blocks removed by VerifyAndRepair are not stale, so they are not counted.
+// Returns the amount of stale blocks seen.
+func (b *Blockchain) StaleBlockCount() uint {
+
+	return b.staleBlocks
+}
+
+// Finds the last block two blockchains have in common, like the block to roll back to in a reorg.
+// Walks back from both tips, following the prev hash of each block, until both chains are on the same block.
+// Returns the height and hash of the common block, and false if the chains have nothing in common (like a different genisis block).
+func CommonAncestor(a, b *Blockchain) (height uint, hash string, ok bool) {
+
+	aIndex := len(a.Blocks) - 1
+	bIndex := len(b.Blocks) - 1
+
+	for aIndex >= 0 && bIndex >= 0 {
+
+		// Both chains have the same block at this height
+		if aIndex == bIndex && a.Blocks[aIndex].BlockHash == b.Blocks[bIndex].BlockHash {
+
+			return uint(aIndex), a.Blocks[aIndex].BlockHash, true
+		}
+
+		// Step back on the taller chain, or on both if they are the same height
+		stepA := aIndex >= bIndex
+		stepB := bIndex >= aIndex
+
+		if stepA {
+
+			if !linkedToPrev(a, aIndex) {
+
+				return 0, "", false
+			}
+
+			aIndex -= 1
+		}
+
+		if stepB {
+
+			if !linkedToPrev(b, bIndex) {
+
+				return 0, "", false
+			}
+
+			bIndex -= 1
+		}
+	}
+
+	return 0, "", false
+}
+
+// Checks that the block at the index points to the block before it, so a walk back follows the hash linkage.
+// The genisis block has no block before it, so it is always linked.
+// Returns true if the block is linked to the block before it.
+func linkedToPrev(bc *Blockchain, index int) bool {
+
+	return index == 0 || bc.Blocks[index].PrevHash == bc.Blocks[index-1].BlockHash
+}
+
+// Registers a func to be called every time a block is added to the blockchain.
+// Returns a func that unregisters the hook, for when whatever registered it is no longer used.
+func (b *Blockchain) OnConnect(hook BlockHook) func() {
+
+	registered := &hook
+	b.connectHooks = append(b.connectHooks, registered)
+
+	return func() { b.connectHooks = removeHook(b.connectHooks, registered) }
+}
+
+// Registers a func to be called every time a block is removed from the blockchain, like in a reorg.
+// Returns a func that unregisters the hook, for when whatever registered it is no longer used.
+func (b *Blockchain) OnDisconnect(hook BlockHook) func() {
+
+	registered := &hook
+	b.disconnectHooks = append(b.disconnectHooks, registered)
+
+	return func() { b.disconnectHooks = removeHook(b.disconnectHooks, registered) }
+}
+
+// Removes a registered hook from the hooks inputted.
+// The hooks are copied, so a hook can unregister itself while the hooks are being called.
+// Returns the hooks without the hook, or the hooks inputted if it was already removed.
+func removeHook(hooks []*BlockHook, hook *BlockHook) []*BlockHook {
+
+	for index := 0; index < len(hooks); index += 1 {
+
+		if hooks[index] == hook {
+
+			removed := make([]*BlockHook, 0, len(hooks)-1)
+			removed = append(removed, hooks[:index]...)
+
+			return append(removed, hooks[index+1:]...)
+		}
+	}
+
+	return hooks
 }
 
 // This function gets a block at a specified index.
+// Blocks whose txs were pruned from memory are read from the block store.
 // Returns the block and true if this was successful.
 // If the index is invalid, it will return a empty block and false.
 func (b *Blockchain) GetBlock(blockNum uint) (Block, bool) {
 
-	if blockNum > b.GetHeight() {
+	if blockNum >= b.Len() {
 
 		return Block{}, false
 	}
 
+	if blockNum < b.prunedBelow {
+
+		block, err := b.store.Get(blockNum)
+
+		return block, err == nil
+	}
+
 	return b.Blocks[blockNum], true
 }
 
+// Calculates the total fees ever paid by the txs on the blockchain.
+// The block rewards are not fees, so they are not counted.
+// The total is kept as blocks are added and removed, so only blocks changed without AddBlock or RemoveBlock are scanned.
+// Returns the total fees, in LUNCHEON.
+func (b *Blockchain) TotalFeesCollected() uint64 {
+
+	// If the blocks were changed without RemoveBlock
+	if b.feeBlocks > len(b.Blocks) {
+
+		b.totalFees = 0
+		b.feeBlocks = 0
+	}
+
+	for ; b.feeBlocks < len(b.Blocks); b.feeBlocks += 1 {
+
+		block := b.fullBlock(uint(b.feeBlocks))
+		b.totalFees += block.TotalFees()
+	}
+
+	return b.totalFees
+}
+
+// Splits what the miner of a block was paid into the block subsidy (the new coins of the block reward) and the fees of its txs.
+// Input is the height of the block.
+// Returns the subsidy and the fees, in LUNCHEON, or 0 and 0 if there is no block at the height.
+func (b *Blockchain) BlockRewardBreakdown(height uint) (subsidy, fees uint64) {
+
+	// GetBlock so the txs of pruned blocks are read from the block store
+	block, found := b.GetBlock(height)
+
+	if !found {
+
+		return 0, 0
+	}
+
+	return b.GetBlockReward(uint32(height)), block.TotalFees()
+}
+
+// The balance of one address, like in a rich list.
+type AddressBalance struct {
+	Address string
+	Balance uint64
+}
+
+// Gets the available balance of every address on the blockchain from the ledger, and ranks them from largest to smallest.
+// Each balance is the same as IndexedBalance, so rewards within the maturity window are left out.
+// Addresses with the same balance are ranked by address, so the list is always in the same order.
+// Addresses with no balance are left out.
+// Input is the amount of addresses to return.
+// Returns the n largest balances, or every balance if there are less than n addresses.
+func (b *Blockchain) TopBalances(n int) []AddressBalance {
+
+	if n <= 0 || len(b.Blocks) == 0 {
+
+		return nil
+	}
+
+	b.syncLedger()
+
+	balances := make(map[string]uint64, len(b.balanceIndex))
+
+	for address, balance := range b.balanceIndex {
+
+		balances[address] = balance
+	}
+
+	// The rewards within the maturity window can not be spent yet
+	for height := b.immatureHeight(); height < uint(len(b.Blocks)); height += 1 {
+
+		miner := b.Blocks[height].Miner
+
+		// Never below zero, the same as IndexedBalance
+		if reward := b.GetBlockReward(uint32(height)); reward < balances[miner] {
+
+			balances[miner] -= reward
+		} else {
+
+			balances[miner] = 0
+		}
+	}
+
+	ranked := make([]AddressBalance, 0, len(balances))
+
+	for address, balance := range balances {
+
+		if balance > 0 {
+
+			ranked = append(ranked, AddressBalance{Address: address, Balance: balance})
+		}
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+
+		if ranked[i].Balance != ranked[j].Balance {
+
+			return ranked[i].Balance > ranked[j].Balance
+		}
+
+		return ranked[i].Address < ranked[j].Address
+	})
+
+	if len(ranked) > n {
+
+		ranked = ranked[:n]
+	}
+
+	return ranked
+}
+
+// Brings the tx index up to date with the blocks of the blockchain.
+// Blocks are indexed the first time a tx is looked up after they are added.
+// Returns nothing.
+func (b *Blockchain) syncTxIndex() {
+
+	// If the index has not been made, or the blocks were changed without RemoveBlock
+	if b.txHeights == nil || b.indexedBlocks > len(b.Blocks) {
+
+		b.txHeights = make(map[string]uint)
+		b.indexedBlocks = 0
+	}
+
+	for ; b.indexedBlocks < len(b.Blocks); b.indexedBlocks += 1 {
+
+		block := b.fullBlock(uint(b.indexedBlocks))
+
+		for txIndex := 0; txIndex < len(block.Txs); txIndex += 1 {
+
+			txid := block.Txs[txIndex].HashTx()
+
+			// Only the first block a tx is in counts
+			if _, found := b.txHeights[txid]; !found {
+
+				b.txHeights[txid] = uint(b.indexedBlocks)
+			}
+		}
+	}
+}
+
+// Finds a confirmed tx on the blockchain by its txid, using the tx index.
+// Returns the tx, the height of the block it is in, and true if it was found.
+func (b *Blockchain) findTx(txid string) (transactions.LuTx, uint, bool) {
+
+	b.syncTxIndex()
+
+	height, found := b.txHeights[txid]
+
+	if !found {
+
+		return transactions.LuTx{}, 0, false
+	}
+
+	block := b.fullBlock(height)
+
+	for txIndex := 0; txIndex < len(block.Txs); txIndex += 1 {
+
+		if block.Txs[txIndex].HashTx() == txid {
+
+			return block.Txs[txIndex], height, true
+		}
+	}
+
+	return transactions.LuTx{}, 0, false
+}
+
+// Gets the block that a confirmed tx is in.
+// Input is the txid of the tx.
+// Returns the block, its height, and true if the tx was found on the blockchain.
+func (b *Blockchain) GetBlockByTxid(txid string) (Block, uint, bool) {
+
+	_, height, found := b.findTx(txid)
+
+	if !found {
+
+		return Block{}, 0, false
+	}
+
+	return b.fullBlock(height), height, true
+}
+
+// Gets the fee rate of a confirmed tx, in LUNCHEON per weight.
+// Input is the txid of the tx.
+// Returns the fee rate, and true if the tx was found on the blockchain.
+func (b *Blockchain) TxFeeRate(txid string) (float64, bool) {
+
+	tx, _, found := b.findTx(txid)
+
+	if !found {
+
+		return 0, false
+	}
+
+	return float64(tx.Fee) / float64(tx.GetWeight()), true
+}
+
+// Makes a deep copy of the blockchain, so the copy can be changed (like in a reorg) without changing the original.
+// The hooks of the blockchain are not copied, as they belong to the original.
+// Returns the copy.
+func (b *Blockchain) Copy() *Blockchain {
+
+	chainCopy := new(Blockchain)
+
+	chainCopy.Blocks = make([]Block, len(b.Blocks))
+
+	// The copy has no block store, so pruned blocks are copied with their txs from the store
+	for index := 0; index < len(chainCopy.Blocks); index += 1 {
+
+		chainCopy.Blocks[index] = b.fullBlock(uint(index))
+
+		// The txs of each block are their own slice, so they need copying as well
+		if chainCopy.Blocks[index].Txs != nil {
+
+			txs := chainCopy.Blocks[index].Txs
+			chainCopy.Blocks[index].Txs = make([]transactions.LuTx, len(txs))
+			copy(chainCopy.Blocks[index].Txs, txs)
+		}
+
+		// So is the coinbase tag
+		if chainCopy.Blocks[index].CoinbaseData != nil {
+
+			chainCopy.Blocks[index].CoinbaseData = append([]byte{}, chainCopy.Blocks[index].CoinbaseData...)
+		}
+	}
+
+	if b.params != nil {
+
+		chainCopy.SetParams(b.params.Copy())
+	}
+
+	chainCopy.staleBlocks = b.staleBlocks
+
+	return chainCopy
+}
+
 // Calculates the packed target of a block.
 // Expects what the block number will be, not what the current highest block is.
 // So if this is used to see what the target of a new block will be, input what block height it will be.
-// Returns the packed target of the block.
+// Until the first full retarget window, every block uses the target of the genisis block.
+// A target of 0 can not be mined, so if the block the target is taken from has none, the genesis target of the params is used instead.
+// Returns the packed target of the block, or 0 if the blockchain does not have the block before it.
 func (b *Blockchain) CalculatePackedTarget(blockNumber uint) uint32 {
 
+	params := b.Params()
+
+	// The genisis block has nothing before it to take a target from
+	if blockNumber == 0 {
+
+		return params.GenesisTarget
+	}
+
 	if blockNumber > uint(len(b.Blocks)) {
 
 		return 0
 	}
 
-	// If block time is 1 minute, this will happen once a week
-	if blockNumber%10080 == 0 {
+	interval := params.RetargetInterval
 
-		unPacker := new(utilities.TargetUnpacker)
-		packer := new(utilities.TargetPacker)
-		byteUtil := new(utilities.ByteUtil)
-		time := b.Blocks[blockNumber-1].Timestamp - b.Blocks[blockNumber-10080].Timestamp
+	// Before the first full window there is nothing to retarget from
+	if interval == 0 || blockNumber < interval {
+
+		return nonZeroTarget(b.Blocks[0].PackedTarget, params.GenesisTarget)
+	}
 
-		newMultiplier := (10080 * 60) / time // The *60 converts to seconds
+	// Retargets once every interval, from the window of blocks before it
+	if blockNumber%interval == 0 {
 
-		// Convert this to a uint256
-		bigNewMultiplier := *types.NewUInt256("0", 1)
-		bigNewMultiplier.Set(byteUtil.Uint64toB(newMultiplier))
+		expectedTime := uint64(interval) * uint64(TargetBlockTime/time.Second)
+		time := b.Blocks[blockNumber-1].Timestamp - b.Blocks[blockNumber-interval].Timestamp
 
-		// Convert the current target to uint256
-		target := unPacker.Unpack(b.Blocks[blockNumber-1].PackedTarget)
+		// Bound the time, so the target can change by at most RetargetClampFactor times in one retarget
+		if params.RetargetClampFactor != 0 {
 
-		// Apply the multiplier to the current target to get the new target
-		newTarget := target.Multiply(&bigNewMultiplier)
-		maxTarget := unPacker.Unpack(0x1d0fffff)
+			if minTime := expectedTime / params.RetargetClampFactor; time < minTime {
 
-		// If the target is larger than the max allowed target
-		if newTarget.Compare(&maxTarget) == 1 {
+				time = minTime
+			}
 
-			return 0x1d0fffff
+			if maxTime := expectedTime * params.RetargetClampFactor; time > maxTime {
+
+				time = maxTime
+			}
+		}
+
+		// Stops a divide by zero if every block in the window has the same timestamp
+		if time == 0 {
+
+			time = 1
 		}
 
-		return packer.PackTargetUint256(*newTarget)
+		// Scale the current target by the time the window took over the expected time,
+		// so a window mined too fast gives a smaller (harder) target, and a slow window a larger (easier) one
+		return nonZeroTarget(scaleTarget(b.Blocks[blockNumber-1].PackedTarget, time, expectedTime, params.GenesisTarget), params.GenesisTarget)
 	}
 
-	return b.Blocks[blockNumber-1].PackedTarget
+	return nonZeroTarget(b.Blocks[blockNumber-1].PackedTarget, params.GenesisTarget)
 }
 
-// This function saves the blockchain to the computers hard-disk.
-// Input is the name of the blockchain being saved.
+// Falls back to the easiest target the network allows when a packed target is 0, which can not be mined.
+// Returns the packed target, or the fallback target if it is 0.
+func nonZeroTarget(packedTarget uint32, packedFallback uint32) uint32 {
+
+	if packedTarget == 0 {
+
+		return packedFallback
+	}
+
+	return packedTarget
+}
+
+// Scales a packed target by numerator / denominator, for retargeting.
+// The math is done on a big.Int, so a large scale or target can not wrap around the 256 bits into a tiny target.
+// If the result is larger than the max allowed target (the genisis target), the max target is used instead.
+// Returns the packed new target.
+func scaleTarget(packedTarget uint32, numerator uint64, denominator uint64, packedMaxTarget uint32) uint32 {
+
+	unPacker := new(utilities.TargetUnpacker)
+	packer := new(utilities.TargetPacker)
+
+	// Convert the targets to big endian ints, the same order the miner compares hashes in
+	target := new(big.Int).SetBytes(unPacker.UnpackAsBytes(packedTarget))
+	maxTarget := new(big.Int).SetBytes(unPacker.UnpackAsBytes(packedMaxTarget))
+
+	newTarget := target.Mul(target, new(big.Int).SetUint64(numerator))
+	newTarget.Div(newTarget, new(big.Int).SetUint64(denominator))
+
+	// If the target is larger than the max allowed target
+	if newTarget.Cmp(maxTarget) == 1 {
+
+		return packedMaxTarget
+	}
+
+	packedNewTarget, _ := packer.PackTargetBytes(newTarget.FillBytes(make([]byte, 32)))
+
+	return packedNewTarget
+}
+
+// The formats a blockchain can be saved to the disk in.
+type PersistFormat int
+
+const (
+	// JSON, which is easy to read and use from other tools. The default.
+	PersistJSON PersistFormat = iota
+
+	// Gob, a compact binary encoding that is smaller and faster to save and load than JSON.
+	PersistGob
+)
+
+// Sets the format the blockchain is saved and loaded in.
 // Returns nothing.
-func (b *Blockchain) SaveBlockchain(bcName string) {
+func (b *Blockchain) SetPersistFormat(format PersistFormat) {
+
+	b.format = format
+}
+
+// Gets the format the blockchain is saved and loaded in.
+// Returns the format.
+func (b *Blockchain) PersistFormat() PersistFormat {
+
+	return b.format
+}
 
-	err := os.WriteFile("saves/"+bcName+".json", b.AsBytes(), 0750)
+// Gets the path the blockchain is saved to and loaded from, in SaveDir, with the file extension of its format.
+// Input is the name of the blockchain.
+// Returns the path of the save.
+func (b *Blockchain) savePath(bcName string) string {
+
+	extension := ".json"
+
+	if b.format == PersistGob {
+
+		extension = ".gob"
+	}
+
+	return filepath.Join(b.SaveDir(), bcName+extension)
+}
+
+// Encodes the blockchain in its persist format.
+// Pruned blocks are encoded with their txs from the block store, so a save never loses the txs that were pruned from memory.
+// Returns the bytes of the blockchain, or an error if it could not be encoded.
+func (b *Blockchain) encode() ([]byte, error) {
+
+	blocks, err := b.fullBlocks()
 
 	if err != nil {
 
-		panic(err)
+		return nil, err
 	}
+
+	// Only the blocks are saved, so a blockchain of the full blocks is saved in place of the pruned one
+	saved := &Blockchain{Blocks: blocks}
+
+	switch b.format {
+
+	case PersistJSON:
+		return json.Marshal(saved)
+
+	case PersistGob:
+		buffer := new(bytes.Buffer)
+
+		if err := gob.NewEncoder(buffer).Encode(saved); err != nil {
+
+			return nil, err
+		}
+
+		return buffer.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown persist format %d", b.format)
 }
 
-// Loads a saved blockchain.
-// Input is the name of the blockchain.
-// Returns nothing.
-func (b *Blockchain) LoadBlockchain(bcName string) {
+// Decodes a blockchain saved in its persist format into the blockchain.
+// The blocks are decoded into new blocks, as decoding into the old ones would keep the fields the save leaves out (like an empty CoinbaseData).
+// The blocks of the blockchain are only replaced if the whole save decodes.
+// Returns an error if the bytes could not be decoded.
+func (b *Blockchain) decode(bAsBytes []byte) error {
+
+	loaded := new(Blockchain)
+
+	switch b.format {
+
+	case PersistJSON:
+		if err := json.Unmarshal(bAsBytes, loaded); err != nil {
+
+			return err
+		}
+
+	case PersistGob:
+		if err := gob.NewDecoder(bytes.NewReader(bAsBytes)).Decode(loaded); err != nil {
+
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown persist format %d", b.format)
+	}
+
+	b.Blocks = loaded.Blocks
+
+	return nil
+}
+
+// Gets the folder the blockchain is saved in and loaded from, which is the folder of its network in DataDir.
+// This keeps the saves of the mainnet and testnet apart.
+// Returns the path of the folder.
+func (b *Blockchain) SaveDir() string {
+
+	return filepath.Join(DataDir, b.Params().Name)
+}
+
+// This function saves the blockchain to the computers hard-disk, creating its save folder if it is missing.
+// Saves atomicly, so a crash while saving never leaves a half written save.
+// The blockchain is written to a temporary file first, which then replaces the old save.
+// Input is the name of the blockchain being saved.
+// Returns an error if the blockchain could not be saved.
+func (b *Blockchain) SaveBlockchain(bcName string) error {
+
+	if err := os.MkdirAll(b.SaveDir(), 0750); err != nil {
 
-	bAsBytes, err := os.ReadFile("saves/" + bcName + ".json")
+		return err
+	}
+
+	savePath := b.savePath(bcName)
+	bAsBytes, err := b.encode()
 
 	if err != nil {
 
-		panic(err)
+		return err
+	}
+
+	if err := os.WriteFile(savePath+".tmp", bAsBytes, 0750); err != nil {
+
+		return err
+	}
+
+	return os.Rename(savePath+".tmp", savePath)
+}
+
+// Saves the blockchain once every interval, and one last time when the context is canceled.
+// Used by long running nodes, so the blockchain is not lost if the node stops.
+// Blocks until the context is canceled, so run it in its own go-routine.
+// Returns nothing.
+func (b *Blockchain) StartAutosave(ctx context.Context, bcName string, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+
+		case <-ticker.C:
+
+			if err := b.SaveBlockchain(bcName); err != nil {
+
+				fmt.Println(color.Colorize(color.Red, "[BLOCKCHAIN]: Could not autosave the blockchain. Err: ") + err.Error())
+			}
+
+		case <-ctx.Done():
+
+			if err := b.SaveBlockchain(bcName); err != nil {
+
+				fmt.Println(color.Colorize(color.Red, "[BLOCKCHAIN]: Could not save the blockchain. Err: ") + err.Error())
+			}
+
+			return
+		}
 	}
+}
 
-	// Convert the data to a blockchain from json
-	err = json.Unmarshal(bAsBytes, b)
+// Loads a saved blockchain, saved in the persist format of the blockchain.
+// The loaded blocks replace every block of the blockchain, so everything worked out from the old blocks is forgotten,
+// and the block store (if the blockchain has one) is rewritten with the loaded blocks.
+// Input is the name of the blockchain.
+// Returns an error if the save could not be read or decoded.
+func (b *Blockchain) LoadBlockchain(bcName string) error {
+
+	savePath := b.savePath(bcName)
+	bAsBytes, err := os.ReadFile(savePath)
 
 	if err != nil {
 
-		panic(err)
+		return err
 	}
+
+	b.resetIndexes()
+
+	// Convert the data to a blockchain from its persist format
+	if err := b.decode(bAsBytes); err != nil {
+
+		return fmt.Errorf("could not decode %s: %w", savePath, err)
+	}
+
+	// The store has the old blocks, so it is rewritten from the start
+	if b.store != nil {
+
+		if err := b.store.Truncate(0); err != nil {
+
+			return err
+		}
+
+		return b.SetBlockStore(b.store)
+	}
+
+	return nil
+}
+
+// Forgets everything the blockchain worked out from its blocks, like the ledger and the tx index.
+// Used when every block is replaced at once, so nothing from the old blocks is kept. The indexes are rebuilt from the new blocks when they are next used.
+// Returns nothing.
+func (b *Blockchain) resetIndexes() {
+
+	b.txHeights = nil
+	b.indexedBlocks = 0
+
+	b.invalidBlocks = nil
+
+	b.totalFees = 0
+	b.feeBlocks = 0
+
+	b.balanceIndex = nil
+	b.nonceIndex = nil
+	b.ledgerBlocks = 0
+
+	b.prunedBelow = 0
+	b.staleBlocks = 0
 }
 
-// Converts the blockchain into its bytes,
+// Writes the blockchain as newline delimited JSON, with each block as a compact JSON line.
+// Used to stream the blockchain to tools like jq, without holding the whole blockchain as JSON in memory.
+// Returns an error if a block could not be written.
+func (b *Blockchain) WriteNDJSON(w io.Writer) error {
+
+	// The encoder ends each block with a newline
+	encoder := json.NewEncoder(w)
+
+	for index := 0; index < len(b.Blocks); index += 1 {
+
+		block, found := b.GetBlock(uint(index))
+
+		// The txs of a pruned block that can not be read would be missing from the JSON
+		if !found {
+
+			return fmt.Errorf("block %d: could not be read from the block store", index)
+		}
+
+		if err := encoder.Encode(&block); err != nil {
+
+			return fmt.Errorf("block %d: %w", index, err)
+		}
+	}
+
+	return nil
+}
+
+// Converts the blockchain into its bytes, with the txs of pruned blocks read from the block store.
 // Returns the byte slice of the blockchain.
 func (b *Blockchain) AsBytes() []byte {
 
+	blocks, err := b.fullBlocks()
+
+	if err != nil {
+
+		panic(err)
+	}
+
 	// Get the byte slice
-	bAsBytes, err := json.Marshal(b)
+	bAsBytes, err := json.Marshal(&Blockchain{Blocks: blocks})
 
 	if err != nil {
 
@@ -210,28 +1197,73 @@ func (b *Blockchain) AsBytes() []byte {
 // No inputs required and returns the uint64 of the current difficulty.
 func (b *Blockchain) GetDifficulty() uint64 {
 
-	unpacker := new(utilities.TargetUnpacker)
+	tip, found := b.Tip()
 
-	currentTarget := unpacker.Unpack(b.Blocks[b.GetHeight()].PackedTarget)
-	genisisTarget := unpacker.Unpack(0x1d0fffff)
+	// An empty blockchain has no difficulty yet
+	if !found {
 
-	difficulty := genisisTarget.Divide(&currentTarget)
+		return 0
+	}
 
-	// Returns the uint256 as a uint64 from little endian order
-	return binary.LittleEndian.Uint64(difficulty.ToBytes())
+	return difficultyOf(tip.PackedTarget)
 }
 
 // This function gets the difficulty of a specific block from the blockchain.
 // Only input is the block number and returns the uint64 of that blocks difficulty.
 func (b *Blockchain) GetDifficultyOfBlock(blockN uint) uint64 {
 
+	return difficultyOf(b.Blocks[blockN].PackedTarget)
+}
+
+// A single point in the difficulty history of the blockchain.
+type DifficultyPoint struct {
+	Height     uint
+	Difficulty uint64
+	Timestamp  uint64
+}
+
+// This function gets the difficulty at every retarget boundary of the blockchain, starting with the genisis block.
+// Used for charting, as it avoids calling GetDifficultyOfBlock for every block.
+// Returns a slice of the difficulty points, which is empty if the blockchain has no blocks.
+func (b *Blockchain) DifficultyHistory() []DifficultyPoint {
+
+	history := []DifficultyPoint{}
+	interval := b.Params().RetargetInterval
+
+	// The difficulty can only change once every retarget interval
+	for height := uint(0); height < uint(len(b.Blocks)); height += interval {
+
+		history = append(history, DifficultyPoint{
+			Height:     height,
+			Difficulty: b.GetDifficultyOfBlock(height),
+			Timestamp:  b.Blocks[height].Timestamp,
+		})
+
+		// Without an interval, the difficulty never changes from the genisis block
+		if interval == 0 {
+
+			break
+		}
+	}
+
+	return history
+}
+
+// Calculates the difficulty of a packed target, which is how many times harder it is than the genisis target.
+// The targets are compared as big endian numbers, the same way the miner compares hashes to them.
+// Returns the uint64 of the difficulty, or 0 if the target is zero.
+func difficultyOf(packedTarget uint32) uint64 {
+
 	unpacker := new(utilities.TargetUnpacker)
 
-	currentTarget := unpacker.Unpack(b.Blocks[blockN].PackedTarget)
-	genisisTarget := unpacker.Unpack(0x1d0fffff)
+	currentTarget := new(big.Int).SetBytes(unpacker.UnpackAsBytes(packedTarget))
+	genisisTarget := new(big.Int).SetBytes(unpacker.UnpackAsBytes(0x1d0fffff))
 
-	difficulty := genisisTarget.Divide(&currentTarget)
+	// A target of zero can not be divided by
+	if currentTarget.Sign() == 0 {
+
+		return 0
+	}
 
-	// Returns the uint256 as a uint64 from little endian order
-	return binary.LittleEndian.Uint64(difficulty.ToBytes())
+	return new(big.Int).Div(genisisTarget, currentTarget).Uint64()
 }
@@ -0,0 +1,1370 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
+)
+
+func TestDifficultyHistory(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	// Three retarget windows, each one twice as hard as the last
+	targets := []uint32{0x1d0fffff, 0x1d07ffff, 0x1d03ffff}
+
+	for index := 0; index < 10080*3; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, Block{
+			PackedTarget: targets[index/10080],
+			Timestamp:    uint64(index * 60),
+		})
+	}
+
+	history := bc.DifficultyHistory()
+
+	if len(history) != 3 {
+
+		t.Fatalf("Expected 3 difficulty points, got %d", len(history))
+	}
+
+	expected := []DifficultyPoint{
+		{Height: 0, Difficulty: 1, Timestamp: 0},
+		{Height: 10080, Difficulty: 2, Timestamp: 10080 * 60},
+		{Height: 20160, Difficulty: 4, Timestamp: 20160 * 60},
+	}
+
+	for index := range expected {
+
+		if history[index] != expected[index] {
+
+			t.Errorf("Point %d: expected %+v, got %+v", index, expected[index], history[index])
+		}
+	}
+}
+
+func TestDifficultyHistoryEmpty(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	if len(bc.DifficultyHistory()) != 0 {
+
+		t.Error("Expected no difficulty points for an empty blockchain")
+	}
+}
+
+// Creates a blockchain with a full retarget window, where the last block has the target inputted.
+// The window takes the amount of seconds inputted to mine.
+// Returns the blockchain.
+func newRetargetChain(lastTarget uint32, windowTime uint64) *Blockchain {
+
+	bc := new(Blockchain)
+	bc.Blocks = make([]Block, 10080)
+
+	for index := range bc.Blocks {
+
+		bc.Blocks[index].PackedTarget = lastTarget
+	}
+
+	bc.Blocks[10079].Timestamp = windowTime
+
+	return bc
+}
+
+func TestCalculatePackedTargetOverflow(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		lastTarget uint32
+		windowTime uint64
+		expected   uint32
+	}{
+		// A window twice as slow as expected, 0x0fffff * 2 = 0x1ffffe
+		{"normal", 0x1c0fffff, 10080 * 60 * 2, 0x1c1ffffe},
+		{"near max target", 0x1d0fffff, 10080 * 60 * 8, 0x1d0fffff},
+		// Without the clamp, this multiply wraps past 256 bits
+		{"over max target", 0x2000ffff, 10080 * 60 * 8, 0x1d0fffff},
+	}
+
+	for _, test := range tests {
+
+		bc := newRetargetChain(test.lastTarget, test.windowTime)
+
+		if target := bc.CalculatePackedTarget(10080); target != test.expected {
+
+			t.Errorf("%s: expected target %x, got %x", test.name, test.expected, target)
+		}
+	}
+}
+
+func TestBlockchainCopy(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	params := TestnetParams
+	params.RuleHeights = map[Rule]uint{RuleValidMiner: 5}
+	bc.SetParams(params)
+
+	for index := 0; index < 4; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, Block{Miner: "aa", CoinbaseData: []byte("pool"), Txs: []transactions.LuTx{{TxTo: "bb", Value: 10}}})
+	}
+
+	chainCopy := bc.Copy()
+
+	// Mutate the copy in every way a reorg could
+	chainCopy.Blocks[1].Miner = "cc"
+	chainCopy.Blocks[2].Txs[0].Value = 20
+	chainCopy.Blocks[2].CoinbaseData[0] = 'x'
+	chainCopy.Blocks[3].AddTx(transactions.LuTx{TxTo: "dd"})
+	chainCopy.RemoveBlock()
+	chainCopy.AddBlock(&Block{Miner: "ee"})
+	chainCopy.Params().RuleHeights[RuleValidMiner] = 0
+
+	if bc.Blocks[1].Miner != "aa" || bc.Blocks[2].Txs[0].Value != 10 || string(bc.Blocks[2].CoinbaseData) != "pool" || len(bc.Blocks[3].Txs) != 1 || bc.Blocks[3].Miner != "aa" {
+
+		t.Error("Expected the original blocks to not change with the copy")
+	}
+
+	if bc.GetHeight() != 3 || chainCopy.GetHeight() != 3 {
+
+		t.Error("Expected both chains to keep their heights")
+	}
+
+	if bc.Params().Name != "testnet" || bc.Params().RuleHeights[RuleValidMiner] != 5 {
+
+		t.Error("Expected the original params to not change with the copy")
+	}
+}
+
+func TestValidateSupply(t *testing.T) {
+
+	defer func(interval uint32, maxSupply uint64) {
+
+		HalvingInterval = interval
+		MaxSupply = maxSupply
+	}(HalvingInterval, MaxSupply)
+
+	// Shrink the schedule to 10 blocks a halving, so a short chain goes through every halving
+	HalvingInterval = 10
+	MaxSupply = 208597500 * 1000000 / 525600 * 10
+
+	bc := new(Blockchain)
+	bc.Blocks = make([]Block, 120)
+
+	if err := bc.ValidateSupply(); err != nil {
+
+		t.Fatalf("Expected the full reward schedule to be within the cap, got %v", err)
+	}
+
+	// Past the last halving, no more coins are issued
+	if reward := bc.GetBlockReward(70); reward != 0 {
+
+		t.Errorf("Expected no reward after the reward dried up, got %d", reward)
+	}
+
+	if reward := bc.GetBlockReward(0xffffffff); reward != 0 {
+
+		t.Errorf("Expected no reward at the max height, got %d", reward)
+	}
+
+	// A cap 1 LNCH short of the schedule is exceeded by the last reward
+	MaxSupply -= 1000000
+
+	if err := bc.ValidateSupply(); !errors.Is(err, ErrSupplyExceeded) {
+
+		t.Errorf("Expected %v, got %v", ErrSupplyExceeded, err)
+	}
+}
+
+func TestGetBlockRewardHalvings(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	tests := []struct {
+		height   uint32
+		expected uint64
+	}{
+		{0, 200000000},
+		{525599, 200000000},
+		{525600, 100000000},
+		{1051200, 50000000},
+		{1576800, 25000000},
+		{2102400, 12500000},
+		{2628000, 6250000},
+		{3153600, 3125000},
+		{3679199, 3125000},
+
+		// The first block of year 8
+		{3679200, 0},
+		{0xffffffff, 0},
+	}
+
+	for _, test := range tests {
+
+		if reward := bc.GetBlockReward(test.height); reward != test.expected {
+
+			t.Errorf("Height %d: expected %d, got %d", test.height, test.expected, reward)
+		}
+	}
+}
+
+func TestMaxSupplyMatchesSchedule(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	var supply uint64
+
+	// Sum one block of each halving, as every block of a halving has the same reward
+	for halving := uint32(0); halving <= 8; halving += 1 {
+
+		supply += bc.GetBlockReward(halving*HalvingInterval) * uint64(HalvingInterval)
+	}
+
+	if supply != MaxSupply {
+
+		t.Errorf("Expected the reward schedule to issue %d, got %d", MaxSupply, supply)
+	}
+}
+
+func TestTxFeeRate(t *testing.T) {
+
+	cheapTx := transactions.LuTx{TxFrom: "aa", TxTo: "bb", Value: 10, Fee: 1000}
+	pricyTx := transactions.LuTx{TxFrom: "aa", TxTo: "bb", Value: 10, Nonce: 1, Fee: 50000}
+
+	bc := new(Blockchain)
+	bc.Blocks = []Block{{}, {Txs: []transactions.LuTx{cheapTx}}, {Txs: []transactions.LuTx{pricyTx}}}
+
+	for _, tx := range []transactions.LuTx{cheapTx, pricyTx} {
+
+		rate, found := bc.TxFeeRate(tx.HashTx())
+		expected := float64(tx.Fee) / float64(tx.GetWeight())
+
+		if !found || rate != expected {
+
+			t.Errorf("Expected the fee rate %f, got %f (found %v)", expected, rate, found)
+		}
+	}
+
+	if _, found := bc.TxFeeRate("kaimorton123"); found {
+
+		t.Error("Expected an unknown txid to not be found")
+	}
+}
+
+func TestBlockHooksReorg(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	for index := 0; index < 5; index += 1 {
+
+		bc.AddBlock(&Block{Miner: "old"})
+	}
+
+	events := []string{}
+
+	unhookConnect := bc.OnConnect(func(block Block, height uint) {
+
+		events = append(events, fmt.Sprintf("connect %s %d", block.Miner, height))
+	})
+
+	unhookDisconnect := bc.OnDisconnect(func(block Block, height uint) {
+
+		events = append(events, fmt.Sprintf("disconnect %s %d", block.Miner, height))
+	})
+
+	// Reorg the last two blocks out for three new ones
+	bc.RollbackTo(2)
+
+	for index := 0; index < 3; index += 1 {
+
+		bc.AddBlock(&Block{Miner: "new"})
+	}
+
+	expected := []string{"disconnect old 4", "disconnect old 3", "connect new 3", "connect new 4", "connect new 5"}
+
+	if len(events) != len(expected) {
+
+		t.Fatalf("Expected the events %v, got %v", expected, events)
+	}
+
+	for index := 0; index < len(expected); index += 1 {
+
+		if events[index] != expected[index] {
+
+			t.Errorf("Event %d: expected %q, got %q", index, expected[index], events[index])
+		}
+	}
+
+	// Rolling back to a height at or above the tip does nothing
+	events = events[:0]
+	bc.RollbackTo(5)
+
+	if len(events) != 0 || bc.GetHeight() != 5 {
+
+		t.Error("Expected rolling back to the tip to not remove any blocks")
+	}
+
+	// The hooks stay with the original chain
+	chainCopy := bc.Copy()
+	chainCopy.RemoveBlock()
+
+	if len(events) != 0 {
+
+		t.Error("Expected the copy to not call the hooks of the original")
+	}
+
+	// Unregistered hooks are not called anymore, and unregistering them again does nothing
+	unhookConnect()
+	unhookDisconnect()
+	unhookConnect()
+
+	bc.RemoveBlock()
+	bc.AddBlock(&Block{Miner: "new"})
+
+	if len(events) != 0 || len(bc.connectHooks) != 0 || len(bc.disconnectHooks) != 0 {
+
+		t.Errorf("Expected the unregistered hooks to be removed, got the events %v", events)
+	}
+}
+
+func TestGetBlockByTxid(t *testing.T) {
+
+	firstTx := transactions.LuTx{TxFrom: "aa", TxTo: "bb", Value: 10}
+	secondTx := transactions.LuTx{TxFrom: "aa", TxTo: "bb", Value: 10, Nonce: 1}
+
+	bc := new(Blockchain)
+	bc.AddBlock(&Block{})
+	bc.AddBlock(&Block{Miner: "one", Txs: []transactions.LuTx{firstTx}})
+	bc.AddBlock(&Block{Miner: "two"})
+
+	block, height, found := bc.GetBlockByTxid(firstTx.HashTx())
+
+	if !found || height != 1 || block.Miner != "one" {
+
+		t.Errorf("Expected the tx to be found in block 1, got block %d (found %v)", height, found)
+	}
+
+	if _, _, found := bc.GetBlockByTxid("kaimorton123"); found {
+
+		t.Error("Expected an unknown txid to not be found")
+	}
+
+	// Blocks added after the index was made are indexed on the next lookup
+	bc.AddBlock(&Block{Miner: "three", Txs: []transactions.LuTx{secondTx}})
+
+	if block, height, found := bc.GetBlockByTxid(secondTx.HashTx()); !found || height != 3 || block.Miner != "three" {
+
+		t.Errorf("Expected the new tx to be found in block 3, got block %d (found %v)", height, found)
+	}
+
+	// Removed blocks are taken out of the index
+	bc.RollbackTo(2)
+
+	if _, _, found := bc.GetBlockByTxid(secondTx.HashTx()); found {
+
+		t.Error("Expected the tx of a removed block to not be found")
+	}
+
+	if _, height, found := bc.GetBlockByTxid(firstTx.HashTx()); !found || height != 1 {
+
+		t.Error("Expected the tx of a kept block to still be found")
+	}
+}
+
+func TestCalculatePackedTargetShortChain(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	// The genisis block uses the genisis target of the params
+	if target := bc.CalculatePackedTarget(0); target != MainnetParams.GenesisTarget {
+
+		t.Errorf("Expected the genisis target %x at height 0, got %x", MainnetParams.GenesisTarget, target)
+	}
+
+	for index := 0; index < 5; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, Block{PackedTarget: 0x1c0fffff, Timestamp: uint64(index * 30)})
+	}
+
+	// Before the first window, the genisis block target is used
+	if target := bc.CalculatePackedTarget(5); target != 0x1c0fffff {
+
+		t.Errorf("Expected the genisis block target before the first window, got %x", target)
+	}
+
+	// A retarget height without the history to retarget from
+	if target := bc.CalculatePackedTarget(10080); target != 0 {
+
+		t.Errorf("Expected no target without the blocks before it, got %x", target)
+	}
+
+	// A normal retarget, with a full window of blocks twice as fast as the target, which halves the target
+	if target := newRetargetChain(0x1c0fffff, 10080*30).CalculatePackedTarget(10080); target != 0x1c07ffff {
+
+		t.Errorf("Expected the retarget to %x, got %x", 0x1c07ffff, target)
+	}
+}
+
+// Waits for the file at the path inputted to exist.
+// Returns true if it was found before the timeout.
+func waitForFile(path string, timeout time.Duration) bool {
+
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(5 * time.Millisecond) {
+
+		if _, err := os.Stat(path); err == nil {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestStartAutosave(t *testing.T) {
+
+	workDir, _ := os.Getwd()
+	defer os.Chdir(workDir)
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+
+		t.Fatal(err)
+	}
+
+	bc := new(Blockchain)
+	bc.AddBlock(&Block{Miner: "aa"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+
+	go func() {
+
+		bc.StartAutosave(ctx, "autosave", 10*time.Millisecond)
+		done <- true
+	}()
+
+	if !waitForFile("saves/mainnet/autosave.json", time.Second) {
+
+		t.Fatal("Expected the blockchain to be saved on the interval")
+	}
+
+	// The save keeps being updated on every tick
+	os.Remove("saves/mainnet/autosave.json")
+
+	if !waitForFile("saves/mainnet/autosave.json", time.Second) {
+
+		t.Fatal("Expected the blockchain to be saved again")
+	}
+
+	cancel()
+
+	select {
+
+	case <-done:
+	case <-time.After(time.Second):
+
+		t.Fatal("Expected the autosave to stop when the context is canceled")
+	}
+
+	loaded := new(Blockchain)
+
+	if err := loaded.LoadBlockchain("autosave"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if len(loaded.Blocks) != 1 || loaded.Blocks[0].Miner != "aa" {
+
+		t.Error("Expected the saved blockchain to load back")
+	}
+
+	if _, err := os.Stat("saves/mainnet/autosave.json.tmp"); err == nil {
+
+		t.Error("Expected the temporary save file to be renamed over the save")
+	}
+}
+
+func TestSaveBlockchainPerNetwork(t *testing.T) {
+
+	workDir, _ := os.Getwd()
+	defer os.Chdir(workDir)
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+
+		t.Fatal(err)
+	}
+
+	mainnet := InitBlockchainWithParams(MainnetParams)
+	testnet := InitBlockchainWithParams(TestnetParams)
+	testnet.AddBlock(&Block{Miner: "testnetMiner"})
+
+	// Both are saved with the same name
+
+	if err := mainnet.SaveBlockchain("chain"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if err := testnet.SaveBlockchain("chain"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"saves/mainnet/chain.json", "saves/testnet/chain.json"} {
+
+		if _, err := os.Stat(path); err != nil {
+
+			t.Errorf("Expected a save at %s, got %v", path, err)
+		}
+	}
+
+	loadedMainnet := new(Blockchain)
+
+	if err := loadedMainnet.LoadBlockchain("chain"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	loadedTestnet := new(Blockchain)
+	loadedTestnet.SetParams(TestnetParams)
+
+	if err := loadedTestnet.LoadBlockchain("chain"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if len(loadedMainnet.Blocks) != 1 || loadedMainnet.Blocks[0].PackedTarget != MainnetParams.GenesisTarget {
+
+		t.Error("Expected the mainnet save to load the mainnet blockchain")
+	}
+
+	if len(loadedTestnet.Blocks) != 2 || loadedTestnet.Blocks[1].Miner != "testnetMiner" {
+
+		t.Error("Expected the testnet save to load the testnet blockchain")
+	}
+}
+
+func TestSaveBlockchainMissingDir(t *testing.T) {
+
+	defer func(dataDir string) { DataDir = dataDir }(DataDir)
+	DataDir = filepath.Join(t.TempDir(), "missing", "saves")
+
+	bc := InitBlockchainWithParams(TestnetParams)
+
+	if err := bc.SaveBlockchain("chain"); err != nil {
+
+		t.Fatalf("Expected the missing save folder to be created, got %v", err)
+	}
+
+	loaded := new(Blockchain)
+	loaded.SetParams(TestnetParams)
+
+	if err := loaded.LoadBlockchain("chain"); err != nil || len(loaded.Blocks) != 1 {
+
+		t.Errorf("Expected the saved blockchain to load back, got %d blocks and %v", len(loaded.Blocks), err)
+	}
+
+	// A save that was never made
+
+	if err := loaded.LoadBlockchain("neverSaved"); !errors.Is(err, os.ErrNotExist) {
+
+		t.Errorf("Expected a missing save to return %v, got %v", os.ErrNotExist, err)
+	}
+}
+
+func TestLoadBlockchainCorrupted(t *testing.T) {
+
+	defer func(dataDir string) { DataDir = dataDir }(DataDir)
+	DataDir = t.TempDir()
+
+	bc := new(Blockchain)
+
+	if err := os.MkdirAll(bc.SaveDir(), 0750); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(bc.savePath("chain"), []byte(`{"Blocks": [{"Miner": `), 0750); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if err := bc.LoadBlockchain("chain"); err == nil {
+
+		t.Error("Expected a corrupted save to return an error")
+	}
+}
+
+func TestLoadBlockchainOverIndexedChain(t *testing.T) {
+
+	defer func(dataDir string) { DataDir = dataDir }(DataDir)
+	DataDir = t.TempDir()
+
+	// Two blockchains of the same length, mined by different keys
+	newChain := func(miner string) *Blockchain {
+
+		bc := new(Blockchain)
+
+		for index := 0; index < 16; index += 1 {
+
+			bc.AddBlock(&Block{Miner: miner, BlockHash: fmt.Sprintf("%s%d", miner, index), Txs: []transactions.LuTx{{TxFrom: miner, TxTo: "bob", Value: 10, Fee: 5}}})
+		}
+
+		return bc
+	}
+
+	saved := newChain("new")
+
+	if err := saved.SaveBlockchain("chain"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	// Build every index of the old blockchain before loading over it
+	bc := newChain("old")
+	bc.MarkInvalid("new3")
+	oldTxid := bc.Blocks[2].Txs[0].HashTx()
+
+	if bc.IndexedBalance("old") == 0 || bc.TotalFeesCollected() != 80 {
+
+		t.Fatal("Expected the old blockchain to be indexed")
+	}
+
+	if _, _, found := bc.GetBlockByTxid(oldTxid); !found {
+
+		t.Fatal("Expected the old tx to be indexed")
+	}
+
+	if err := bc.LoadBlockchain("chain"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	// Only the rewards of blocks 0 to 4 are past the maturity window of the tip
+	expected := 5 * bc.GetBlockReward(0)
+
+	if balance := bc.IndexedBalance("new"); balance != expected {
+
+		t.Errorf("Expected the new miner to have a balance of %d, got %d", expected, balance)
+	}
+
+	if balance, nonce := bc.IndexedBalance("old"), bc.IndexedNonce("old"); balance != 0 || nonce != 0 {
+
+		t.Errorf("Expected the old miner to be forgotten, got a balance of %d and a nonce of %d", balance, nonce)
+	}
+
+	if _, _, found := bc.GetBlockByTxid(oldTxid); found {
+
+		t.Error("Expected the old tx to be forgotten")
+	}
+
+	if _, _, found := bc.GetBlockByTxid(saved.Blocks[2].Txs[0].HashTx()); !found {
+
+		t.Error("Expected the loaded tx to be found")
+	}
+
+	if bc.IsInvalid("new3") {
+
+		t.Error("Expected the blocks marked invalid before the load to be forgotten")
+	}
+
+	if fees := bc.TotalFeesCollected(); fees != 80 {
+
+		t.Errorf("Expected the fees of the loaded blocks, got %d", fees)
+	}
+}
+
+func TestLoadBlockchainOverTaggedBlocks(t *testing.T) {
+
+	defer func(dataDir string) { DataDir = dataDir }(DataDir)
+	DataDir = t.TempDir()
+
+	for _, format := range []PersistFormat{PersistJSON, PersistGob} {
+
+		saved := new(Blockchain)
+		saved.SetPersistFormat(format)
+		saved.Blocks = []Block{{Miner: "aa", Timestamp: 1}}
+
+		if err := saved.SaveBlockchain("chain"); err != nil {
+
+			t.Fatal(err)
+		}
+
+		// The block being loaded over has the fields the save leaves out
+		bc := new(Blockchain)
+		bc.SetPersistFormat(format)
+		bc.Blocks = []Block{{Miner: "bb", CoinbaseData: []byte("pool"), VersionBits: 5}}
+
+		if err := bc.LoadBlockchain("chain"); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(bc.Blocks, saved.Blocks) || !bytes.Equal(bc.Blocks[0].CalcHash(), saved.Blocks[0].CalcHash()) {
+
+			t.Errorf("Format %d: expected the loaded block %+v, got %+v", format, saved.Blocks[0], bc.Blocks[0])
+		}
+	}
+}
+
+func TestIndexedBalanceNeverWraps(t *testing.T) {
+
+	bc := new(Blockchain)
+	bc.AddBlock(&Block{Miner: "aa"})
+
+	// A ledger that is missing the reward of the immature block
+	bc.syncLedger()
+	bc.balanceIndex["aa"] = 0
+
+	if balance := bc.IndexedBalance("aa"); balance != 0 {
+
+		t.Errorf("Expected a balance of 0, got %d", balance)
+	}
+}
+
+func TestPersistFormatRoundTrip(t *testing.T) {
+
+	workDir, _ := os.Getwd()
+	defer os.Chdir(workDir)
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+
+		t.Fatal(err)
+	}
+
+	bc := new(Blockchain)
+
+	for index := 0; index < 50; index += 1 {
+
+		block := Block{
+			SoftwareVersion: "v1",
+			PrevHash:        fmt.Sprintf("%064x", index),
+			PackedTarget:    0x1d0fffff,
+			Miner:           "04aabbccdd",
+			Nonce:           uint32(index),
+			Timestamp:       uint64(1000 + index),
+			BlockHash:       fmt.Sprintf("%064x", index+1),
+		}
+
+		if index%2 == 0 {
+
+			block.Txs = []transactions.LuTx{{TxFrom: "04aabb", TxTo: "04ccdd", Value: uint64(index), Fee: 1000, Signature: "eeff"}}
+			block.CoinbaseData = []byte("LuncheonPool/1")
+			block.VersionBits = 1 << 3
+		}
+
+		bc.Blocks = append(bc.Blocks, block)
+	}
+
+	sizes := map[PersistFormat]int64{}
+
+	for _, format := range []PersistFormat{PersistJSON, PersistGob} {
+
+		bc.SetPersistFormat(format)
+
+		if err := bc.SaveBlockchain("chain"); err != nil {
+
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(bc.savePath("chain"))
+
+		if err != nil {
+
+			t.Fatal(err)
+		}
+
+		sizes[format] = info.Size()
+
+		loaded := new(Blockchain)
+		loaded.SetPersistFormat(format)
+
+		if err := loaded.LoadBlockchain("chain"); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(loaded.Blocks, bc.Blocks) {
+
+			t.Errorf("Format %d: expected the loaded blockchain to equal the saved one", format)
+		}
+	}
+
+	if sizes[PersistGob] >= sizes[PersistJSON] {
+
+		t.Errorf("Expected the gob save (%d bytes) to be smaller than the JSON save (%d bytes)", sizes[PersistGob], sizes[PersistJSON])
+	}
+
+	// Each format has its own save
+	if filepath.Ext(new(Blockchain).savePath("chain")) != ".json" || filepath.Ext(bc.savePath("chain")) != ".gob" {
+
+		t.Error("Expected the JSON and gob saves to have their own file extensions")
+	}
+}
+
+func TestDiskGrowthPerDay(t *testing.T) {
+
+	defer func(window uint, blockTime time.Duration) {
+
+		DiskGrowthWindow = window
+		TargetBlockTime = blockTime
+	}(DiskGrowthWindow, TargetBlockTime)
+
+	// Pads the miner of a block, so the block saves to exactly size bytes
+	sizedBlock := func(size int) Block {
+
+		block := Block{}
+		block.Miner = strings.Repeat("a", size-len(block.AsBytes()))
+
+		return block
+	}
+
+	bc := new(Blockchain)
+
+	if growth := bc.DiskGrowthPerDay(); growth != 0 {
+
+		t.Errorf("Expected no growth without blocks, got %d", growth)
+	}
+
+	// The oldest block is outside of the window
+	bc.Blocks = []Block{sizedBlock(10000), sizedBlock(1000), sizedBlock(3000)}
+	DiskGrowthWindow = 2
+
+	tests := []struct {
+		blockTime time.Duration
+		expected  int64
+	}{
+		// 2000 bytes a block, 1440 blocks a day
+		{time.Minute, 2880000},
+		{10 * time.Minute, 288000},
+		{30 * time.Second, 5760000},
+	}
+
+	for _, test := range tests {
+
+		TargetBlockTime = test.blockTime
+
+		if growth := bc.DiskGrowthPerDay(); growth != test.expected {
+
+			t.Errorf("%s blocks: expected %d bytes a day, got %d", test.blockTime, test.expected, growth)
+		}
+	}
+}
+
+func TestCoinsUntilHalving(t *testing.T) {
+
+	defer func(interval uint32) { HalvingInterval = interval }(HalvingInterval)
+	HalvingInterval = 10
+
+	tests := []struct {
+		blocks   int
+		expected uint64
+	}{
+		// The next block is the first of the halving
+		{0, 10 * 200 * 1000000},
+		{1, 9 * 200 * 1000000},
+		// The next block is the last before the halving
+		{9, 1 * 200 * 1000000},
+		{10, 10 * 100 * 1000000},
+		{17, 3 * 100 * 1000000},
+		// After the reward has dried up
+		{95, 0},
+	}
+
+	for _, test := range tests {
+
+		bc := new(Blockchain)
+		bc.Blocks = make([]Block, test.blocks)
+
+		if coins := bc.CoinsUntilHalving(); coins != test.expected {
+
+			t.Errorf("%d blocks: expected %d, got %d", test.blocks, test.expected, coins)
+		}
+	}
+}
+
+func TestTip(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	if tip, found := bc.Tip(); found || tip != nil {
+
+		t.Error("Expected an empty blockchain to have no tip")
+	}
+
+	if difficulty := bc.GetDifficulty(); difficulty != 0 {
+
+		t.Errorf("Expected an empty blockchain to have no difficulty, got %d", difficulty)
+	}
+
+	bc.AddBlock(&Block{Miner: "aa"})
+	bc.AddBlock(&Block{Miner: "bb", BlockHash: "ccdd"})
+
+	tip, found := bc.Tip()
+
+	if !found || tip.Miner != "bb" {
+
+		t.Fatal("Expected the tip to be the newest block")
+	}
+
+	// The tip is the block on the blockchain, not a copy
+	tip.Miner = "ee"
+
+	if bc.Blocks[1].Miner != "ee" {
+
+		t.Error("Expected the tip to point to the block on the blockchain")
+	}
+
+	if block := bc.CreateBlock("ff"); block.PrevHash != "ccdd" {
+
+		t.Errorf("Expected a new block to point to the tip, got %q", block.PrevHash)
+	}
+}
+
+func TestAverageTxsPerBlock(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	// Blocks with 0, 1, 2, 3, 4 and 5 txs
+	for index := 0; index < 6; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, Block{Txs: make([]transactions.LuTx, index)})
+	}
+
+	tests := []struct {
+		window   uint
+		expected float64
+	}{
+		{0, 0},
+		{1, 5},
+		{2, 4.5},
+		{4, 3.5},
+		{6, 2.5},
+		// Bigger than the blockchain
+		{100, 2.5},
+	}
+
+	for _, test := range tests {
+
+		if average := bc.AverageTxsPerBlock(test.window); average != test.expected {
+
+			t.Errorf("Window %d: expected %f, got %f", test.window, test.expected, average)
+		}
+	}
+
+	if average := new(Blockchain).AverageTxsPerBlock(10); average != 0 {
+
+		t.Errorf("Expected an empty blockchain to average 0, got %f", average)
+	}
+}
+
+// Creates a blockchain with a block for each hash inputted, where every block links to the block before it.
+// Returns the blockchain.
+func newLinkedChain(hashes ...string) *Blockchain {
+
+	bc := new(Blockchain)
+
+	for index := 0; index < len(hashes); index += 1 {
+
+		block := Block{BlockHash: hashes[index]}
+
+		if index != 0 {
+
+			block.PrevHash = hashes[index-1]
+		}
+
+		bc.Blocks = append(bc.Blocks, block)
+	}
+
+	return bc
+}
+
+func TestCommonAncestor(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		a              *Blockchain
+		b              *Blockchain
+		expectedHeight uint
+		expectedHash   string
+		expectedOk     bool
+	}{
+		{"same chain", newLinkedChain("g", "a1", "a2"), newLinkedChain("g", "a1", "a2"), 2, "a2", true},
+		{"fork", newLinkedChain("g", "a1", "a2", "a3"), newLinkedChain("g", "a1", "b2"), 1, "a1", true},
+		{"fork, longer b", newLinkedChain("g", "a1"), newLinkedChain("g", "b1", "b2", "b3", "b4"), 0, "g", true},
+		{"prefix", newLinkedChain("g", "a1"), newLinkedChain("g", "a1", "a2", "a3"), 1, "a1", true},
+		{"different genisis", newLinkedChain("g", "a1", "a2"), newLinkedChain("h", "b1", "b2"), 0, "", false},
+		{"empty", newLinkedChain("g"), new(Blockchain), 0, "", false},
+	}
+
+	for _, test := range tests {
+
+		height, hash, ok := CommonAncestor(test.a, test.b)
+
+		if height != test.expectedHeight || hash != test.expectedHash || ok != test.expectedOk {
+
+			t.Errorf("%s: expected (%d, %q, %v), got (%d, %q, %v)", test.name,
+				test.expectedHeight, test.expectedHash, test.expectedOk, height, hash, ok)
+		}
+	}
+}
+
+func TestAverageBlockTime(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	if average := bc.AverageBlockTime(10); average != TargetBlockTime {
+
+		t.Errorf("Expected an empty blockchain to average the target block time, got %s", average)
+	}
+
+	// 5 block times of 30 seconds, then 5 of 90 seconds
+	for _, timestamp := range []uint64{0, 30, 60, 90, 120, 150, 240, 330, 420, 510, 600} {
+
+		bc.Blocks = append(bc.Blocks, Block{Timestamp: timestamp})
+	}
+
+	tests := []struct {
+		window   uint
+		expected time.Duration
+	}{
+		{0, TargetBlockTime},
+		{5, 90 * time.Second},
+		{10, time.Minute},
+		// Bigger than the blockchain
+		{100, time.Minute},
+	}
+
+	for _, test := range tests {
+
+		if average := bc.AverageBlockTime(test.window); average != test.expected {
+
+			t.Errorf("Window %d: expected %s, got %s", test.window, test.expected, average)
+		}
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+
+	bc := newLinkedChain("g", "a1", "a2", "a3")
+	bc.Blocks[2].Txs = []transactions.LuTx{{TxFrom: "alice", TxTo: "bob", Value: 2000}}
+
+	buffer := new(bytes.Buffer)
+
+	if err := bc.WriteNDJSON(buffer); err != nil {
+
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+
+	if len(lines) != len(bc.Blocks) {
+
+		t.Fatalf("Expected %d lines, got %d", len(bc.Blocks), len(lines))
+	}
+
+	for index, line := range lines {
+
+		var block Block
+
+		if err := json.Unmarshal([]byte(line), &block); err != nil {
+
+			t.Fatalf("Line %d: could not parse %q: %v", index, line, err)
+		}
+
+		if !reflect.DeepEqual(block, bc.Blocks[index]) {
+
+			t.Errorf("Line %d: expected block %+v, got %+v", index, bc.Blocks[index], block)
+		}
+	}
+}
+
+func TestTotalFeesCollected(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	for _, fees := range [][]uint64{{}, {1000, 2000}, {3000}, {}, {4000, 5000, 6000}} {
+
+		block := Block{}
+
+		for _, fee := range fees {
+
+			block.Txs = append(block.Txs, transactions.LuTx{TxFrom: "alice", TxTo: "bob", Value: 2000, Fee: fee})
+		}
+
+		bc.AddBlock(&block)
+	}
+
+	if total := bc.TotalFeesCollected(); total != 21000 {
+
+		t.Errorf("Expected 21000 in fees, got %d", total)
+	}
+
+	// The fees of removed blocks are taken out
+	bc.RollbackTo(2)
+
+	if total := bc.TotalFeesCollected(); total != 6000 {
+
+		t.Errorf("Expected 6000 in fees after the rollback, got %d", total)
+	}
+
+	bc.AddBlock(&Block{Txs: []transactions.LuTx{{Fee: 7000}}})
+
+	if total := bc.TotalFeesCollected(); total != 13000 {
+
+		t.Errorf("Expected 13000 in fees after adding a block, got %d", total)
+	}
+
+	// Blocks changed without AddBlock are counted too
+	bc.Blocks = bc.Blocks[:1]
+	bc.Blocks = append(bc.Blocks, Block{Txs: []transactions.LuTx{{Fee: 500}}})
+
+	if total := bc.TotalFeesCollected(); total != 500 {
+
+		t.Errorf("Expected 500 in fees after the blocks were replaced, got %d", total)
+	}
+}
+
+func TestSignalPercent(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	if percent := bc.SignalPercent(1, 10); percent != 0 {
+
+		t.Errorf("Expected an empty blockchain to have no signaling, got %f", percent)
+	}
+
+	// The newest 4 blocks signal bit 1, and every other block signals bit 2
+	for index := 0; index < 10; index += 1 {
+
+		block := Block{}
+
+		if index >= 6 {
+
+			block.SignalBit(1)
+		}
+
+		if index%2 == 0 {
+
+			block.SignalBit(2)
+		}
+
+		bc.Blocks = append(bc.Blocks, block)
+	}
+
+	tests := []struct {
+		bit      int
+		window   uint
+		expected float64
+	}{
+		{1, 4, 1},
+		{1, 8, 0.5},
+		{1, 10, 0.4},
+		// Bigger than the blockchain
+		{1, 100, 0.4},
+		{2, 10, 0.5},
+		{3, 10, 0},
+		{1, 0, 0},
+	}
+
+	for _, test := range tests {
+
+		if percent := bc.SignalPercent(test.bit, test.window); percent != test.expected {
+
+			t.Errorf("Bit %d, window %d: expected %f, got %f", test.bit, test.window, test.expected, percent)
+		}
+	}
+}
+
+func TestBlockRewardBreakdown(t *testing.T) {
+
+	defer func(interval uint32) { HalvingInterval = interval }(HalvingInterval)
+	HalvingInterval = 5
+
+	bc := new(Blockchain)
+	bc.Blocks = make([]Block, 6)
+
+	bc.Blocks[1].Txs = []transactions.LuTx{{Fee: 1000}, {Fee: 2500}}
+	bc.Blocks[5].Txs = []transactions.LuTx{{Fee: 4000}}
+
+	tests := []struct {
+		name            string
+		height          uint
+		expectedSubsidy uint64
+		expectedFees    uint64
+	}{
+		{"no txs", 0, utilities.ToLuncheon(200), 0},
+		{"two txs", 1, utilities.ToLuncheon(200), 3500},
+		{"after the first halving", 5, utilities.ToLuncheon(100), 4000},
+		{"no block", 6, 0, 0},
+	}
+
+	for _, test := range tests {
+
+		subsidy, fees := bc.BlockRewardBreakdown(test.height)
+
+		if subsidy != test.expectedSubsidy || fees != test.expectedFees {
+
+			t.Errorf("%s: expected a subsidy of %d and %d in fees, got %d and %d", test.name, test.expectedSubsidy, test.expectedFees, subsidy, fees)
+		}
+	}
+}
+
+func TestTopBalances(t *testing.T) {
+
+	bc := new(Blockchain)
+	reward := bc.GetBlockReward(0)
+
+	bc.Blocks = []Block{
+		{Miner: "alice"},
+		{Miner: "bob"},
+		{Miner: "alice", Txs: []transactions.LuTx{{TxFrom: "bob", TxTo: "carol", Value: reward / 2, Fee: 1000}}},
+		{Miner: "dave", Txs: []transactions.LuTx{{TxFrom: "alice", TxTo: "erin", Value: reward, Fee: 0}}},
+		{Miner: "frank", Txs: []transactions.LuTx{{TxFrom: "frank", TxTo: "gina", Value: reward, Fee: 0}}},
+	}
+
+	// The rest of the blocks are within the maturity window of the tip, so only the rewards of the first 3 blocks can be spent
+	for len(bc.Blocks) < 3+int(RewardMaturity)+1 {
+
+		bc.Blocks = append(bc.Blocks, Block{Miner: "frank"})
+	}
+
+	// Rewards and received values are counted like the ledger, and dave and frank have only mined immature rewards
+	expected := []AddressBalance{
+		{"alice", 2 * reward},
+		{"bob", reward},
+		{"erin", reward},
+		{"gina", reward},
+		{"carol", reward / 2},
+	}
+
+	if top := bc.TopBalances(100); !reflect.DeepEqual(top, expected) {
+
+		t.Errorf("Expected %+v, got %+v", expected, top)
+	}
+
+	if top := bc.TopBalances(3); !reflect.DeepEqual(top, expected[:3]) {
+
+		t.Errorf("Expected the top 3 to be %+v, got %+v", expected[:3], top)
+	}
+
+	// The ranking agrees with the ledger of each address
+	for _, balance := range bc.TopBalances(100) {
+
+		if indexed := bc.IndexedBalance(balance.Address); indexed != balance.Balance {
+
+			t.Errorf("%s: expected a balance of %d, got %d", balance.Address, indexed, balance.Balance)
+		}
+	}
+
+	if top := bc.TopBalances(0); len(top) != 0 {
+
+		t.Errorf("Expected no balances for n of 0, got %+v", top)
+	}
+
+	if top := new(Blockchain).TopBalances(10); len(top) != 0 {
+
+		t.Errorf("Expected no balances on an empty blockchain, got %+v", top)
+	}
+}
+
+func TestStaleBlockCount(t *testing.T) {
+
+	// Two chains that split after height 2
+	local := newLinkedChain("aa", "bb", "cc", "dd", "ee")
+	best := newLinkedChain("aa", "bb", "cc", "ff", "gg", "hh")
+
+	if local.StaleBlockCount() != 0 {
+
+		t.Fatalf("Expected no stale blocks before a reorg, got %d", local.StaleBlockCount())
+	}
+
+	// Reorg onto the best chain
+	height, _, ok := CommonAncestor(local, best)
+
+	if !ok {
+
+		t.Fatal("Expected the chains to have a common block")
+	}
+
+	local.RollbackTo(height)
+
+	for index := height + 1; index < uint(len(best.Blocks)); index += 1 {
+
+		local.AddBlock(&best.Blocks[index])
+	}
+
+	if local.StaleBlockCount() != 2 {
+
+		t.Errorf("Expected the 2 blocks that lost the reorg to be stale, got %d", local.StaleBlockCount())
+	}
+
+	// A second reorg adds to the count
+	local.RollbackTo(4)
+
+	if local.StaleBlockCount() != 3 {
+
+		t.Errorf("Expected 3 stale blocks after another reorg, got %d", local.StaleBlockCount())
+	}
+
+	if local.Copy().StaleBlockCount() != 3 {
+
+		t.Error("Expected a copy of the blockchain to keep its stale count")
+	}
+
+	// Rolling back to the tip removes nothing
+	local.RollbackTo(local.GetHeight())
+
+	if local.StaleBlockCount() != 3 {
+
+		t.Errorf("Expected a rollback that removes nothing to not change the count, got %d", local.StaleBlockCount())
+	}
+}
+
+func TestGetHeightEmpty(t *testing.T) {
+
+	bc := Blockchain{}
+
+	if bc.GetHeight() != 0 || bc.Len() != 0 {
+
+		t.Errorf("Expected an empty blockchain to have a height of 0 and no blocks, got %d and %d", bc.GetHeight(), bc.Len())
+	}
+
+	if _, found := bc.GetBlock(0); found {
+
+		t.Error("Expected no block on an empty blockchain")
+	}
+
+	// Emptied by RemoveBlock
+	bc.AddBlock(&Block{BlockHash: "aa"})
+
+	if bc.GetHeight() != 0 || bc.Len() != 1 {
+
+		t.Errorf("Expected only the genisis block, got height %d and %d blocks", bc.GetHeight(), bc.Len())
+	}
+
+	bc.RemoveBlock()
+	bc.RemoveBlock()
+	bc.RollbackTo(0)
+
+	if bc.GetHeight() != 0 || bc.Len() != 0 {
+
+		t.Errorf("Expected the emptied blockchain to have a height of 0 and no blocks, got %d and %d", bc.GetHeight(), bc.Len())
+	}
+
+	if _, found := bc.GetBlock(0); found {
+
+		t.Error("Expected no block on the emptied blockchain")
+	}
+}
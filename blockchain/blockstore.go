@@ -0,0 +1,326 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/TwiN/go-color"
+)
+
+/*
+This file contains the block store, which keeps the full blocks of a blockchain on disk.
+The blocks are appended to one file, each as the length of the block JSON (a little endian uint32) followed by the block JSON.
+Only the offset of each block in the file is kept in memory, and a block is read from the disk when it is asked for.
+A blockchain with a store can prune the txs of its old blocks from memory, and GetBlock reads them back from the store.
+*/
+
+// The error returned when a block is asked for that is not in the store.
+var ErrBlockNotStored = errors.New("block is not in the block store")
+
+// A file of full blocks, in order of their height.
+// Safe to use from many go-routines.
+type BlockStore struct {
+	mutex sync.Mutex
+
+	file *os.File
+
+	// The offset of each block in the file, by height, and the offset the next block is written at
+	offsets []int64
+	size    int64
+}
+
+// Opens the block store at the path inputted, creating it and its folder if it does not exist.
+// A block that was only half written (like from a crash) is cut off the end of the store.
+// Returns the store, or an error if it could not be opened.
+func OpenBlockStore(path string) (*BlockStore, error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+
+	if err != nil {
+
+		return nil, err
+	}
+
+	s := new(BlockStore)
+	s.file = file
+
+	// Find where each block starts
+	lengthBytes := make([]byte, 4)
+
+	for {
+
+		if _, err := file.ReadAt(lengthBytes, s.size); err != nil {
+
+			break
+		}
+
+		end := s.size + 4 + int64(binary.LittleEndian.Uint32(lengthBytes))
+
+		// If the block was not fully written
+		if info, err := file.Stat(); err != nil || end > info.Size() {
+
+			break
+		}
+
+		s.offsets = append(s.offsets, s.size)
+		s.size = end
+	}
+
+	// Cut off anything after the last full block
+	if err := file.Truncate(s.size); err != nil {
+
+		file.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Gets the amount of blocks in the store.
+// Returns the amount of blocks.
+func (s *BlockStore) Len() uint {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return uint(len(s.offsets))
+}
+
+// Writes a block to the end of the store, as the block after the last one.
+// Returns an error if the block could not be written.
+func (s *BlockStore) Append(block *Block) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	blockBytes, err := json.Marshal(block)
+
+	if err != nil {
+
+		return err
+	}
+
+	record := make([]byte, 4, 4+len(blockBytes))
+	binary.LittleEndian.PutUint32(record, uint32(len(blockBytes)))
+	record = append(record, blockBytes...)
+
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+
+		return err
+	}
+
+	s.offsets = append(s.offsets, s.size)
+	s.size += int64(len(record))
+
+	return nil
+}
+
+// Reads the block at a height from the disk.
+// Returns the block, or ErrBlockNotStored if the store does not have a block at the height.
+func (s *BlockStore) Get(height uint) (Block, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if height >= uint(len(s.offsets)) {
+
+		return Block{}, ErrBlockNotStored
+	}
+
+	// The block ends where the next one starts
+	end := s.size
+
+	if height+1 < uint(len(s.offsets)) {
+
+		end = s.offsets[height+1]
+	}
+
+	blockBytes := make([]byte, end-s.offsets[height]-4)
+
+	if _, err := s.file.ReadAt(blockBytes, s.offsets[height]+4); err != nil && err != io.EOF {
+
+		return Block{}, err
+	}
+
+	block := Block{}
+
+	if err := json.Unmarshal(blockBytes, &block); err != nil {
+
+		return Block{}, fmt.Errorf("block %d: %w", height, err)
+	}
+
+	return block, nil
+}
+
+// Removes every block at or above a height from the store, like when the blockchain removes blocks in a reorg.
+// Returns an error if the store could not be cut.
+func (s *BlockStore) Truncate(height uint) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if height >= uint(len(s.offsets)) {
+
+		return nil
+	}
+
+	if err := s.file.Truncate(s.offsets[height]); err != nil {
+
+		return err
+	}
+
+	s.size = s.offsets[height]
+	s.offsets = s.offsets[:height]
+
+	return nil
+}
+
+// Closes the file of the store.
+// Returns an error if the file could not be closed.
+func (s *BlockStore) Close() error {
+
+	return s.file.Close()
+}
+
+// Gives the blockchain a store to keep its full blocks in.
+// The store is brought up to date with the blockchain, and every block added or removed after is added to or removed from the store too.
+// Returns an error if the store could not be brought up to date.
+func (b *Blockchain) SetBlockStore(store *BlockStore) error {
+
+	// Blocks in the store that are not on the blockchain, like from before a reorg
+	if err := store.Truncate(uint(len(b.Blocks))); err != nil {
+
+		return err
+	}
+
+	for height := store.Len(); height < uint(len(b.Blocks)); height += 1 {
+
+		if err := store.Append(&b.Blocks[height]); err != nil {
+
+			return err
+		}
+	}
+
+	b.store = store
+
+	return nil
+}
+
+// Drops the txs of the blocks below a height from memory, keeping only their headers.
+// The full blocks are still read from the block store by GetBlock.
+// The blockchain reads the txs of pruned blocks from the store wherever it needs them (like the tx index and saves),
+// but code outside of the blockchain that reads Blocks directly only sees the headers of pruned blocks.
+// Returns an error if the blockchain has no block store.
+func (b *Blockchain) PruneBodies(height uint) error {
+
+	if b.store == nil {
+
+		return errors.New("the blockchain has no block store to prune to")
+	}
+
+	if height > uint(len(b.Blocks)) {
+
+		height = uint(len(b.Blocks))
+	}
+
+	for index := b.prunedBelow; index < height; index += 1 {
+
+		b.Blocks[index].Txs = nil
+	}
+
+	if height > b.prunedBelow {
+
+		b.prunedBelow = height
+	}
+
+	return nil
+}
+
+// Gets the block at a height with its txs, which are read from the block store if the block was pruned.
+// If the store could not be read, the header kept in memory is returned instead.
+// Returns the block.
+func (b *Blockchain) fullBlock(height uint) Block {
+
+	block, found := b.GetBlock(height)
+
+	if !found {
+
+		return b.Blocks[height]
+	}
+
+	return block
+}
+
+// Gets every block of the blockchain with its txs, reading the pruned blocks from the block store.
+// Returns the blocks, or an error if a pruned block could not be read, as its txs would be lost.
+func (b *Blockchain) fullBlocks() ([]Block, error) {
+
+	if b.prunedBelow == 0 {
+
+		return b.Blocks, nil
+	}
+
+	blocks := make([]Block, len(b.Blocks))
+	copy(blocks, b.Blocks)
+
+	for height := uint(0); height < b.prunedBelow; height += 1 {
+
+		block, err := b.store.Get(height)
+
+		if err != nil {
+
+			return nil, fmt.Errorf("could not read pruned block %d from the block store: %w", height, err)
+		}
+
+		blocks[height] = block
+	}
+
+	return blocks, nil
+}
+
+// Writes a block added to the blockchain to the block store, if the blockchain has one.
+// Returns nothing.
+func (b *Blockchain) storeBlock(block *Block) {
+
+	if b.store == nil {
+
+		return
+	}
+
+	if err := b.store.Append(block); err != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[BLOCKCHAIN]: Could not write the block to the block store. Err: ") + err.Error())
+	}
+}
+
+// Removes the blocks no longer on the blockchain from the block store, if the blockchain has one.
+// Returns nothing.
+func (b *Blockchain) unstoreBlocks() {
+
+	if b.store == nil {
+
+		return
+	}
+
+	if err := b.store.Truncate(uint(len(b.Blocks))); err != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[BLOCKCHAIN]: Could not remove the block from the block store. Err: ") + err.Error())
+	}
+
+	if b.prunedBelow > uint(len(b.Blocks)) {
+
+		b.prunedBelow = uint(len(b.Blocks))
+	}
+}
@@ -0,0 +1,217 @@
+package blockchain
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+)
+
+// Creates a blockchain where each block has a tx, with a block store in a temporary folder.
+// Returns the blockchain and the path of the store.
+func newStoredChain(t *testing.T, length int) (*Blockchain, string) {
+
+	bc := newLinkedChain("g")
+
+	for index := 1; index < length; index += 1 {
+
+		block := Block{PrevHash: bc.Blocks[index-1].BlockHash, BlockHash: string(rune('a' + index)), Timestamp: uint64(index)}
+		block.Txs = []transactions.LuTx{{TxFrom: "alice", TxTo: "bob", Value: uint64(index * 1000), Fee: 1000}}
+
+		bc.Blocks = append(bc.Blocks, block)
+	}
+
+	path := filepath.Join(t.TempDir(), "blocks", "blocks.dat")
+	store, err := OpenBlockStore(path)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { store.Close() })
+
+	if err := bc.SetBlockStore(store); err != nil {
+
+		t.Fatal(err)
+	}
+
+	return bc, path
+}
+
+func TestGetBlockFromStore(t *testing.T) {
+
+	bc, _ := newStoredChain(t, 10)
+
+	// The blocks from memory
+	expected := []Block{}
+
+	for height := uint(0); height < 10; height += 1 {
+
+		block, _ := bc.GetBlock(height)
+		expected = append(expected, block)
+	}
+
+	if err := bc.PruneBodies(8); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if bc.Blocks[7].Txs != nil || bc.Blocks[8].Txs == nil {
+
+		t.Error("Expected only the txs of the blocks below the prune height to be dropped from memory")
+	}
+
+	for height := uint(0); height < 10; height += 1 {
+
+		if block, found := bc.GetBlock(height); !found || !reflect.DeepEqual(block, expected[height]) {
+
+			t.Errorf("Block %d: expected %+v, got %+v", height, expected[height], block)
+		}
+	}
+}
+
+func TestBlockStoreReorg(t *testing.T) {
+
+	bc, path := newStoredChain(t, 10)
+
+	bc.RollbackTo(5)
+	bc.AddBlock(&Block{PrevHash: bc.Blocks[5].BlockHash, BlockHash: "fork", Timestamp: 100})
+
+	if bc.store.Len() != 7 {
+
+		t.Fatalf("Expected the store to follow the blockchain to 7 blocks, got %d", bc.store.Len())
+	}
+
+	// Reopened, the store has the blocks of the new chain
+	reopened, err := OpenBlockStore(path)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	defer reopened.Close()
+
+	for height := uint(0); height < 7; height += 1 {
+
+		if block, err := reopened.Get(height); err != nil || !reflect.DeepEqual(block, bc.Blocks[height]) {
+
+			t.Errorf("Block %d: expected %+v, got %+v (%v)", height, bc.Blocks[height], block, err)
+		}
+	}
+
+	if _, err := reopened.Get(7); err != ErrBlockNotStored {
+
+		t.Errorf("Expected no block past the tip, got %v", err)
+	}
+}
+
+func TestBlockStoreHalfWritten(t *testing.T) {
+
+	_, path := newStoredChain(t, 5)
+
+	// A crash while writing a block
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0640)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	file.Write([]byte{200, 0, 0, 0, '{'})
+	file.Close()
+
+	store, err := OpenBlockStore(path)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	if store.Len() != 5 {
+
+		t.Errorf("Expected the half written block to be cut off, got %d blocks", store.Len())
+	}
+
+	if err := store.Append(&Block{BlockHash: "next"}); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if block, err := store.Get(5); err != nil || block.BlockHash != "next" {
+
+		t.Errorf("Expected the next block to be written over the half written one, got %+v (%v)", block, err)
+	}
+}
+
+func TestPruneBodiesWithoutStore(t *testing.T) {
+
+	if err := newLinkedChain("g", "a").PruneBodies(1); err == nil {
+
+		t.Error("Expected pruning without a block store to fail")
+	}
+}
+
+func TestPrunedBlocksReadFromStore(t *testing.T) {
+
+	defer func(dataDir string) { DataDir = dataDir }(DataDir)
+	DataDir = t.TempDir()
+
+	bc, _ := newStoredChain(t, 10)
+
+	expected := make([]Block, len(bc.Blocks))
+	copy(expected, bc.Blocks)
+
+	if err := bc.PruneBodies(8); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if bc.Blocks[3].Txs != nil {
+
+		t.Fatalf("Expected block 3 to be pruned, got %+v", bc.Blocks[3])
+	}
+
+	// A tx of a pruned block is still found by its txid
+	txid := expected[3].Txs[0].HashTx()
+	block, height, found := bc.GetBlockByTxid(txid)
+
+	if !found || height != 3 || !reflect.DeepEqual(block, expected[3]) {
+
+		t.Errorf("Expected the pruned block 3 to be found by its txid, got %+v at %d (found: %t)", block, height, found)
+	}
+
+	if _, found := bc.TxFeeRate(txid); !found {
+
+		t.Error("Expected the fee rate of a pruned tx to be found")
+	}
+
+	if average := bc.AverageTxsPerBlock(10); average != 0.9 {
+
+		t.Errorf("Expected the pruned txs to be counted, got an average of %f", average)
+	}
+
+	// Saving after pruning keeps the txs of the pruned blocks
+	if err := bc.SaveBlockchain("pruned"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	loaded := new(Blockchain)
+	loaded.SetParams(bc.Params())
+
+	if err := loaded.LoadBlockchain("pruned"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded.Blocks, expected) {
+
+		t.Errorf("Expected the saved blockchain to have the full blocks, got %+v", loaded.Blocks)
+	}
+}
@@ -0,0 +1,121 @@
+package blockchain
+
+// Gets the available balance of a public key from the ledger, so the whole blockchain is not scanned for every balance.
+// The balance is counted the same way the wallet always has, as the block rewards the key mined and the tx values it received.
+// The ledger is kept as blocks are added and removed, so only blocks changed without AddBlock or RemoveBlock are scanned.
+// Block rewards only count once they are past RewardMaturity, which is the only part not kept in the ledger,
+// as it changes with every block, so the rewards of the newest blocks are taken back out.
+// Returns the balance of the public key, in LUNCHEON.
+func (b *Blockchain) IndexedBalance(pubKey string) uint64 {
+
+	b.syncLedger()
+
+	balance := b.balanceIndex[pubKey]
+
+	// The rewards within the maturity window can not be spent yet
+	for index := b.immatureHeight(); index < uint(len(b.Blocks)); index += 1 {
+
+		if b.Blocks[index].Miner == pubKey {
+
+			// A ledger that does not match the blocks should never happen, but it must never wrap around into a huge balance
+			if reward := b.GetBlockReward(uint32(index)); reward < balance {
+
+				balance -= reward
+			} else {
+
+				balance = 0
+			}
+		}
+	}
+
+	return balance
+}
+
+// Gets the height of the first block whose reward is still within the maturity window.
+// Returns the height, which is the length of the blockchain if no reward is immature.
+func (b *Blockchain) immatureHeight() uint {
+
+	height := b.GetHeight()
+
+	if height > RewardMaturity {
+
+		return height - RewardMaturity
+	}
+
+	return 0
+}
+
+// Gets the nonce of a public key from the ledger, which is the amount of txs it has sent.
+// Returns the nonce the next tx of the public key has to use.
+func (b *Blockchain) IndexedNonce(pubKey string) uint32 {
+
+	b.syncLedger()
+
+	return b.nonceIndex[pubKey]
+}
+
+// Brings the ledger up to date with the blocks of the blockchain.
+// Blocks are indexed the first time a balance or nonce is looked up after they are added.
+// Returns nothing.
+func (b *Blockchain) syncLedger() {
+
+	// If the ledger has not been made, or the blocks were changed without RemoveBlock
+	if b.balanceIndex == nil || b.ledgerBlocks > len(b.Blocks) {
+
+		b.balanceIndex = make(map[string]uint64)
+		b.nonceIndex = make(map[string]uint32)
+		b.ledgerBlocks = 0
+	}
+
+	for ; b.ledgerBlocks < len(b.Blocks); b.ledgerBlocks += 1 {
+
+		block := b.fullBlock(uint(b.ledgerBlocks))
+		b.ledgerAdd(&block, uint(b.ledgerBlocks))
+	}
+}
+
+// Adds the block reward and txs of a block to the ledger.
+// Input is the block and its height.
+// Returns nothing.
+func (b *Blockchain) ledgerAdd(block *Block, height uint) {
+
+	ApplyBlockToBalances(b.balanceIndex, block, b.GetBlockReward(uint32(height)))
+
+	for index := 0; index < len(block.Txs); index += 1 {
+
+		b.nonceIndex[block.Txs[index].TxFrom] += 1
+	}
+}
+
+// Takes the block reward and txs of a block back out of the ledger, the opposite of ledgerAdd.
+// Keys left with nothing are removed, so the ledger does not grow with keys that are no longer on the blockchain.
+// Input is the block and its height.
+// Returns nothing.
+func (b *Blockchain) ledgerRemove(block *Block, height uint) {
+
+	b.balanceIndex[block.Miner] -= b.GetBlockReward(uint32(height))
+
+	if b.balanceIndex[block.Miner] == 0 {
+
+		delete(b.balanceIndex, block.Miner)
+	}
+
+	for index := 0; index < len(block.Txs); index += 1 {
+
+		tx := &block.Txs[index]
+
+		b.balanceIndex[tx.TxTo] -= tx.Value
+
+		if b.balanceIndex[tx.TxTo] == 0 {
+
+			delete(b.balanceIndex, tx.TxTo)
+		}
+
+		b.nonceIndex[tx.TxFrom] -= 1
+
+		if b.nonceIndex[tx.TxFrom] == 0 {
+
+			delete(b.nonceIndex, tx.TxFrom)
+		}
+	}
+}
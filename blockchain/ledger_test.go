@@ -0,0 +1,168 @@
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+)
+
+// Scans every block for the balance and nonce of a public key, the way the wallet did before the ledger.
+// Returns the balance and nonce of the public key.
+func scanLedger(bc *Blockchain, pubKey string) (balance uint64, nonce uint32) {
+
+	for index := 0; index < len(bc.Blocks); index += 1 {
+
+		block, _ := bc.GetBlock(uint(index))
+
+		if block.Miner == pubKey && uint(index)+RewardMaturity < bc.GetHeight() {
+
+			balance += bc.GetBlockReward(uint32(index))
+		}
+
+		for txIndex := 0; txIndex < len(block.Txs); txIndex += 1 {
+
+			if block.Txs[txIndex].TxTo == pubKey {
+
+				balance += block.Txs[txIndex].Value
+			}
+
+			if block.Txs[txIndex].TxFrom == pubKey {
+
+				nonce += 1
+			}
+		}
+	}
+
+	return balance, nonce
+}
+
+// Checks that the ledger of the blockchain matches a full scan, for every key inputted.
+// Returns nothing.
+func checkLedger(t *testing.T, bc *Blockchain, step string, pubKeys []string) {
+
+	t.Helper()
+
+	for _, pubKey := range pubKeys {
+
+		balance, nonce := scanLedger(bc, pubKey)
+
+		if indexed := bc.IndexedBalance(pubKey); indexed != balance {
+
+			t.Errorf("%s: expected %s to have a balance of %d, got %d", step, pubKey, balance, indexed)
+		}
+
+		if indexed := bc.IndexedNonce(pubKey); indexed != nonce {
+
+			t.Errorf("%s: expected %s to have a nonce of %d, got %d", step, pubKey, nonce, indexed)
+		}
+	}
+}
+
+func TestLedgerMatchesFullScan(t *testing.T) {
+
+	pubKeys := []string{"aa", "bb", "cc", "dd"}
+
+	// Each block is mined by one of the keys, and sends from one key to the next
+	newBlock := func(index int) Block {
+
+		block := Block{Miner: pubKeys[index%3]}
+
+		for txIndex := 0; txIndex < index%4; txIndex += 1 {
+
+			block.Txs = append(block.Txs, transactions.LuTx{
+				TxFrom: pubKeys[(index+txIndex)%4],
+				TxTo:   pubKeys[(index+txIndex+1)%4],
+				Value:  uint64(1000 * (index + txIndex + 1)),
+			})
+		}
+
+		return block
+	}
+
+	bc := new(Blockchain)
+	checkLedger(t, bc, "empty", pubKeys)
+
+	// Past the maturity window, so rewards mature as blocks are added
+	for index := 0; index < int(RewardMaturity)+10; index += 1 {
+
+		block := newBlock(index)
+		bc.AddBlock(&block)
+		checkLedger(t, bc, "add", pubKeys)
+	}
+
+	for index := 0; index < 5; index += 1 {
+
+		bc.RemoveBlock()
+		checkLedger(t, bc, "remove", pubKeys)
+	}
+
+	// A reorg onto different blocks
+	bc.RollbackTo(10)
+	checkLedger(t, bc, "rollback", pubKeys)
+
+	for index := 100; index < 108; index += 1 {
+
+		block := newBlock(index)
+		bc.AddBlock(&block)
+		checkLedger(t, bc, "reorg", pubKeys)
+	}
+
+	// Blocks changed without AddBlock or RemoveBlock make the ledger start over
+	bc.Blocks = bc.Blocks[:5]
+	checkLedger(t, bc, "truncated", pubKeys)
+
+	for bc.Len() != 0 {
+
+		bc.RemoveBlock()
+		checkLedger(t, bc, "remove all", pubKeys)
+	}
+
+	if len(bc.balanceIndex) != 0 || len(bc.nonceIndex) != 0 {
+
+		t.Errorf("Expected the ledger to be empty with no blocks, got %v and %v", bc.balanceIndex, bc.nonceIndex)
+	}
+}
+
+func TestLedgerPrunedBlocks(t *testing.T) {
+
+	store, err := OpenBlockStore(filepath.Join(t.TempDir(), "blocks", "blocks.dat"))
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { store.Close() })
+
+	bc := new(Blockchain)
+
+	if err := bc.SetBlockStore(store); err != nil {
+
+		t.Fatal(err)
+	}
+
+	for index := 0; index < 15; index += 1 {
+
+		bc.AddBlock(&Block{Miner: "aa", Txs: []transactions.LuTx{{TxFrom: "aa", TxTo: "bb", Value: 10}}})
+	}
+
+	if err := bc.PruneBodies(10); err != nil {
+
+		t.Fatal(err)
+	}
+
+	// The ledger is made after pruning, so the pruned txs are read from the store
+	if balance, nonce := bc.IndexedBalance("bb"), bc.IndexedNonce("aa"); balance != 150 || nonce != 15 {
+
+		t.Errorf("Expected the pruned txs to be counted, got a balance of %d and a nonce of %d", balance, nonce)
+	}
+
+	// Removing pruned blocks takes their txs out of the ledger
+	bc.RollbackTo(4)
+
+	if balance, nonce := bc.IndexedBalance("bb"), bc.IndexedNonce("aa"); balance != 50 || nonce != 5 {
+
+		t.Errorf("Expected the removed txs to be taken out, got a balance of %d and a nonce of %d", balance, nonce)
+	}
+}
@@ -2,75 +2,160 @@ package blockchain
 
 import (
 	"encoding/hex"
+	"errors"
 
 	"golang.org/x/crypto/sha3"
 )
 
 // Function takes all of the transactions in the block,
 // and gets their merkle root.
-// Returns the hash string of the merkle root.
+// The merkle tree is a binary tree, whose leaves are the hashes of the bytes of each tx (in the order of the block).
+// Each pair of hashes is hashed together into the level above, and the last hash of a level with an odd count is paired with itself.
+// A block with one tx has the hash of that tx as its root.
+// Returns the hash string of the merkle root, or an empty string if the block has no txs.
 func (b *Block) GetMerkleRoot() string {
 
-	// Init the length
-	leng := len(b.Txs)
-
 	// If there are no txs
-	if leng == 0 {
+	if len(b.Txs) == 0 {
 
 		return ""
 	}
 
-	// Save a copy of the tx's
-	txCopy := b.Txs
+	level := b.merkleLeaves()
+
+	// Hash each level into the one above it, until only the root is left
+	for len(level) > 1 {
+
+		level = nextMerkleLevel(level)
+	}
+
+	return hex.EncodeToString(level[0])
+}
+
+// Hashes each tx of the block into a leaf of its merkle tree.
+// Each leaf is the hash of the tx, the same as the bytes of its HashTx.
+// Returns the leaves, in the order of the txs.
+func (b *Block) merkleLeaves() [][]byte {
 
-	// Is the total amount of tx's odd?
-	if leng%2 == 1 {
+	leaves := make([][]byte, len(b.Txs))
 
-		// Makes the tranaction amount even
-		txCopy = append(txCopy, txCopy[leng-1])
+	for index := 0; index < len(b.Txs); index += 1 {
 
-		// Adjusts the length to be accurate
-		leng += 1
+		leaves[index] = make([]byte, 32)
+		sha3.ShakeSum256(leaves[index], b.Txs[index].AsBytes())
 	}
 
-	hashStrs := make([]string, leng)
+	return leaves
+}
+
+// Hashes a level of the merkle tree into the level above it, pairing the last hash with itself if the level has an odd count.
+// Returns the level above, with half as many hashes (rounded up).
+func nextMerkleLevel(level [][]byte) [][]byte {
+
+	next := make([][]byte, 0, (len(level)+1)/2)
 
-	// Move all of the transactions bytes into a hex encoded string array
-	for index := 0; index < leng; index += 1 {
+	for index := 0; index < len(level); index += 2 {
 
-		hashStrs[index] = hex.EncodeToString(txCopy[index].AsBytes())
+		// The last hash of an odd level has no pair, so it is its own pair
+		right := level[index]
+
+		if index+1 < len(level) {
+
+			right = level[index+1]
+		}
+
+		next = append(next, hashMerklePair(level[index], right))
 	}
 
-	// Gets the merkle root
-	for index := 0; leng != 1; index += 2 {
+	return next
+}
+
+// Hashes two hashes of the merkle tree into the hash above them.
+// Returns the hash of left + right.
+func hashMerklePair(left []byte, right []byte) []byte {
+
+	pair := make([]byte, 0, len(left)+len(right))
+	pair = append(pair, left...)
+	pair = append(pair, right...)
 
-		// Init the hash
-		hash := make([]byte, 32)
+	hash := make([]byte, 32)
+	sha3.ShakeSum256(hash, pair)
 
-		// Prepare selected the data for hashing (the pairs ex: 0 and 1, 2 and 3)
-		byteData, err := hex.DecodeString(hashStrs[index] + hashStrs[index+1])
+	return hash
+}
 
-		// Was there an error?
-		if err != nil {
-			panic(err)
+// Recomputes the merkle root from the txs of the block, and checks it against a root from somewhere else, like a peer or a block explorer.
+// The MerkleRoot field of the block is not used, so a block can be checked against a root it was not built with.
+// Input is the hex string of the expected merkle root.
+// Returns true if the txs of the block have the expected merkle root, false if not.
+func (b *Block) VerifyMerkleRoot(expected string) bool {
+
+	return b.GetMerkleRoot() == expected
+}
+
+// One level of a merkle proof, the hash a tx is paired with on its way up to the root.
+type ProofStep struct {
+	// The hash the running hash is paired with
+	Hash []byte
+
+	// True if Hash is the left of the pair, false if it is the right
+	Left bool
+}
+
+// The error returned when a merkle proof is asked for a tx that is not in the block.
+var ErrTxIndexOutOfRange = errors.New("tx index is out of range of the block")
+
+// Gets the proof that a tx is in the block, so a light client can check it against the merkle root without every tx.
+// The proof follows the same rules as GetMerkleRoot, so the last hash of an odd level is paired with itself.
+// Input is the index of the tx in the block.
+// Returns the steps from the leaf of the tx up to the root (none if it is the only tx), or ErrTxIndexOutOfRange.
+func (b *Block) GetMerkleProof(txIndex int) ([]ProofStep, error) {
+
+	if txIndex < 0 || txIndex >= len(b.Txs) {
+
+		return nil, ErrTxIndexOutOfRange
+	}
+
+	proof := []ProofStep{}
+	level := b.merkleLeaves()
+
+	for len(level) > 1 {
+
+		// The pair of an even index is to its right, and of an odd index is to its left
+		sibling := txIndex ^ 1
+
+		// The last hash of an odd level is its own pair
+		if sibling >= len(level) {
+
+			sibling = txIndex
 		}
 
-		// Do the hash
-		sha3.ShakeSum256(hash, byteData)
+		proof = append(proof, ProofStep{Hash: level[sibling], Left: txIndex%2 == 1})
+
+		level = nextMerkleLevel(level)
+		txIndex /= 2
+	}
+
+	return proof, nil
+}
+
+// Checks a merkle proof from GetMerkleProof, by hashing the tx up through the proof and comparing it to the root.
+// Inputs are the hash of the tx (the bytes of its HashTx), the proof, and the hex string of the merkle root of the block.
+// Returns true if the proof shows the tx is in the block with the root, false if not.
+func VerifyMerkleProof(txHash []byte, proof []ProofStep, root string) bool {
+
+	hash := txHash
 
-		// Sets the data on the lower end indexes of the array, ex: 0,1,2,3 becomes 0,1
-		hashStrs[index/2] = hex.EncodeToString(hash)
+	for index := 0; index < len(proof); index += 1 {
 
-		// Stops the index from going out of range
-		if (index + 2) == leng {
+		if proof[index].Left {
 
-			// Sets it to -2 because when it loops again, -2 + 2 = 0 and it needs to be set back to 0
-			index = -2
+			hash = hashMerklePair(proof[index].Hash, hash)
+		} else {
 
-			// Half the length because the total data has been halved
-			leng /= 2
+			hash = hashMerklePair(hash, proof[index].Hash)
 		}
 	}
 
-	return hashStrs[0]
+	return root != "" && hex.EncodeToString(hash) == root
 }
@@ -1,6 +1,9 @@
 package blockchain
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -8,45 +11,223 @@ import (
 	"golang.org/x/crypto/sha3"
 )
 
+// Hashes the data inputted, the same way the merkle tree does.
+// Returns the hash.
+func testHash(data ...[]byte) []byte {
+
+	joined := []byte{}
+
+	for _, part := range data {
+
+		joined = append(joined, part...)
+	}
+
+	hash := make([]byte, 32)
+	sha3.ShakeSum256(hash, joined)
+
+	return hash
+}
+
 func TestMerkle(t *testing.T) {
 
-	block := new(Block)
+	txs := []transactions.LuTx{}
+
+	for index := 0; index < 5; index += 1 {
 
-	tx1 := new(transactions.LuTx)
-	tx2 := new(transactions.LuTx)
-	tx3 := new(transactions.LuTx)
+		tx := new(transactions.LuTx)
+		tx.AddScriptStr(fmt.Sprintf("PUBKH %d", 123+index))
 
-	tx1.AddScriptStr("PUBKH 123")
-	tx2.AddScriptStr("PUBKH 124")
-	tx3.AddScriptStr("PUBKH 125")
+		txs = append(txs, *tx)
+	}
 
 	// By hand way
+	leaves := [][]byte{}
+
+	for index := range txs {
+
+		leaves = append(leaves, testHash(txs[index].AsBytes()))
+	}
+
+	pair01 := testHash(leaves[0], leaves[1])
+	pair22 := testHash(leaves[2], leaves[2])
+	pair23 := testHash(leaves[2], leaves[3])
+	pair44 := testHash(leaves[4], leaves[4])
+	pair0123 := testHash(pair01, pair23)
+	pair4444 := testHash(pair44, pair44)
+
+	tests := []struct {
+		txs      int
+		expected []byte
+	}{
+		{1, leaves[0]},
+		{2, pair01},
+		{3, testHash(pair01, pair22)},
+		{4, pair0123},
+
+		// The odd hash is paired with itself above the leaves too
+		{5, testHash(pair0123, pair4444)},
+	}
+
+	for _, test := range tests {
+
+		block := Block{Txs: txs[:test.txs]}
+
+		if root := block.GetMerkleRoot(); root != hex.EncodeToString(test.expected) {
+
+			t.Errorf("%d txs: expected the root %x, got %s", test.txs, test.expected, root)
+		}
+	}
+
+	if root := new(Block).GetMerkleRoot(); root != "" {
+
+		t.Errorf("Expected a block with no txs to have an empty root, got %s", root)
+	}
+}
+
+func TestMerkleRootChangesWithTxs(t *testing.T) {
+
+	for txCount := 1; txCount <= 4; txCount += 1 {
+
+		block := new(Block)
+
+		for index := 0; index < txCount; index += 1 {
+
+			block.Txs = append(block.Txs, transactions.LuTx{TxFrom: "aa", TxTo: "bb", Value: uint64(index), Fee: 10})
+		}
+
+		root := block.GetMerkleRoot()
+
+		// Changing any tx changes the root
+		for index := 0; index < txCount; index += 1 {
+
+			block.Txs[index].Fee += 1
+
+			if block.GetMerkleRoot() == root {
+
+				t.Errorf("%d txs: expected changing tx %d to change the root", txCount, index)
+			}
+
+			block.Txs[index].Fee -= 1
+		}
+
+		if block.GetMerkleRoot() != root {
+
+			t.Errorf("%d txs: expected the same txs to always have the same root", txCount)
+		}
+
+		// Swapping the txs changes the root too
+		if txCount > 1 {
+
+			block.Txs[0], block.Txs[1] = block.Txs[1], block.Txs[0]
+
+			if block.GetMerkleRoot() == root {
+
+				t.Errorf("%d txs: expected the order of the txs to change the root", txCount)
+			}
+		}
+	}
+}
+
+func TestVerifyMerkleRoot(t *testing.T) {
+
+	block := new(Block)
+	block.Txs = []transactions.LuTx{
+		{TxFrom: "aa", TxTo: "bb", Value: 100, Fee: 10, Signature: "cc"},
+		{TxFrom: "bb", TxTo: "aa", Value: 50, Nonce: 3, Fee: 20, Signature: "dd"},
+		{TxFrom: "ee", TxTo: "ff", Value: 1, Nonce: 7, Fee: 30, Signature: "gg"},
+	}
+
+	root := block.GetMerkleRoot()
+
+	// The stored field is not what is checked
+	block.MerkleRoot = "not the root"
+
+	if !block.VerifyMerkleRoot(root) {
+
+		t.Error("Expected the root of the txs to match")
+	}
+
+	if block.VerifyMerkleRoot(block.MerkleRoot) {
+
+		t.Error("Expected a root that is not of the txs to not match")
+	}
+
+	// Changing a tx changes the root
+	block.Txs[2].Value += 1
+
+	if block.VerifyMerkleRoot(root) {
+
+		t.Error("Expected the old root to not match changed txs")
+	}
+
+	if !new(Block).VerifyMerkleRoot("") {
+
+		t.Error("Expected a block with no txs to match the empty root")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+
+	for txCount := 1; txCount <= 7; txCount += 1 {
+
+		block := new(Block)
+
+		for index := 0; index < txCount; index += 1 {
+
+			block.Txs = append(block.Txs, transactions.LuTx{TxFrom: "aa", TxTo: "bb", Value: uint64(index), Fee: 10})
+		}
+
+		root := block.GetMerkleRoot()
+
+		for index := 0; index < txCount; index += 1 {
+
+			proof, err := block.GetMerkleProof(index)
+
+			if err != nil {
+
+				t.Fatalf("%d txs, tx %d: %v", txCount, index, err)
+			}
+
+			txHash, _ := hex.DecodeString(block.Txs[index].HashTx())
+
+			if !VerifyMerkleProof(txHash, proof, root) {
+
+				t.Errorf("%d txs, tx %d: expected the proof to verify", txCount, index)
+			}
+
+			// A tampered tx hash does not lead to the root
+			tampered := append([]byte{}, txHash...)
+			tampered[0] ^= 1
+
+			if VerifyMerkleProof(tampered, proof, root) {
 
-	tx1Bytes := tx1.AsBytes()
-	tx2Bytes := tx2.AsBytes()
-	tx3Bytes := tx3.AsBytes()
+				t.Errorf("%d txs, tx %d: expected a tampered tx hash to fail", txCount, index)
+			}
 
-	tx1Bytes = append(tx1Bytes, tx2Bytes...)
-	tx3Bytes = append(tx3Bytes, tx3Bytes...)
+			// Neither does a proof with a flipped side, when the pair is not the tx itself
+			if len(proof) != 0 && !bytes.Equal(proof[0].Hash, txHash) {
 
-	hash1 := make([]byte, 32)
-	hash2 := make([]byte, 32)
+				proof[0].Left = !proof[0].Left
 
-	sha3.ShakeSum256(hash1, tx1Bytes)
-	sha3.ShakeSum256(hash2, tx3Bytes)
+				if VerifyMerkleProof(txHash, proof, root) {
 
-	hash1 = append(hash1, hash2...)
+					t.Errorf("%d txs, tx %d: expected a proof with a flipped side to fail", txCount, index)
+				}
+			}
+		}
 
-	hash3 := make([]byte, 32)
-	sha3.ShakeSum256(hash3, hash1)
+		for _, index := range []int{-1, txCount} {
 
-	fmt.Printf("Merkle Root: %x\n", hash3)
+			if _, err := block.GetMerkleProof(index); !errors.Is(err, ErrTxIndexOutOfRange) {
 
-	// Actual Way
+				t.Errorf("%d txs, tx %d: expected %v, got %v", txCount, index, ErrTxIndexOutOfRange, err)
+			}
+		}
+	}
 
-	block.Txs = append(block.Txs, *tx1)
-	block.Txs = append(block.Txs, *tx2)
-	block.Txs = append(block.Txs, *tx3)
+	// A block with no txs has nothing to prove
+	if VerifyMerkleProof(make([]byte, 32), nil, new(Block).GetMerkleRoot()) {
 
-	fmt.Println("Merkle Root:", block.GetMerkleRoot())
+		t.Error("Expected nothing to verify against the empty root")
+	}
 }
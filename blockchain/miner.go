@@ -2,8 +2,16 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
 
 	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
 	"github.com/TwiN/go-color"
@@ -12,8 +20,15 @@ import (
 
 // The struct that handles the mining. Uses the shake256 varient of sha3 for hashing.
 // Here is how the miner handles block hashing. (This is the order of the append list) (adding all the info together)
-// SoftwareVersion + PrevBlockHash + MerkleRoot + PackedTarget + Time + Nonce
+// SoftwareVersion + PrevBlockHash + MerkleRoot + PackedTarget + Time + ExtraNonce + VersionBits (if any) + Nonce
+// Everything but the nonce comes from Block.ParseBlockToBytes, and every hash goes through hashHeader.
 type Miner struct {
+	// Where the miner prints its progress, os.Stdout if not set
+	Out io.Writer
+
+	// The clock the timestamps of mined blocks come from, the real clock if not set
+	Clock utilities.TimeSource
+
 	currentHash    []byte
 	unpackedTarget []byte
 	blocksFound    uint
@@ -22,52 +37,228 @@ type Miner struct {
 	util     utilities.ByteUtil
 	unpacker utilities.TargetUnpacker
 	utilTime utilities.Time
+
+	// The block that Restart has swapped in, waiting to be picked up by the mining loop
+	restartLock  sync.Mutex
+	restartBlock *Block
+	restarting   uint32
+}
+
+// Swaps the block the miner is working on for a new one, without stopping the miner.
+// Used when a new tip arrives mid-mining, so the stale block is abandoned.
+// The miner picks up the new block on its next hash and starts its nonce sweep over from 0.
+// Returns nothing.
+func (m *Miner) Restart(newBlock Block) {
+
+	m.restartLock.Lock()
+	m.restartBlock = &newBlock
+	m.restartLock.Unlock()
+
+	// Tell the mining loop that a new block is waiting
+	atomic.StoreUint32(&m.restarting, 1)
+}
+
+// Moves the block given to Restart into the block being mined.
+// Only intended to be used by the mining loop.
+// Returns nothing.
+func (m *Miner) swapBlock(b *Block, bc *Blockchain) {
+
+	m.restartLock.Lock()
+	defer m.restartLock.Unlock()
+
+	*b = *m.restartBlock
+	m.restartBlock = nil
+	atomic.StoreUint32(&m.restarting, 0)
+
+	// Reset the miner for the new block
+	b.Nonce = 0
+
+	if bc != nil {
+
+		m.startHeight = bc.GetHeight()
+	}
+
+	fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Yellow, "Restarting on a new block..."))
+}
+
+// Gets the current time from the clock of the miner.
+// Returns the unix time in seconds.
+func (m *Miner) now() uint64 {
+
+	if m.Clock == nil {
+
+		return m.utilTime.Now()
+	}
+
+	return m.Clock.Now()
+}
+
+// Gets the timestamp to mine a block with, which is after the block it builds on.
+// The timestamp never goes back from the one the block has (like the one CreateBlock gives), as that is after the block it builds on.
+// Input is the block, and the blockchain it builds on (nil if there is none to check).
+// Returns the timestamp.
+func (m *Miner) timestamp(b *Block, bc *Blockchain) uint64 {
+
+	timestamp := m.now()
+
+	if bc != nil {
+
+		timestamp = bc.NextTimestamp(b.PrevHash, timestamp)
+	}
+
+	if timestamp < b.Timestamp {
+
+		return b.Timestamp
+	}
+
+	return timestamp
+}
+
+// The error returned when the miner is given a block that has a target of zero.
+// No hash can be at or below a target of zero, so the block could never be found.
+var ErrZeroTarget = errors.New("cannot mine a block with a target of zero")
+
+// The error returned when the miner is given a block with a packed target that can not be unpacked.
+var ErrMalformedTarget = errors.New("cannot mine a block with a malformed packed target")
+
+// Checks the packed target of the block being mined, and unpacks it into the miner.
+// The exponent (first byte) must be between 3 and 32, as anything else shifts the target out of the 256 bits.
+// Returns an error if the target can not be mined, nil if it is good.
+func (m *Miner) inputTarget(packedTarget uint32) error {
+
+	// A zero target would make the miner loop forever
+	if packedTarget&0x00ffffff == 0 {
+
+		return ErrZeroTarget
+	}
+
+	exponent := packedTarget >> (3 * 8)
+
+	if exponent < 3 || exponent > 32 {
+
+		return ErrMalformedTarget
+	}
+
+	// Gets the unpacked target with the unpacker struct
+	m.unpackedTarget = m.unpacker.UnpackAsBytes(packedTarget)
+
+	return nil
 }
 
 // Starts the miner with the inputted block.
+// The timestamp of the block is set once, when mining starts, and only changes if every nonce was tried without finding the block.
+// So the timestamp is when the block started being mined, not when it was found.
 // Will stop if the block is found and added to the blockchain seperatly.
-// Returns true if it found the block.
-func (m *Miner) Start(b *Block, bc *Blockchain, difficulty uint64) bool {
+// Returns true if it found the block, and an error if the block can not be mined.
+func (m *Miner) Start(b *Block, bc *Blockchain, difficulty uint64) (bool, error) {
+
+	return m.mine(context.Background(), b, bc, difficulty, 0)
+}
+
+// The error returned when the context of the miner is canceled before the block is found.
+var ErrMiningCanceled = errors.New("mining was canceled")
+
+// Starts the miner with the inputted block, mining until it is found or the context is canceled.
+// Used to stop mining a block that went stale, like when a peer sends a new block.
+// The context is checked every 65536 nonces, so the miner stops soon after it is canceled.
+// The block is not checked against a blockchain, so the miner will not notice if it was already found.
+// Returns the solved block, or ErrMiningCanceled if the context was canceled (or an error if the block can not be mined).
+func (m *Miner) StartContext(ctx context.Context, b Block) (Block, error) {
+
+	found, err := m.mine(ctx, &b, nil, 0, 0)
+
+	if err == nil && !found {
+
+		err = ErrMiningCanceled
+	}
+
+	return b, err
+}
+
+// Starts the miner with the inputted block, but gives up after maxHashes attempts.
+// Lets the caller roll the timestamp or txs of the block and try again, instead of sweeping the whole nonce space.
+// The block is not checked against a blockchain, so the miner will not notice if it was already found.
+// Returns the block (solved if found), true if it found the block, and an error if the block can not be mined.
+func (m *Miner) StartBudget(b Block, maxHashes uint64) (Block, bool, error) {
+
+	found, err := m.mine(context.Background(), &b, nil, 0, maxHashes)
+
+	return b, found, err
+}
+
+// The mining loop used by Start, StartContext and StartBudget.
+// A maxHashes of 0 mines until the block is found, and a nil bc skips the checks for the block already being found.
+// Returns true if it found the block, and an error if the block can not be mined or the context was canceled.
+func (m *Miner) mine(ctx context.Context, b *Block, bc *Blockchain, difficulty uint64, maxHashes uint64) (bool, error) {
 
 	//****
 	// Prepare the miner
 
-	m.startHeight = bc.GetHeight()
+	// Print to the terminal if nowhere else was given
+	if m.Out == nil {
 
-	// Gets the unpacked target with the unpacker struct
-	m.unpackedTarget = m.unpacker.UnpackAsBytes(b.PackedTarget)
+		m.Out = os.Stdout
+	}
+
+	if bc != nil {
+
+		m.startHeight = bc.GetHeight()
+	}
+
+	// Gets the unpacked target, if the block has a target that can be mined
+	if err := m.inputTarget(b.PackedTarget); err != nil {
+
+		return false, err
+	}
 
 	// Init the timer used for calculating MH/s
 	timer := m.utilTime.Timer()
 
-	fmt.Println("[MINER]:", color.Colorize(color.Yellow, "New Block!"))
+	fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Yellow, "New Block!"))
 
 	// Prepare the miner
 	//****
 
 	// The actual mining process
-	for b.Nonce = 0; b.Nonce <= 0xFFFFFFFF; b.Nonce++ {
+	b.Nonce = 0
+	b.Timestamp = m.timestamp(b, bc)
+
+	// The header only changes with the timestamp or a new block, so it is not rebuilt for every nonce
+	header := b.ParseBlockToBytes()
+
+	for hashes := uint64(0); maxHashes == 0 || hashes < maxHashes; hashes, b.Nonce = hashes+1, b.Nonce+1 {
 
 		//****
 		// Var changes in the process
 
-		// Set the timestamp in the block
-		b.Timestamp = m.utilTime.CurrentUnix()
+		// Checking the context every hash would slow the miner down
+		if b.Nonce%65536 == 0 && ctx.Err() != nil {
 
-		// Get the block as bytes for mining
-		softwareVersion := []byte(utilities.SoftwareVersion)
-		prevBlockHash, _ := hex.DecodeString(b.PrevHash)
-		merkleRoot, _ := hex.DecodeString(b.MerkleRoot)
-		blockTime := m.util.Uint64toB(b.Timestamp)
-		packedTargetBytes := m.util.Uint32toB(b.PackedTarget)
-		nonceBytes := m.util.Uint32toB(b.Nonce)
+			fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Yellow, "Mining canceled."))
+			return false, ErrMiningCanceled
+		}
+
+		// If a new block was given to the miner, start working on it instead
+		if atomic.LoadUint32(&m.restarting) == 1 {
+
+			m.swapBlock(b, bc)
+
+			// The new block needs its own target unpacked
+			if err := m.inputTarget(b.PackedTarget); err != nil {
+
+				return false, err
+			}
+
+			b.Timestamp = m.timestamp(b, bc)
+			header = b.ParseBlockToBytes()
+		}
+
+		// Every nonce was tried, so a new timestamp gives the miner new hashes to try
+		if hashes != 0 && b.Nonce == 0 {
 
-		// Shove them together (into softwareVerion var bc it is first declared)
-		softwareVersion = append(softwareVersion, prevBlockHash...)
-		softwareVersion = append(softwareVersion, merkleRoot...)
-		softwareVersion = append(softwareVersion, packedTargetBytes...)
-		softwareVersion = append(softwareVersion, blockTime...)
-		softwareVersion = append(softwareVersion, nonceBytes...)
+			b.Timestamp = m.timestamp(b, bc)
+			header = b.ParseBlockToBytes()
+		}
 
 		// Init the size of the hash
 		m.currentHash = make([]byte, 32)
@@ -78,52 +269,52 @@ func (m *Miner) Start(b *Block, bc *Blockchain, difficulty uint64) bool {
 		//****
 		// Mining
 
-		// Hash the data
-		sha3.ShakeSum256(m.currentHash, softwareVersion)
+		// Hash the header, with the nonce on the end
+		hashHeader(m.currentHash, header, b.Nonce)
 
 		// Was the solution found?
 		if bytes.Compare(m.currentHash, m.unpackedTarget) != 1 {
 
 			// Check if the block has already been found
-			if m.startHeight != bc.GetHeight() {
+			if bc != nil && m.startHeight != bc.GetHeight() {
 
-				fmt.Println("[MINER]:", color.Colorize(color.Yellow, "Found solution to old block. Scrapping old block..."))
-				return false
+				fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Yellow, "Found solution to old block. Scrapping old block..."))
+				return false, nil
 			}
 
 			// Set the block hash to the winning hash
 			b.BlockHash = hex.EncodeToString(m.currentHash)
 
-			fmt.Println("[MINER]:", color.Colorize(color.Green, "Block Found!"))
+			fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Green, "Block Found!"))
 
 			m.blocksFound += 1
 
-			return true
+			return true, nil
 		}
 
 		// Prints stats every 20 MHs
 		if b.Nonce%20000000 == 0 {
 
 			// Check if the block has already been found
-			if m.startHeight != bc.GetHeight() {
+			if bc != nil && m.startHeight != bc.GetHeight() {
 
-				fmt.Println("[MINER]:", color.Colorize(color.Yellow, "Scrapping old block..."))
-				return false
+				fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Yellow, "Scrapping old block..."))
+				return false, nil
 			}
 
 			timer = m.utilTime.Timer()
 
 			if timer != 0 {
 
-				fmt.Println("!==========!")
+				fmt.Fprintln(m.Out, "!==========!")
 
-				fmt.Println("[MINER]:", color.Colorize(color.Yellow, "Mining..."))
-				fmt.Println("[MINER]:", m.utilTime.CurrentTime())
-				fmt.Printf("[MINER]: Heres a random of the hashes: %x\n", m.currentHash)
-				fmt.Println("[MINER]: Current Difficulty:", difficulty, "| Blocks Found:", m.blocksFound)
-				fmt.Println("[MINER]: Average Hashing Speed: ", ((20000000/timer)*60)/1000000, " MH / per minute.")
+				fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Yellow, "Mining..."))
+				fmt.Fprintln(m.Out, "[MINER]:", m.utilTime.CurrentTime())
+				fmt.Fprintf(m.Out, "[MINER]: Heres a random of the hashes: %x\n", m.currentHash)
+				fmt.Fprintln(m.Out, "[MINER]: Current Difficulty:", difficulty, "| Blocks Found:", m.blocksFound)
+				fmt.Fprintln(m.Out, "[MINER]: Average Hashing Speed: ", ((20000000/timer)*60)/1000000, " MH / per minute.")
 
-				fmt.Println("!==========!")
+				fmt.Fprintln(m.Out, "!==========!")
 			}
 		}
 
@@ -131,5 +322,140 @@ func (m *Miner) Start(b *Block, bc *Blockchain, difficulty uint64) bool {
 		//****
 	}
 
-	return false
+	return false, nil
+}
+
+// The error returned when every nonce of a block was tried without finding the block.
+// The caller has to change the block (like its timestamp or extranonce) and mine it again.
+var ErrNonceSpaceExhausted = errors.New("no nonce solves the block")
+
+// Mines the block inputted on many go-routines at once, splitting the nonce space between them.
+// The timestamp is set once before mining, and every worker stops as soon as one of them finds the block.
+// The block is not checked against a blockchain, so the miner will not notice if it was already found.
+// Input is the block, and the amount of workers to mine with (the amount of CPUs if 0 or less).
+// Returns the solved block, or an error if the block can not be mined or no nonce solves it.
+func (m *Miner) StartParallel(b Block, workers int) (Block, error) {
+
+	if m.Out == nil {
+
+		m.Out = os.Stdout
+	}
+
+	if workers <= 0 {
+
+		workers = runtime.NumCPU()
+	}
+
+	if err := m.inputTarget(b.PackedTarget); err != nil {
+
+		return b, err
+	}
+
+	target := m.unpackedTarget
+
+	fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Yellow, "New Block!"))
+
+	b.Timestamp = m.timestamp(&b, nil)
+	header := b.ParseBlockToBytes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Only the first solution found is kept
+	solutions := make(chan uint32, 1)
+
+	waitGroup := new(sync.WaitGroup)
+	chunk := (uint64(math.MaxUint32) + 1) / uint64(workers)
+
+	for worker := 0; worker < workers; worker += 1 {
+
+		start := uint64(worker) * chunk
+		end := start + chunk
+
+		// The last worker takes the nonces left over by the division
+		if worker == workers-1 {
+
+			end = uint64(math.MaxUint32) + 1
+		}
+
+		waitGroup.Add(1)
+
+		go func() {
+
+			defer waitGroup.Done()
+
+			if nonce, found := mineRange(ctx, header, target, start, end); found {
+
+				select {
+
+				case solutions <- nonce:
+					cancel()
+
+				default:
+				}
+			}
+		}()
+	}
+
+	waitGroup.Wait()
+
+	select {
+
+	case nonce := <-solutions:
+
+		b.Nonce = nonce
+
+		hash := make([]byte, 32)
+		hashHeader(hash, header, nonce)
+		b.BlockHash = hex.EncodeToString(hash)
+
+		fmt.Fprintln(m.Out, "[MINER]:", color.Colorize(color.Green, "Block Found!"))
+
+		m.blocksFound += 1
+
+		return b, nil
+
+	default:
+
+		return b, ErrNonceSpaceExhausted
+	}
+}
+
+// Hashes the header with every nonce from start up to (not including) end, until one is at or below the target.
+// Stops early if the context is canceled, like when another worker found the block.
+// Returns the nonce and true if it solves the block, false if none in the range did.
+func mineRange(ctx context.Context, header []byte, target []byte, start uint64, end uint64) (uint32, bool) {
+
+	// The header with room for the nonce on the end
+	blockBytes := make([]byte, len(header), len(header)+4)
+	copy(blockBytes, header)
+
+	hash := make([]byte, 32)
+
+	for nonce := start; nonce < end; nonce += 1 {
+
+		// Checking the context every hash would slow the workers down
+		if nonce%4096 == 0 && ctx.Err() != nil {
+
+			return 0, false
+		}
+
+		hashHeader(hash, blockBytes, uint32(nonce))
+
+		if bytes.Compare(hash, target) != 1 {
+
+			return uint32(nonce), true
+		}
+	}
+
+	return 0, false
+}
+
+// Hashes the header bytes from Block.ParseBlockToBytes with the nonce on the end, into the hash inputted.
+// Both the miner and Block.CalcHash hash through here, so a mined nonce always reproduces the BlockHash it was found with.
+// The nonce is appended to the header in place, so the spare capacity of the header is written over.
+// Returns nothing.
+func hashHeader(hash []byte, header []byte, nonce uint32) {
+
+	sha3.ShakeSum256(hash, append(header, utilities.ByteUtil{}.Uint32toB(nonce)...))
 }
@@ -0,0 +1,336 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
+	"golang.org/x/crypto/sha3"
+)
+
+func TestMinerRestart(t *testing.T) {
+
+	bc := new(Blockchain)
+	miner := new(Miner)
+
+	// A target of 1, which will not be found before the restart
+	oldBlock := Block{PrevHash: "aa", PackedTarget: 0x03000001}
+
+	// An easy target, found in a few hundred hashes
+	newBlock := Block{PrevHash: "bb", PackedTarget: 0x2000ffff}
+
+	done := make(chan bool)
+
+	go func() {
+
+		found, _ := miner.Start(&oldBlock, bc, 1)
+		done <- found
+	}()
+
+	// Let the miner get going on the old block first
+	time.Sleep(50 * time.Millisecond)
+
+	miner.Restart(newBlock)
+
+	select {
+
+	case found := <-done:
+
+		if !found {
+
+			t.Fatal("Expected the miner to find the restarted block")
+		}
+
+	case <-time.After(10 * time.Second):
+
+		t.Fatal("Miner did not find the restarted block in time")
+	}
+
+	if oldBlock.PrevHash != "bb" || oldBlock.PackedTarget != 0x2000ffff {
+
+		t.Errorf("Expected the mined block to be the new block, got %+v", oldBlock)
+	}
+
+	if oldBlock.BlockHash == "" {
+
+		t.Error("Expected the mined block to have its hash set")
+	}
+}
+
+func TestMinerZeroTarget(t *testing.T) {
+
+	bc := new(Blockchain)
+	miner := new(Miner)
+
+	block := Block{PrevHash: "aa", PackedTarget: 0}
+
+	found, err := miner.Start(&block, bc, 1)
+
+	if err != ErrZeroTarget {
+
+		t.Errorf("Expected ErrZeroTarget, got %v", err)
+	}
+
+	if found {
+
+		t.Error("Expected a block with a target of zero to not be found")
+	}
+}
+
+func TestMinerMalformedTarget(t *testing.T) {
+
+	bc := new(Blockchain)
+	miner := new(Miner)
+
+	// Exponents that shift the target out of 256 bits
+	for _, packedTarget := range []uint32{0x0200ffff, 0x2100ffff} {
+
+		block := Block{PrevHash: "aa", PackedTarget: packedTarget}
+
+		found, err := miner.Start(&block, bc, 1)
+
+		if err != ErrMalformedTarget {
+
+			t.Errorf("Target %x: expected ErrMalformedTarget, got %v", packedTarget, err)
+		}
+
+		if found {
+
+			t.Errorf("Target %x: expected the block to not be found", packedTarget)
+		}
+	}
+}
+
+func TestMinerOut(t *testing.T) {
+
+	bc := new(Blockchain)
+	miner := new(Miner)
+
+	out := new(bytes.Buffer)
+	miner.Out = out
+
+	block := Block{PrevHash: "aa", PackedTarget: 0x2000ffff}
+
+	if found, err := miner.Start(&block, bc, 1); !found || err != nil {
+
+		t.Fatalf("Expected the block to be found, got %v, %v", found, err)
+	}
+
+	for _, line := range []string{"New Block!", "Block Found!"} {
+
+		if !strings.Contains(out.String(), line) {
+
+			t.Errorf("Expected %q in the miner output, got %q", line, out.String())
+		}
+	}
+}
+
+func TestMinerStartBudget(t *testing.T) {
+
+	miner := new(Miner)
+	miner.Out = new(bytes.Buffer)
+
+	// A target of 1, which will not be found in the budget
+	block, found, err := miner.StartBudget(Block{PrevHash: "aa", PackedTarget: 0x03000001}, 1000)
+
+	if found || err != nil {
+
+		t.Fatalf("Expected the budget to run out without an error, got found %v and err %v", found, err)
+	}
+
+	if block.BlockHash != "" {
+
+		t.Error("Expected the unfound block to have no hash")
+	}
+
+	// An easy target, found in a few hundred hashes
+	block, found, err = miner.StartBudget(Block{PrevHash: "bb", PackedTarget: 0x2000ffff}, 1000000)
+
+	if !found || err != nil {
+
+		t.Fatalf("Expected the easy block to be found, got found %v and err %v", found, err)
+	}
+
+	if block.BlockHash == "" {
+
+		t.Error("Expected the found block to be returned with its hash")
+	}
+}
+
+func TestMinerStartParallel(t *testing.T) {
+
+	miner := new(Miner)
+	miner.Out = new(bytes.Buffer)
+
+	for _, workers := range []int{0, 1, 4} {
+
+		block, err := miner.StartParallel(Block{PrevHash: "aa", PackedTarget: 0x1f0fffff}, workers)
+
+		if err != nil {
+
+			t.Fatalf("%d workers: expected the block to be found, got %v", workers, err)
+		}
+
+		// The hash is of the block that was returned, and at or below its target
+		hash := make([]byte, 32)
+		sha3.ShakeSum256(hash, append(block.ParseBlockToBytes(), new(utilities.ByteUtil).Uint32toB(block.Nonce)...))
+
+		if hex.EncodeToString(hash) != block.BlockHash || bytes.Compare(hash, miner.unpackedTarget) == 1 {
+
+			t.Errorf("%d workers: expected a solved block, got %+v", workers, block)
+		}
+	}
+
+	if _, err := miner.StartParallel(Block{PrevHash: "aa", PackedTarget: 0}, 4); err != ErrZeroTarget {
+
+		t.Errorf("Expected ErrZeroTarget, got %v", err)
+	}
+}
+
+func BenchmarkMinerStart(b *testing.B) {
+
+	miner := new(Miner)
+	miner.Out = io.Discard
+
+	for index := 0; index < b.N; index += 1 {
+
+		block := Block{PrevHash: fmt.Sprint(index), PackedTarget: 0x1f00ffff}
+
+		if found, err := miner.Start(&block, nil, 1); !found || err != nil {
+
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMinerStartParallel(b *testing.B) {
+
+	miner := new(Miner)
+	miner.Out = io.Discard
+
+	for index := 0; index < b.N; index += 1 {
+
+		if _, err := miner.StartParallel(Block{PrevHash: fmt.Sprint(index), PackedTarget: 0x1f00ffff}, 0); err != nil {
+
+			b.Fatal(err)
+		}
+	}
+}
+
+// A clock that moves forward a second every time it is read.
+type tickingClock struct {
+	now   uint64
+	reads int
+}
+
+func (c *tickingClock) Now() uint64 {
+
+	c.now += 1
+	c.reads += 1
+
+	return c.now
+}
+
+func TestMinerTimestampSetOnce(t *testing.T) {
+
+	clock := &tickingClock{now: 1000}
+
+	miner := new(Miner)
+	miner.Out = new(bytes.Buffer)
+	miner.Clock = clock
+
+	block := Block{PrevHash: "aa", PackedTarget: 0x1f00ffff}
+
+	if found, err := miner.Start(&block, nil, 1); !found || err != nil {
+
+		t.Fatalf("Expected the block to be found, got %v, %v", found, err)
+	}
+
+	// Thousands of hashes were tried, but the clock was only read when mining started
+	if block.Nonce < 2 || clock.reads != 1 || block.Timestamp != 1001 {
+
+		t.Errorf("Expected the timestamp from the start of mining (1001) after %d hashes, got %d from %d reads", block.Nonce+1, block.Timestamp, clock.reads)
+	}
+
+	// The block hash is of the timestamp the block has
+	hash := make([]byte, 32)
+	sha3.ShakeSum256(hash, append(block.ParseBlockToBytes(), new(utilities.ByteUtil).Uint32toB(block.Nonce)...))
+
+	if hex.EncodeToString(hash) != block.BlockHash {
+
+		t.Error("Expected the block hash to match the final timestamp")
+	}
+}
+
+func TestMinerStartContext(t *testing.T) {
+
+	miner := new(Miner)
+	miner.Out = new(bytes.Buffer)
+
+	// An easy block is found like with Start
+	block, err := miner.StartContext(context.Background(), Block{PrevHash: "aa", PackedTarget: 0x2000ffff})
+
+	if err != nil || block.BlockHash == "" {
+
+		t.Fatalf("Expected the easy block to be found, got %+v and %v", block, err)
+	}
+
+	// A target of 1, which will not be found before the context is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	block, err = miner.StartContext(ctx, Block{PrevHash: "aa", PackedTarget: 0x03000001})
+
+	if err != ErrMiningCanceled {
+
+		t.Fatalf("Expected ErrMiningCanceled, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+
+		t.Errorf("Expected the miner to stop soon after the context was canceled, took %s", elapsed)
+	}
+
+	if block.BlockHash != "" {
+
+		t.Error("Expected the canceled block to have no hash")
+	}
+
+	// Already canceled
+	if _, err := miner.StartContext(ctx, Block{PrevHash: "aa", PackedTarget: 0x2000ffff}); err != ErrMiningCanceled {
+
+		t.Errorf("Expected a canceled context to stop the miner before it starts, got %v", err)
+	}
+}
+
+func TestBlockCalcHash(t *testing.T) {
+
+	miner := new(Miner)
+	miner.Out = io.Discard
+
+	block := Block{SoftwareVersion: "1.0", PrevHash: strings.Repeat("ab", 32), PackedTarget: 0x2000ffff, VersionBits: 1}
+
+	if found, err := miner.Start(&block, nil, 0); !found || err != nil {
+
+		t.Fatalf("Could not mine the test block: %v", err)
+	}
+
+	if hex.EncodeToString(block.CalcHash()) != block.BlockHash {
+
+		t.Errorf("Expected CalcHash to reproduce the mined hash %s, got %x", block.BlockHash, block.CalcHash())
+	}
+
+	block.Nonce += 1
+
+	if hex.EncodeToString(block.CalcHash()) == block.BlockHash {
+
+		t.Error("Expected a different nonce to change the hash")
+	}
+}
@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"github.com/Sucks-To-Suck/LuncheonNetwork/ellip"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
+)
+
+// The rules that a network of the blockchain runs by.
+// Each blockchain uses one set of these, so a testnet can run with different rules than the mainnet without editing the source.
+type ChainParams struct {
+	// The name of the network
+	Name string
+
+	// The amount of blocks between each difficulty retarget
+	RetargetInterval uint
+
+	// The most times the target can get easier or harder in one retarget (0 means it is not bounded)
+	RetargetClampFactor uint64
+
+	// The packed target of the genisis block, which is also the easiest target a block can have
+	GenesisTarget uint32
+
+	// The most seconds a block's timestamp can be ahead of the verifier's clock, as clocks are never perfectly in sync
+	MaxFutureDrift uint64
+
+	// The range of fees a tx can pay, in LUNCHEON (a MaxTxFee of 0 means there is no max)
+	// A fee above the max is most likely a bug in whatever made the tx
+	MinTxFee uint64
+	MaxTxFee uint64
+
+	// The least fee a tx has to pay for each weight it has, in LUNCHEON, so a big tx can not claim a tiny fee
+	MinRelayFee uint64
+
+	// The scheme every tx of the network is signed with (secp256k1 if not set)
+	SignatureScheme ellip.SignatureScheme
+
+	// The hash of a block trusted to be valid, set by the node operator to sync faster.
+	// The tx signatures of it and the blocks below it are not checked, but everything else is.
+	AssumeValid string
+
+	// How many blocks below the tip VerifyBlockchain still checks the tx signatures of (0 means every block is checked).
+	// Blocks deeper than this are well past any reorg, so only their proof of work and links are checked again.
+	SigCheckDepth uint
+
+	// The heights that consensus rules start being enforced at.
+	// A rule that is not in the map has been enforced since the genisis block.
+	RuleHeights map[Rule]uint
+}
+
+// A consensus rule that was added after the genisis block.
+// Blocks below the rules activation height are verified without it, so old blocks stay valid.
+type Rule string
+
+const (
+	// The block reward has to go to a valid public key
+	RuleValidMiner Rule = "validminer"
+
+	// The block hash has to be at or below the blocks target
+	RuleProofOfWork Rule = "proofofwork"
+
+	// The txs of a block have to be in the order of SortTxs
+	RuleTxOrder Rule = "txorder"
+)
+
+// The params of the main Luncheon network.
+var MainnetParams = ChainParams{
+	Name: "mainnet",
+
+	// If block time is 1 minute, this is once a week
+	RetargetInterval: 10080,
+
+	RetargetClampFactor: 4,
+
+	GenesisTarget: 0x1d0fffff,
+
+	// 2 block times
+	MaxFutureDrift: 2 * 60,
+
+	MinTxFee: 1000,
+	MaxTxFee: 100 * utilities.LuncheonPerLNCH,
+
+	MinRelayFee: 1,
+
+	SignatureScheme: ellip.Secp256k1{},
+}
+
+// The params of the Luncheon test network.
+var TestnetParams = ChainParams{
+	Name: "testnet",
+
+	// If block time is 1 minute, this is once a day
+	RetargetInterval: 1440,
+
+	RetargetClampFactor: 4,
+
+	// 16 times easier than the mainnet, so the testnet can be mined on anything
+	GenesisTarget: 0x1e0fffff,
+
+	// Testnet nodes are often run on machines with a bad clock
+	MaxFutureDrift: 10 * 60,
+
+	MinTxFee: 1000,
+	MaxTxFee: 100 * utilities.LuncheonPerLNCH,
+
+	MinRelayFee: 1,
+
+	SignatureScheme: ellip.Secp256k1{},
+}
+
+// Gets the params of the blockchain.
+// A blockchain that was not given any params uses the mainnet params.
+// Returns a copy of the params.
+func (b *Blockchain) Params() ChainParams {
+
+	if b.params == nil {
+
+		return MainnetParams
+	}
+
+	return *b.params
+}
+
+// Makes a copy of the params, that does not share its RuleHeights with the original.
+// Returns the copy.
+func (p ChainParams) Copy() ChainParams {
+
+	if p.RuleHeights != nil {
+
+		ruleHeights := make(map[Rule]uint, len(p.RuleHeights))
+
+		for rule, height := range p.RuleHeights {
+
+			ruleHeights[rule] = height
+		}
+
+		p.RuleHeights = ruleHeights
+	}
+
+	return p
+}
+
+// Sets the params the blockchain runs by.
+// Returns nothing.
+func (b *Blockchain) SetParams(params ChainParams) {
+
+	b.params = &params
+}
+
+// Checks if a consensus rule is enforced for the block at the height inputted.
+// Returns true if the rule is active, false if the block is from before the rule.
+func (p ChainParams) RuleActive(rule Rule, height uint) bool {
+
+	activationHeight, found := p.RuleHeights[rule]
+
+	return !found || height >= activationHeight
+}
+
+// Checks if a tx fee is within the MinTxFee and MaxTxFee of the params.
+// Returns true if the fee is allowed, false if not.
+func (p ChainParams) FeeInRange(fee uint64) bool {
+
+	return fee >= p.MinTxFee && (p.MaxTxFee == 0 || fee <= p.MaxTxFee)
+}
+
+// Calculates the least fee a tx of the weight inputted has to pay, from the MinRelayFee of the params.
+// Returns the min fee, in LUNCHEON.
+func (p ChainParams) MinFeeForWeight(weight uint) uint64 {
+
+	return uint64(weight) * p.MinRelayFee
+}
+
+// Gets the scheme the txs of the network are signed with.
+// Returns the scheme, or secp256k1 if the params do not have one.
+func (p ChainParams) SigScheme() ellip.SignatureScheme {
+
+	if p.SignatureScheme == nil {
+
+		return ellip.Secp256k1{}
+	}
+
+	return p.SignatureScheme
+}
@@ -0,0 +1,129 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func TestRetargetInterval(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	params := MainnetParams
+	params.RetargetInterval = 5
+	bc.SetParams(params)
+
+	// Blocks come twice as fast as the 1 minute target
+	// A window spans 4 block times (120 seconds) against an expected 300, so each retarget scales the target by 0.4 (harder)
+	for index := 0; index < 12; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, Block{PackedTarget: 0x1c0fffff, Timestamp: uint64(index * 30)})
+	}
+
+	for blockNumber := uint(1); blockNumber <= 12; blockNumber += 1 {
+
+		expected := uint32(0x1c0fffff)
+
+		if blockNumber == 5 || blockNumber == 10 {
+
+			expected = 0x1c066666
+		}
+
+		if target := bc.CalculatePackedTarget(blockNumber); target != expected {
+
+			t.Errorf("Block %d: expected target %x, got %x", blockNumber, expected, target)
+		}
+	}
+}
+
+func TestRetargetIntervalLongerThanChain(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	params := MainnetParams
+	params.RetargetInterval = 100
+	bc.SetParams(params)
+
+	for index := 0; index < 12; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, Block{PackedTarget: 0x1c0fffff, Timestamp: uint64(index * 30)})
+	}
+
+	for blockNumber := uint(1); blockNumber <= 12; blockNumber += 1 {
+
+		if target := bc.CalculatePackedTarget(blockNumber); target != 0x1c0fffff {
+
+			t.Errorf("Block %d: expected no retarget, got %x", blockNumber, target)
+		}
+	}
+}
+
+func TestRuleActive(t *testing.T) {
+
+	params := ChainParams{RuleHeights: map[Rule]uint{RuleProofOfWork: 5}}
+
+	if params.RuleActive(RuleProofOfWork, 4) || !params.RuleActive(RuleProofOfWork, 5) {
+
+		t.Error("Expected the rule to be active from its activation height")
+	}
+
+	if !params.RuleActive(RuleValidMiner, 0) {
+
+		t.Error("Expected a rule without an activation height to always be active")
+	}
+}
+
+func TestFeeInRange(t *testing.T) {
+
+	params := ChainParams{MinTxFee: 10, MaxTxFee: 100}
+
+	for fee, expected := range map[uint64]bool{9: false, 10: true, 50: true, 100: true, 101: false} {
+
+		if params.FeeInRange(fee) != expected {
+
+			t.Errorf("Fee %d: expected in range to be %v", fee, expected)
+		}
+	}
+
+	// No max fee
+	params.MaxTxFee = 0
+
+	if !params.FeeInRange(1000000000) {
+
+		t.Error("Expected any fee over the min to be in range without a max")
+	}
+}
+
+func TestRetargetClampFactor(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		clampFactor uint64
+		windowTime  uint64
+		expected    uint32
+	}{
+		// Blocks 8 times faster than expected, so the target gets smaller (harder)
+		{"fast, clamp 2", 2, 10080 * 60 / 8, 0x1c07ffff},
+		{"fast, clamp 4", 4, 10080 * 60 / 8, 0x1c03ffff},
+		{"fast, no clamp", 0, 10080 * 60 / 8, 0x1c01ffff},
+		// Blocks 8 times slower than expected, so the target gets larger (easier)
+		{"slow, clamp 2", 2, 10080 * 60 * 8, 0x1c1ffffe},
+		{"slow, clamp 4", 4, 10080 * 60 * 8, 0x1c3ffffc},
+		{"slow, no clamp", 0, 10080 * 60 * 8, 0x1c7ffff8},
+		// Within both clamps
+		{"on time, clamp 2", 2, 10080 * 60, 0x1c0fffff},
+	}
+
+	for _, test := range tests {
+
+		bc := newRetargetChain(0x1c0fffff, test.windowTime)
+
+		params := MainnetParams
+		params.RetargetClampFactor = test.clampFactor
+		bc.SetParams(params)
+
+		if target := bc.CalculatePackedTarget(10080); target != test.expected {
+
+			t.Errorf("%s: expected target %x, got %x", test.name, test.expected, target)
+		}
+	}
+}
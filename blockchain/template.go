@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"encoding/hex"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
+)
+
+// The work for the next block, given to miners outside of the node.
+// A miner only has to fill in the nonce (and may update the timestamp) to solve it.
+type BlockTemplate struct {
+	Height          uint
+	SoftwareVersion string
+	PrevHash        string
+
+	PackedTarget uint32
+	Target       string
+
+	Miner string
+
+	// The block reward the miner is credited, which is only the reward, as the ledger does not pay the fees of the txs to the miner
+	CoinbaseValue uint64
+
+	MerkleRoot string
+	Txs        []transactions.LuTx
+
+	Timestamp uint64
+
+	// Rolled by miners once every nonce has been tried, like in a block
+	ExtraNonce uint64
+
+	// The bits of the soft forks the miner is ready for, like in a block
+	VersionBits uint32
+}
+
+// Creates a template for the next block on the blockchain.
+// Inputs are the mining address that will be rewarded if the block is solved, and the txs to try to fit in the block.
+// Txs that would make the block too heavy are left out.
+// Returns the template.
+func (b *Blockchain) NewBlockTemplate(blockMinerId string, txs []transactions.LuTx) BlockTemplate {
+
+	block := b.CreateBlock(blockMinerId)
+
+	for index := 0; index < len(txs); index += 1 {
+
+		block.AddTx(txs[index])
+	}
+
+	unpacker := new(utilities.TargetUnpacker)
+	timeUtil := new(utilities.Time)
+
+	template := new(BlockTemplate)
+
+	template.Height = uint(len(b.Blocks))
+	template.SoftwareVersion = block.SoftwareVersion
+	template.PrevHash = block.PrevHash
+	template.PackedTarget = block.PackedTarget
+	template.Target = hex.EncodeToString(unpacker.UnpackAsBytes(block.PackedTarget))
+	template.Miner = block.Miner
+	template.CoinbaseValue = b.GetBlockReward(uint32(template.Height))
+	template.MerkleRoot = block.MerkleRoot
+	template.Txs = block.Txs
+	template.Timestamp = b.NextTimestamp(block.PrevHash, timeUtil.CurrentUnix())
+	template.ExtraNonce = block.ExtraNonce
+	template.VersionBits = block.VersionBits
+
+	return *template
+}
+
+// Turns the template into a block, that still has to be solved.
+// Returns the block.
+func (t *BlockTemplate) Block() Block {
+
+	block := new(Block)
+
+	block.SoftwareVersion = t.SoftwareVersion
+	block.PrevHash = t.PrevHash
+	block.PackedTarget = t.PackedTarget
+	block.Miner = t.Miner
+	block.MerkleRoot = t.MerkleRoot
+	block.Txs = t.Txs
+	block.Timestamp = t.Timestamp
+	block.ExtraNonce = t.ExtraNonce
+	block.VersionBits = t.VersionBits
+
+	return *block
+}
@@ -0,0 +1,173 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The error returned when two blocks of the blockchain have the same hash.
+var ErrDuplicateBlock = errors.New("block is already on the blockchain")
+
+// The error returned when a block's timestamp is not after the timestamp of the block before it, which breaks the retarget math.
+var ErrTimestampNotIncreasing = errors.New("block timestamp is not after the previous block")
+
+// The error of a blockchain that is not valid, with the height of the first block that is not.
+type ValidationError struct {
+	Height uint
+	Err    error
+}
+
+// Returns the error as a string, with the height of the block.
+func (e *ValidationError) Error() string {
+
+	return fmt.Sprintf("block %d: %s", e.Height, e.Err.Error())
+}
+
+// Returns the reason the block is not valid, so errors.Is can be used on a ValidationError.
+func (e *ValidationError) Unwrap() error {
+
+	return e.Err
+}
+
+// Checks the structure of the blockchain, like after loading a save that could be corrupted or crafted.
+// This does not verify the blocks themselves, which is done by the wallet.
+// Returns nil if the blockchain is valid, or a *ValidationError of the first block that is not.
+func (b *Blockchain) Validate() error {
+
+	seenHashes := make(map[string]bool, len(b.Blocks))
+
+	for index := 0; index < len(b.Blocks); index += 1 {
+
+		// If the same block is on the blockchain twice
+		if seenHashes[b.Blocks[index].BlockHash] {
+
+			return &ValidationError{Height: uint(index), Err: ErrDuplicateBlock}
+		}
+
+		seenHashes[b.Blocks[index].BlockHash] = true
+
+		// If the block was not made after the block before it
+		if index != 0 && b.Blocks[index].Timestamp <= b.Blocks[index-1].Timestamp {
+
+			return &ValidationError{Height: uint(index), Err: ErrTimestampNotIncreasing}
+		}
+	}
+
+	return nil
+}
+
+// Remembers a block as invalid, so it is rejected straight away if a peer sends it again.
+// Only blocks whose hash was checked to match them should be marked, or a peer could get a valid block marked.
+// Returns nothing.
+func (b *Blockchain) MarkInvalid(blockHash string) {
+
+	if b.invalidBlocks == nil {
+
+		b.invalidBlocks = map[string]bool{}
+	}
+
+	b.invalidBlocks[blockHash] = true
+}
+
+// Checks if a block was marked as invalid.
+// Returns true if the block is known to be invalid.
+func (b *Blockchain) IsInvalid(blockHash string) bool {
+
+	return b.invalidBlocks[blockHash]
+}
+
+// Validates the blockchain, and if truncate is true, removes the first invalid block and every block after it.
+// Without truncate, the blockchain is only checked, which is the same as Validate.
+// Returns nil if the blockchain was valid, or the *ValidationError of the first invalid block.
+func (b *Blockchain) VerifyAndRepair(truncate bool) error {
+
+	err := b.Validate()
+
+	var validationErr *ValidationError
+
+	if truncate && errors.As(err, &validationErr) {
+
+		// The genisis block can not be removed
+		if validationErr.Height == 0 {
+
+			return err
+		}
+
+		// Invalid blocks did not lose a reorg, so they are not counted as stale
+		b.truncateTo(validationErr.Height - 1)
+	}
+
+	return err
+}
+
+// A tx that spent a block reward before the reward was mature.
+type Violation struct {
+	Txid   string
+	Height uint
+}
+
+// Audits the blockchain for txs that spent a block reward before RewardMaturity blocks had passed.
+// Replays the credits of the ledger from the genisis block, and flags any tx its sender could only pay for with immature rewards.
+// Like the ledger, a tx is checked against the blockchain up to the block before it, and never takes what it sends from its sender.
+// Returns the violations, from the oldest to the newest.
+func (b *Blockchain) AuditCoinbaseSpends() []Violation {
+
+	violations := []Violation{}
+
+	// The balances that can be spent, and the heights of the rewards that have not matured into them yet
+	balances := map[string]uint64{}
+	immature := map[string][]uint{}
+
+	for height := uint(0); height < uint(len(b.Blocks)); height += 1 {
+
+		fullBlock := b.fullBlock(height)
+		block := &fullBlock
+
+		// Move the rewards that have matured by this block into the balances.
+		// The txs of the block are checked with the tip at the block before it, where a reward is mature if it is more than RewardMaturity blocks below the tip
+		for pubKey, rewardHeights := range immature {
+
+			for len(rewardHeights) != 0 && rewardHeights[0]+RewardMaturity+1 < height {
+
+				balances[pubKey] += b.GetBlockReward(uint32(rewardHeights[0]))
+				rewardHeights = rewardHeights[1:]
+			}
+
+			immature[pubKey] = rewardHeights
+		}
+
+		for txIndex := 0; txIndex < len(block.Txs); txIndex += 1 {
+
+			tx := &block.Txs[txIndex]
+			spent := tx.Value + tx.Fee
+
+			// The sender could only pay with the rewards that are not mature yet
+			if spent > balances[tx.TxFrom] && b.immatureRewards(immature[tx.TxFrom]) >= spent-balances[tx.TxFrom] {
+
+				violations = append(violations, Violation{Txid: tx.HashTx(), Height: height})
+			}
+		}
+
+		// The coins the block sends can only be spent by the blocks after it
+		for txIndex := 0; txIndex < len(block.Txs); txIndex += 1 {
+
+			balances[block.Txs[txIndex].TxTo] += block.Txs[txIndex].Value
+		}
+
+		immature[block.Miner] = append(immature[block.Miner], height)
+	}
+
+	return violations
+}
+
+// Adds up the block rewards of the heights inputted.
+// Returns the total reward.
+func (b *Blockchain) immatureRewards(rewardHeights []uint) (total uint64) {
+
+	for index := 0; index < len(rewardHeights); index += 1 {
+
+		total += b.GetBlockReward(uint32(rewardHeights[index]))
+	}
+
+	return total
+}
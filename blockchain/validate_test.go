@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+)
+
+func TestValidateDuplicateBlock(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	for index, hash := range []string{"aa", "bb", "cc", "bb", "dd"} {
+
+		bc.Blocks = append(bc.Blocks, Block{BlockHash: hash, Timestamp: uint64(index)})
+	}
+
+	err := bc.Validate()
+
+	var validationErr *ValidationError
+
+	if !errors.As(err, &validationErr) || validationErr.Height != 3 || !errors.Is(err, ErrDuplicateBlock) {
+
+		t.Fatalf("Expected a duplicate block at height 3, got %v", err)
+	}
+
+	if err := bc.VerifyAndRepair(true); !errors.Is(err, ErrDuplicateBlock) {
+
+		t.Errorf("Expected the repair to report the duplicate block, got %v", err)
+	}
+
+	if bc.GetHeight() != 2 {
+
+		t.Errorf("Expected the blockchain to be cut before the duplicate block, got height %d", bc.GetHeight())
+	}
+
+	if bc.StaleBlockCount() != 0 {
+
+		t.Errorf("Expected the invalid blocks removed to not be counted as stale, got %d", bc.StaleBlockCount())
+	}
+
+	if err := bc.Validate(); err != nil {
+
+		t.Errorf("Expected the repaired blockchain to be valid, got %v", err)
+	}
+}
+
+func TestValidateTimestampNotIncreasing(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	// The block at height 3 has the same timestamp as the block before it
+	for index, timestamp := range []uint64{100, 160, 220, 220, 280, 340} {
+
+		bc.Blocks = append(bc.Blocks, Block{BlockHash: fmt.Sprint(index), Timestamp: timestamp})
+	}
+
+	err := bc.Validate()
+
+	var validationErr *ValidationError
+
+	if !errors.As(err, &validationErr) || validationErr.Height != 3 || !errors.Is(err, ErrTimestampNotIncreasing) {
+
+		t.Fatalf("Expected a timestamp that does not increase at height 3, got %v", err)
+	}
+
+	// Without truncate, the blockchain is left alone
+	if err := bc.VerifyAndRepair(false); !errors.Is(err, ErrTimestampNotIncreasing) || bc.GetHeight() != 5 {
+
+		t.Errorf("Expected the error without changing the blockchain, got %v at height %d", err, bc.GetHeight())
+	}
+
+	if err := bc.VerifyAndRepair(true); !errors.Is(err, ErrTimestampNotIncreasing) || bc.GetHeight() != 2 {
+
+		t.Errorf("Expected the blockchain to be cut before height 3, got %v at height %d", err, bc.GetHeight())
+	}
+
+	// A timestamp going backwards is also caught
+	bc.Blocks = append(bc.Blocks, Block{BlockHash: "back", Timestamp: 200})
+
+	if err := bc.Validate(); !errors.Is(err, ErrTimestampNotIncreasing) {
+
+		t.Errorf("Expected a timestamp before the previous block to be invalid, got %v", err)
+	}
+}
+
+func TestAuditCoinbaseSpends(t *testing.T) {
+
+	bc := new(Blockchain)
+
+	for index := 0; index < 30; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, Block{Miner: "someoneElse", Timestamp: uint64(index)})
+	}
+
+	reward := bc.GetBlockReward(0)
+	bc.Blocks[0].Miner = "alice"
+
+	// The reward of block 0 can be spent from height 12, once the tip before the tx is past the maturity window, so this spend is too early
+	premature := transactions.LuTx{TxFrom: "alice", TxTo: "bob", Value: reward / 2, Fee: 1000}
+	bc.Blocks[5].Txs = append(bc.Blocks[5].Txs, premature)
+
+	// Spends from a mature reward, and the coins received from one in the block after
+	mature := transactions.LuTx{TxFrom: "someoneElse", TxTo: "bob", Value: reward / 2, Fee: 1000}
+	received := transactions.LuTx{TxFrom: "bob", TxTo: "carol", Value: reward / 4, Fee: 1000}
+	bc.Blocks[15].Txs = append(bc.Blocks[15].Txs, mature)
+	bc.Blocks[16].Txs = append(bc.Blocks[16].Txs, received)
+
+	// The ledger never takes the coins a tx sends from its sender, so the same mature reward pays for another tx
+	bc.Blocks[17].Txs = append(bc.Blocks[17].Txs, mature)
+
+	violations := bc.AuditCoinbaseSpends()
+
+	if len(violations) != 1 || violations[0].Txid != premature.HashTx() || violations[0].Height != 5 {
+
+		t.Fatalf("Expected only the premature spend at height 5, got %+v", violations)
+	}
+
+	// The same spend at the last height the reward is immature (r + RewardMaturity + 1)
+	bc.Blocks[5].Txs = nil
+	bc.Blocks[RewardMaturity+1].Txs = append(bc.Blocks[RewardMaturity+1].Txs, premature)
+
+	if violations := bc.AuditCoinbaseSpends(); len(violations) != 1 || violations[0].Height != RewardMaturity+1 {
+
+		t.Errorf("Expected the spend at height %d to be premature, got %+v", RewardMaturity+1, violations)
+	}
+
+	// The same spend once the reward has matured
+	bc.Blocks[RewardMaturity+1].Txs = nil
+	bc.Blocks[RewardMaturity+2].Txs = append(bc.Blocks[RewardMaturity+2].Txs, premature)
+
+	if violations := bc.AuditCoinbaseSpends(); len(violations) != 0 {
+
+		t.Errorf("Expected no violations once the reward is mature, got %+v", violations)
+	}
+
+	// Coins received in a block can not be spent in the same block, so dave could only pay with the immature reward of block 14
+	bc.Blocks[14].Miner = "dave"
+
+	toDave := transactions.LuTx{TxFrom: "someoneElse", TxTo: "dave", Value: reward / 2, Fee: 1000}
+	fromDave := transactions.LuTx{TxFrom: "dave", TxTo: "carol", Value: reward / 4, Fee: 1000}
+	bc.Blocks[20].Txs = append(bc.Blocks[20].Txs, toDave, fromDave)
+
+	if violations := bc.AuditCoinbaseSpends(); len(violations) != 1 || violations[0].Txid != fromDave.HashTx() || violations[0].Height != 20 {
+
+		t.Errorf("Expected spending coins received in the same block to be premature, got %+v", violations)
+	}
+}
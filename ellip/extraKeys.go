@@ -12,6 +12,10 @@ import (
 	"golang.org/x/crypto/sha3"
 )
 
+// The source of randomness used to make keys and random messages.
+// This is always crypto/rand, tests can swap it for a reader that always gives the same bytes.
+var randSource io.Reader = rand.Reader
+
 // Function gets and if needed generates a public and private key pair.
 // Input the save file name to get / make the key pair.
 // Returns the public key, and then the private key.
@@ -58,7 +62,7 @@ func GetKeyPair(saveName string) ([]byte, ecdsa.PrivateKey) {
 func generateRandPrivKey(saveName string) {
 
 	// Create the key
-	key, keyErr := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+	key, keyErr := NewRandomKey()
 
 	// Error in making key?
 	if keyErr != nil {
@@ -97,6 +101,28 @@ func generateRandPrivKey(saveName string) {
 	}
 }
 
+// Generates a new random private key, without saving it.
+// The key is read from randSource, so it is the same every time for the same random bytes.
+// Returns the private key, or an error if the random bytes could not be read.
+func NewRandomKey() (*ecdsa.PrivateKey, error) {
+
+	keyBytes := make([]byte, 32)
+
+	for {
+
+		if _, readErr := io.ReadFull(randSource, keyBytes); readErr != nil {
+
+			return nil, readErr
+		}
+
+		// The bytes are not a valid key if they are zero or bigger than the order of the curve, so read again
+		if key, keyErr := crypto.ToECDSA(keyBytes); keyErr == nil {
+
+			return key, nil
+		}
+	}
+}
+
 // This function signs a hash of a randomly generated message.
 // The hashing and randomness is used for security.
 // Input is the private key that will be used to sign the message.
@@ -141,7 +167,15 @@ func SignMsg(privKey *ecdsa.PrivateKey, msg []byte) (msgHash, sig []byte) {
 	msgHash = make([]byte, 32)
 	sha3.ShakeSum256(msgHash, msg)
 
-	// Signs the message
+	return msgHash, SignHash(privKey, msgHash)
+}
+
+// This function signs a hash that has already been made, like the hash of a tx.
+// Input is the private key that will be used to sign the hash, and the 32 byte hash.
+// Output is the signature.
+func SignHash(privKey *ecdsa.PrivateKey, msgHash []byte) []byte {
+
+	// Signs the hash
 	sig, sigErr := crypto.Sign(msgHash, privKey)
 
 	// If an error occured
@@ -159,7 +193,7 @@ func SignMsg(privKey *ecdsa.PrivateKey, msg []byte) (msgHash, sig []byte) {
 		finalSig[index] = sig[index]
 	}
 
-	return msgHash, finalSig
+	return finalSig
 }
 
 // Function makes a random message.
@@ -171,7 +205,7 @@ func randomMessage(arraySize uint) []byte {
 	bytesBuffer := make([]byte, arraySize)
 
 	// Read a random place for the message
-	_, readErr := io.ReadFull(rand.Reader, bytesBuffer)
+	_, readErr := io.ReadFull(randSource, bytesBuffer)
 
 	// Not read correctly?
 	if readErr != nil {
@@ -208,3 +242,25 @@ func ValidateSig(publicKey, msgHash, sig []byte) bool {
 
 	return crypto.VerifySignature(publicKey, msgHash, sig)
 }
+
+// Checks if the public key inputted is a valid public key on the curve.
+// Accepts both the uncompressed (65 bytes) and compressed (33 bytes) forms.
+// Returns true if valid, false if not valid.
+func IsValidPublicKey(publicKey []byte) bool {
+
+	var err error
+
+	switch len(publicKey) {
+
+	case 65:
+		_, err = crypto.UnmarshalPubkey(publicKey)
+
+	case 33:
+		_, err = crypto.DecompressPubkey(publicKey)
+
+	default:
+		return false
+	}
+
+	return err == nil
+}
@@ -0,0 +1,79 @@
+package ellip
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestIsValidPublicKey(t *testing.T) {
+
+	key, err := crypto.GenerateKey()
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	uncompressed := elliptic.Marshal(crypto.S256(), key.X, key.Y)
+	compressed := crypto.CompressPubkey(&key.PublicKey)
+
+	if !IsValidPublicKey(uncompressed) {
+
+		t.Error("Expected the uncompressed public key to be valid")
+	}
+
+	if !IsValidPublicKey(compressed) {
+
+		t.Error("Expected the compressed public key to be valid")
+	}
+
+	// Not on the curve
+	offCurve := make([]byte, 65)
+	copy(offCurve, uncompressed)
+	offCurve[64] ^= 0xff
+
+	for _, malformed := range [][]byte{nil, []byte("garbage"), offCurve, uncompressed[:64]} {
+
+		if IsValidPublicKey(malformed) {
+
+			t.Errorf("Expected %x to be an invalid public key", malformed)
+		}
+	}
+}
+
+func TestNewRandomKeyFixedSeed(t *testing.T) {
+
+	defer func(source io.Reader) { randSource = source }(randSource)
+
+	// A zero key is not valid, so it is skipped for the key of 1 after it
+	seed := make([]byte, 64)
+	seed[63] = 1
+	randSource = bytes.NewReader(seed)
+
+	key, err := NewRandomKey()
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	// The public key of 1 is the generator point of secp256k1
+	expected := "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+		"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+
+	if pubKey := hex.EncodeToString(elliptic.Marshal(crypto.S256(), key.X, key.Y)); pubKey != expected {
+
+		t.Errorf("Expected public key %s, got %s", expected, pubKey)
+	}
+
+	// The seed has run out
+	if _, err := NewRandomKey(); err == nil {
+
+		t.Error("Expected an error when the rand source can not be read")
+	}
+}
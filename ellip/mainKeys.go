@@ -3,6 +3,8 @@ package ellip
 import (
 	"crypto/ecdsa"
 	"encoding/hex"
+
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 // The main key used by nodes.
@@ -60,6 +62,32 @@ func (m *MainKey) GetPubKeyStr() string {
 	return hex.EncodeToString(m.pubKey)
 }
 
+// This function gets the main private key as bytes, the form the Secp256k1 scheme signs with.
+// Also does not need keys to be loaded / generated before hand.
+// Returns the 32 byte private key.
+func (m *MainKey) PrivKeyBytes() []byte {
+
+	if !m.loaded {
+
+		m.GetMainKeyPair()
+	}
+
+	return crypto.FromECDSA(&m.privKey)
+}
+
+// This function gets the main public key as bytes.
+// Also does not need keys to be loaded / generated before hand.
+// Returns the 65 byte uncompressed public key.
+func (m *MainKey) PubKeyBytes() []byte {
+
+	if !m.loaded {
+
+		m.GetMainKeyPair()
+	}
+
+	return m.pubKey
+}
+
 // This function signs an inpuutes message with the main private key.
 // Returns the hash of the message, and the signature of that hash.
 func (m *MainKey) SignMsg(msg []byte) (msgHash, sig []byte) {
@@ -70,3 +98,14 @@ func (m *MainKey) SignMsg(msg []byte) (msgHash, sig []byte) {
 
 	return SignMsg(&m.privKey, msg)
 }
+
+// This function signs a hash that has already been made with the main private key.
+// Returns the signature of the hash.
+func (m *MainKey) SignHash(msgHash []byte) []byte {
+
+	if !m.loaded {
+		m.GetMainKeyPair()
+	}
+
+	return SignHash(&m.privKey, msgHash)
+}
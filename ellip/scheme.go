@@ -0,0 +1,106 @@
+package ellip
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+This file contains the signature schemes txs can be signed with.
+Every node of a network has to use the same scheme, so the scheme is set in the params of the network.
+The keys and signatures are passed as bytes, so the code using a scheme does not need to know which one it is.
+*/
+
+// A way of signing and verifying hashes, like the hashes of txs.
+type SignatureScheme interface {
+	// Signs the 32 byte hash with the private key, returning the signature
+	Sign(privKey []byte, msgHash []byte) ([]byte, error)
+
+	// Checks the signature of the hash was made by the private key of the public key
+	Verify(pubKey []byte, msgHash []byte, sig []byte) bool
+
+	// Checks the public key can be used with the scheme, like a point on the curve
+	ValidPubKey(pubKey []byte) bool
+
+	// The length of the public keys of the scheme, in bytes
+	PubKeyLen() int
+}
+
+// The error returned when a private key is not the right size or shape for the scheme.
+var ErrBadPrivKey = errors.New("private key is not valid for the signature scheme")
+
+// The ECDSA scheme on the secp256k1 curve, the scheme of the Luncheon networks.
+// Signatures are 64 bytes, without the recovery byte, and public keys are the 65 byte uncompressed form.
+type Secp256k1 struct{}
+
+// Signs the hash with the 32 byte private key.
+// Returns the signature, or ErrBadPrivKey if the private key is not valid.
+func (Secp256k1) Sign(privKey []byte, msgHash []byte) ([]byte, error) {
+
+	key, err := crypto.ToECDSA(privKey)
+
+	if err != nil {
+
+		return nil, ErrBadPrivKey
+	}
+
+	return SignHash(key, msgHash), nil
+}
+
+// Checks the signature of the hash is valid for the public key.
+// Returns true if valid, false if not valid.
+func (s Secp256k1) Verify(pubKey []byte, msgHash []byte, sig []byte) bool {
+
+	return s.ValidPubKey(pubKey) && ValidateSig(pubKey, msgHash, sig)
+}
+
+// Checks the public key is on the curve.
+// Returns true if valid, false if not valid.
+func (Secp256k1) ValidPubKey(pubKey []byte) bool {
+
+	return IsValidPublicKey(pubKey)
+}
+
+// Returns the length of an uncompressed public key, 65 bytes.
+func (Secp256k1) PubKeyLen() int {
+
+	return 65
+}
+
+// The Ed25519 scheme, for experimenting with networks that do not use ECDSA.
+// Private keys are the 64 byte form of crypto/ed25519, and public keys are 32 bytes.
+type Ed25519 struct{}
+
+// Signs the hash with the 64 byte private key.
+// Returns the signature, or ErrBadPrivKey if the private key is the wrong size.
+func (Ed25519) Sign(privKey []byte, msgHash []byte) ([]byte, error) {
+
+	if len(privKey) != ed25519.PrivateKeySize {
+
+		return nil, ErrBadPrivKey
+	}
+
+	return ed25519.Sign(ed25519.PrivateKey(privKey), msgHash), nil
+}
+
+// Checks the signature of the hash is valid for the public key.
+// Returns true if valid, false if not valid.
+func (e Ed25519) Verify(pubKey []byte, msgHash []byte, sig []byte) bool {
+
+	return e.ValidPubKey(pubKey) && ed25519.Verify(ed25519.PublicKey(pubKey), msgHash, sig)
+}
+
+// Checks the public key is the size of an Ed25519 public key.
+// Returns true if valid, false if not valid.
+func (Ed25519) ValidPubKey(pubKey []byte) bool {
+
+	return len(pubKey) == ed25519.PublicKeySize
+}
+
+// Returns the length of a public key, 32 bytes.
+func (Ed25519) PubKeyLen() int {
+
+	return ed25519.PublicKeySize
+}
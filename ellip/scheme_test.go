@@ -0,0 +1,92 @@
+package ellip
+
+import (
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Creates a key pair for the scheme inputted.
+// Returns the private and public key as bytes.
+func newSchemeKey(t *testing.T, scheme SignatureScheme) ([]byte, []byte) {
+
+	switch scheme.(type) {
+
+	case Secp256k1:
+		key, err := crypto.GenerateKey()
+
+		if err != nil {
+
+			t.Fatal(err)
+		}
+
+		return crypto.FromECDSA(key), elliptic.Marshal(crypto.S256(), key.X, key.Y)
+
+	case Ed25519:
+		pubKey, privKey, err := ed25519.GenerateKey(nil)
+
+		if err != nil {
+
+			t.Fatal(err)
+		}
+
+		return privKey, pubKey
+	}
+
+	t.Fatalf("No key for the scheme %T", scheme)
+
+	return nil, nil
+}
+
+func TestSignatureSchemes(t *testing.T) {
+
+	msgHash := make([]byte, 32)
+	copy(msgHash, "a tx hash")
+
+	schemes := []SignatureScheme{Secp256k1{}, Ed25519{}}
+
+	for index, scheme := range schemes {
+
+		privKey, pubKey := newSchemeKey(t, scheme)
+
+		if len(pubKey) != scheme.PubKeyLen() || !scheme.ValidPubKey(pubKey) {
+
+			t.Errorf("%T: expected a valid public key of %d bytes, got %d", scheme, scheme.PubKeyLen(), len(pubKey))
+		}
+
+		sig, err := scheme.Sign(privKey, msgHash)
+
+		if err != nil {
+
+			t.Fatal(err)
+		}
+
+		if !scheme.Verify(pubKey, msgHash, sig) {
+
+			t.Errorf("%T: expected the signature to be valid", scheme)
+		}
+
+		otherHash := append([]byte{}, msgHash...)
+		otherHash[0] ^= 0xff
+
+		if scheme.Verify(pubKey, otherHash, sig) {
+
+			t.Errorf("%T: expected the signature to be invalid for another hash", scheme)
+		}
+
+		// A network only accepts the signatures of its own scheme
+		other := schemes[(index+1)%len(schemes)]
+
+		if other.Verify(pubKey, msgHash, sig) {
+
+			t.Errorf("%T: expected the signature to be invalid under %T", scheme, other)
+		}
+
+		if _, err := scheme.Sign([]byte("short"), msgHash); err != ErrBadPrivKey {
+
+			t.Errorf("%T: expected ErrBadPrivKey, got %v", scheme, err)
+		}
+	}
+}
@@ -4,14 +4,14 @@ go 1.18
 
 require (
 	github.com/GoblinBear/beson-go v0.0.0-20191106025029-f536b6cd1586
+	github.com/TwiN/go-color v1.1.0
+	github.com/ethereum/go-ethereum v1.10.20
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
 )
 
 require (
-	github.com/TwiN/go-color v1.1.0 // indirect
 	github.com/btcsuite/btcd/btcec/v2 v2.2.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
-	github.com/ethereum/go-ethereum v1.10.20 // indirect
 )
 
 require (
@@ -10,6 +10,8 @@ import (
 	"github.com/Sucks-To-Suck/LuncheonNetwork/ellip"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/mempool"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/node"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/rpc"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/wallet"
 	"github.com/TwiN/go-color"
 )
@@ -24,6 +26,7 @@ func main() {
 
 	localNode := flag.Bool("local", false, "Starts a locally hosted testnet")
 	localNodeTx := flag.Bool("localTx", false, "Sends a tx on the local testnet")
+	rpcAddr := flag.String("rpcAddr", rpc.DefaultAddr, "The address the rpc server listens on")
 
 	flag.Parse()
 
@@ -40,7 +43,11 @@ func main() {
 		mux := localNode.InitMux()
 
 		// Run the server locally, and as a go routine, the sudo multi threading.
-		go http.ListenAndServe(":8180", &mux)
+		go http.ListenAndServe(":8180", mux)
+
+		// Run the rpc server next to it, for querying the node over http
+		rpcServer := rpc.Init(&bc, &mem, &wallet)
+		go http.ListenAndServe(*rpcAddr, rpcServer.InitMux())
 
 		// Also start the node mining process.
 		nodeMiner := node.InitNodeMiner(localNode, &bc, &mem, miner, keys, &wallet, "local")
@@ -54,11 +61,15 @@ func main() {
 		fmt.Println("!==========!")
 
 		// Load the local blockchain
-		bc.LoadBlockchain("localBlockchain")
+		if err := bc.LoadBlockchain("localBlockchain"); err != nil {
+
+			fmt.Println(color.Colorize(color.Red, "[BLOCKCHAIN]: Error: "+err.Error()))
+			return
+		}
 
 		// Get and print the available balance
 		balance := wallet.ScanChainForBalance(keys.GetPubKeyStr())
-		fmt.Println("Available Balance:", balance/1000000)
+		fmt.Println("Available Balance:", utilities.ToLNCH(balance))
 
 		if balance == 0 {
 
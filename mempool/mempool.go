@@ -1,15 +1,42 @@
 package mempool
 
 import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/ellip"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/wallet"
+	"golang.org/x/crypto/sha3"
+)
+
+// The most weight of txs the mempool holds, before it evicts the txs paying the lowest fee rate
+var MaxMempoolWeight uint = 100 * 1000000
+
+// The reasons a tx can be rejected by AddTx.
+var (
+	ErrFeeOutOfRange        = errors.New("tx fee is outside of the fees the network allows")
+	ErrBadSignature         = errors.New("tx signature is not valid for its sender")
+	ErrStaleNonce           = errors.New("tx nonce was already used on the blockchain")
+	ErrFutureNonce          = errors.New("tx nonce is past the next nonce of its sender, only one tx per sender can be pending")
+	ErrReplacementFeeTooLow = errors.New("tx does not pay more than the tx it would replace")
+	ErrInvalidTx            = errors.New("tx is not valid on the blockchain")
+	ErrMempoolFull          = errors.New("mempool is full and the tx does not pay more than the lowest fee rate in it")
 )
 
 // The mempool struct, containing all the tx's waiting to be added to the next available block.
 type Mempool struct {
+	// The txs waiting to be mined. Only read or changed through the methods of the mempool, which hold the mutex
 	Txs []transactions.LuTx
 
 	wal *wallet.Wallet
+
+	// Guards the txs, as the rpc server, the p2p handlers and the node miner all change them from their own go-routines.
+	// Shared by every copy of the mempool
+	mutex *sync.Mutex
 }
 
 // Initialize the mempool with a wallet.
@@ -19,35 +46,311 @@ func Init(wal *wallet.Wallet) Mempool {
 	m := new(Mempool)
 
 	m.wal = wal
+	m.mutex = new(sync.Mutex)
 
 	return *m
 }
 
 // Function adds a tx to the mempool of the blockchain.
+// Only one tx per sender can be pending, as blocks only take the tx using the next nonce of the sender on the blockchain,
+// so a tx has to use that nonce, and the sender has to wait for it to be mined before sending the next one.
+// A tx with the same sender and nonce as a tx already in the mempool replaces it, but only if it pays a higher fee.
+// If the mempool is full, the tx has to pay a higher fee rate than the lowest tx in the mempool, which is then evicted.
 // Inputs the tx you are adding.
-// Returns true if successfully added, false if tx was invalid.
-func (m *Mempool) AddTx(tx *transactions.LuTx) bool {
+// Returns nil if successfully added, or an error saying why the tx was rejected.
+func (m *Mempool) AddTx(tx transactions.LuTx) error {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// If the tx pays a fee the network does not allow
+	if !m.wal.ChainParams().FeeInRange(tx.Fee) {
+
+		return ErrFeeOutOfRange
+	}
+
+	if !validSig(&tx, m.wal.ChainParams().SigScheme()) {
+
+		return ErrBadSignature
+	}
+
+	nonce := m.wal.ScanChainForNonce(tx.TxFrom)
+
+	// If the nonce was already used by a tx on the blockchain
+	if tx.Nonce < nonce {
+
+		return ErrStaleNonce
+	}
+
+	// If the tx would have to wait behind another tx of the sender
+	if tx.Nonce > nonce {
+
+		return ErrFutureNonce
+	}
+
+	replaceIndex := m.findNonce(tx.TxFrom, tx.Nonce)
+
+	if replaceIndex != -1 && tx.Fee <= m.Txs[replaceIndex].Fee {
+
+		return ErrReplacementFeeTooLow
+	}
+
+	// If the tx can not be spent, like from too low of a balance
+	if !m.wal.VerifyTx(tx) {
+
+		return ErrInvalidTx
+	}
+
+	// The tx being replaced makes room for the new one
+	weight := m.weight() + tx.GetWeight()
+
+	if replaceIndex != -1 {
+
+		weight -= m.Txs[replaceIndex].GetWeight()
+	}
+
+	// If the mempool is full, and the tx pays no more than what would be evicted
+	if weight > MaxMempoolWeight {
+
+		lowestIndex := m.lowestFeeRate()
+
+		if lowestIndex == -1 || feeRate(&tx) <= feeRate(&m.Txs[lowestIndex]) {
+
+			return ErrMempoolFull
+		}
+	}
+
+	if replaceIndex != -1 {
 
-	// If the tx is valid
-	if m.wal.VerifyTx(*tx) {
+		m.removeAt(replaceIndex)
+	}
+
+	m.Txs = append(m.Txs, tx)
+	m.trimToSize()
+
+	return nil
+}
+
+// Checks if the signature of a tx is valid for its TxFrom public key, under the signature scheme of the network.
+// Returns true if the signature is valid, false if not.
+func validSig(tx *transactions.LuTx, scheme ellip.SignatureScheme) bool {
+
+	signature, sigErr := hex.DecodeString(tx.Signature)
+	pubKey, pubKeyErr := hex.DecodeString(tx.TxFrom)
+
+	if sigErr != nil || pubKeyErr != nil {
+
+		return false
+	}
+
+	return scheme.Verify(pubKey, tx.SigHash(), signature)
+}
+
+// Finds the tx in the mempool from a sender with a nonce.
+// Returns the index of the tx, or -1 if there is none.
+func (m *Mempool) findNonce(txFrom string, nonce uint32) int {
+
+	for index := 0; index < len(m.Txs); index += 1 {
+
+		if m.Txs[index].TxFrom == txFrom && m.Txs[index].Nonce == nonce {
+
+			return index
+		}
+	}
+
+	return -1
+}
 
-		m.Txs = append(m.Txs, *tx)
-		return true
+// Evicts the txs paying the lowest fee rate, until the mempool is at or under MaxMempoolWeight.
+// Returns the txids of the evicted txs.
+func (m *Mempool) TrimToSize() []string {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.trimToSize()
+}
+
+// Evicts the txs paying the lowest fee rate, like TrimToSize, with the mutex already held.
+// Returns the txids of the evicted txs.
+func (m *Mempool) trimToSize() []string {
+
+	evicted := []string{}
+	weight := m.weight()
+
+	for weight > MaxMempoolWeight && len(m.Txs) != 0 {
+
+		lowestIndex := m.lowestFeeRate()
+
+		weight -= m.Txs[lowestIndex].GetWeight()
+		evicted = append(evicted, m.Txs[lowestIndex].HashTx())
+
+		m.removeAt(lowestIndex)
 	}
 
-	return false
+	return evicted
 }
 
-// This function removes a tx from the mempool.
+// Calculates the total weight of the txs in the mempool.
+// Returns the weight.
+func (m *Mempool) Weight() uint {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.weight()
+}
+
+// Calculates the total weight of the txs in the mempool, with the mutex already held.
+// Returns the weight.
+func (m *Mempool) weight() (weight uint) {
+
+	for index := 0; index < len(m.Txs); index += 1 {
+
+		weight += m.Txs[index].GetWeight()
+	}
+
+	return weight
+}
+
+// Calculates the total weight of the txs in the mempool paying a fee rate of at least feePerWeight.
+// These are the txs a miner would put in a block before a tx paying feePerWeight.
+// Returns the weight.
+func (m *Mempool) WeightAtFeeRate(feePerWeight uint64) (weight uint) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for index := 0; index < len(m.Txs); index += 1 {
+
+		if feeRate(&m.Txs[index]) >= float64(feePerWeight) {
+
+			weight += m.Txs[index].GetWeight()
+		}
+	}
+
+	return weight
+}
+
+// Finds the tx paying the lowest fee rate in the mempool.
+// Returns the index of the tx, or -1 if the mempool is empty.
+func (m *Mempool) lowestFeeRate() int {
+
+	lowestIndex := -1
+
+	for index := 0; index < len(m.Txs); index += 1 {
+
+		if lowestIndex == -1 || feeRate(&m.Txs[index]) < feeRate(&m.Txs[lowestIndex]) {
+
+			lowestIndex = index
+		}
+	}
+
+	return lowestIndex
+}
+
+// Calculates the fee rate of a tx, in LUNCHEON per weight.
+// Returns the fee rate.
+func feeRate(tx *transactions.LuTx) float64 {
+
+	return float64(tx.Fee) / float64(tx.GetWeight())
+}
+
+// This function removes a tx from the mempool by its txid, like when it is mined in a block.
+// Returns nothing.
+func (m *Mempool) RemoveTx(hash string) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.removeTx(hash)
+}
+
+// Removes a tx from the mempool by its txid, with the mutex already held.
+// Returns nothing.
+func (m *Mempool) removeTx(hash string) {
+
+	for index := 0; index < len(m.Txs); index += 1 {
+
+		if m.Txs[index].HashTx() == hash {
+
+			m.removeAt(index)
+			return
+		}
+	}
+}
+
+// Removes the tx at an index of the mempool.
 // Returns nothing.
-func (m *Mempool) RemoveTx(index int) {
+func (m *Mempool) removeAt(index int) {
 
 	m.Txs = append(m.Txs[:index], m.Txs[index+1:]...)
 }
 
+// Takes the txs paying the highest fee rate out of the mempool, as many as fit in the weight inputted.
+// The weight can not be more than blockchain.MaxWeight, the most a block can hold.
+// A tx too heavy to fit is skipped, so lighter txs paying less can still fill the space left.
+// Returns the txs, highest fee rate first, in the order a block holds them.
+func (m *Mempool) PullByFee(maxWeight uint) []transactions.LuTx {
+
+	if maxWeight > blockchain.MaxWeight {
+
+		maxWeight = blockchain.MaxWeight
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sorted := m.pending()
+	blockchain.SortTxs(sorted)
+
+	pulled := []transactions.LuTx{}
+	var weight uint
+
+	for index := 0; index < len(sorted); index += 1 {
+
+		txWeight := sorted[index].GetWeight()
+
+		if weight+txWeight > maxWeight {
+
+			continue
+		}
+
+		weight += txWeight
+		pulled = append(pulled, sorted[index])
+
+		m.removeTx(sorted[index].HashTx())
+	}
+
+	return pulled
+}
+
+// Gets the txs waiting in the mempool, without removing them.
+// Returns a copy of the txs.
+func (m *Mempool) Pending() []transactions.LuTx {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.pending()
+}
+
+// Gets a copy of the txs waiting in the mempool, with the mutex already held.
+// Returns a copy of the txs.
+func (m *Mempool) pending() []transactions.LuTx {
+
+	txs := make([]transactions.LuTx, len(m.Txs))
+	copy(txs, m.Txs)
+
+	return txs
+}
+
 // Gets and returns a valid tx.
 func (m *Mempool) GetTx() transactions.LuTx {
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	// If no txs
 	if len(m.Txs) == 0 {
 
@@ -55,7 +358,38 @@ func (m *Mempool) GetTx() transactions.LuTx {
 	}
 
 	tx := m.Txs[0]
-	m.RemoveTx(0)
+	m.removeAt(0)
 
 	return tx
 }
+
+// Hashes the set of txs in the mempool, so peers can quickly tell if their mempools match.
+// The txids are sorted before hashing, so the order the txs were added in does not matter.
+// Returns the hex string of the hash.
+func (m *Mempool) StateHash() string {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	txids := make([]string, len(m.Txs))
+
+	for index := 0; index < len(m.Txs); index += 1 {
+
+		txids[index] = m.Txs[index].HashTx()
+	}
+
+	sort.Strings(txids)
+
+	stateBytes := []byte{}
+
+	for index := 0; index < len(txids); index += 1 {
+
+		txid, _ := hex.DecodeString(txids[index])
+		stateBytes = append(stateBytes, txid...)
+	}
+
+	hash := make([]byte, 32)
+	sha3.ShakeSum256(hash, stateBytes)
+
+	return hex.EncodeToString(hash)
+}
@@ -1,12 +1,20 @@
 package mempool
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/hex"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/ellip"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/wallet"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 func TestCreateTx(t *testing.T) {
@@ -17,12 +25,600 @@ func TestCreateTx(t *testing.T) {
 	wal := wallet.Init(&bc)
 	mem := Init(&wal)
 
-	miner.Start(&bc.Blocks[0], &bc, bc.GetDifficulty())
+	if _, err := miner.Start(&bc.Blocks[0], &bc, bc.GetDifficulty()); err != nil {
+
+		t.Fatal(err)
+	}
 
 	fmt.Println("Balance:", wal.ScanChainForBalance(key.GetPubKeyStr()))
 
 	tx := wal.CreateTx("kaimorton123", 2000)
 
-	test := mem.AddTx(&tx)
-	fmt.Println("Added tx:", test)
+	err := mem.AddTx(tx)
+	fmt.Println("Added tx:", err)
+}
+
+func TestCreateTxSchemes(t *testing.T) {
+
+	secpKey, err := crypto.GenerateKey()
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	edPub, edPriv, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scheme  ellip.SignatureScheme
+		privKey []byte
+		pubKey  []byte
+	}{
+		{ellip.Secp256k1{}, crypto.FromECDSA(secpKey), elliptic.Marshal(crypto.S256(), secpKey.X, secpKey.Y)},
+		{ellip.Ed25519{}, edPriv, edPub},
+	}
+
+	for _, test := range tests {
+
+		// The key has a mature block reward to send from
+		bc := new(blockchain.Blockchain)
+		bc.Blocks = make([]blockchain.Block, int(blockchain.RewardMaturity)+2)
+		bc.Blocks[0].Miner = hex.EncodeToString(test.pubKey)
+
+		params := blockchain.MainnetParams
+		params.SignatureScheme = test.scheme
+		bc.SetParams(params)
+
+		wal := wallet.Init(bc)
+		mem := Init(&wal)
+
+		if err := wal.SetKey(test.privKey, test.pubKey); err != nil {
+
+			t.Fatalf("%T: %v", test.scheme, err)
+		}
+
+		tx := wal.CreateTx("kaimorton123", 2000)
+
+		if tx.TxFrom != hex.EncodeToString(test.pubKey) {
+
+			t.Fatalf("%T: expected the tx to be sent from the key set, got %q", test.scheme, tx.TxFrom)
+		}
+
+		if !wal.VerifyTx(tx) {
+
+			t.Errorf("%T: expected the created tx to be valid", test.scheme)
+		}
+
+		if err := mem.AddTx(tx); err != nil {
+
+			t.Errorf("%T: expected the created tx to be added, got %v", test.scheme, err)
+		}
+	}
+}
+
+func TestReconcileAfterReorg(t *testing.T) {
+
+	key, err := crypto.GenerateKey()
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	pubKey := elliptic.Marshal(crypto.S256(), key.X, key.Y)
+
+	// The wallet has a mature block reward to spend
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = make([]blockchain.Block, int(blockchain.RewardMaturity)+2)
+	bc.Blocks[0].Miner = hex.EncodeToString(pubKey)
+	bc.Blocks[len(bc.Blocks)-1].BlockHash = "common"
+	common := bc.GetHeight()
+
+	wal := wallet.Init(bc)
+	mem := Init(&wal)
+
+	if err := wal.SetKey(crypto.FromECDSA(key), pubKey); err != nil {
+
+		t.Fatal(err)
+	}
+
+	// Two txs of the wallet, one after the other, each confirmed on the branch the reorg orphans
+	first := wal.CreateTx("kaimorton123", 2000)
+	bc.AddBlock(&blockchain.Block{BlockHash: "old1", PrevHash: "common", Txs: []transactions.LuTx{first}})
+
+	second := wal.CreateTx("kaimorton123", 2000)
+	bc.AddBlock(&blockchain.Block{BlockHash: "old2", PrevHash: "old1", Txs: []transactions.LuTx{second}})
+
+	if second.Nonce != first.Nonce+1 {
+
+		t.Fatalf("Expected the second tx to follow the first, got nonces %d and %d", first.Nonce, second.Nonce)
+	}
+
+	bc.RollbackTo(common)
+	bc.AddBlock(&blockchain.Block{BlockHash: "new1", PrevHash: "common"})
+
+	result, err := wal.ReconcileAfterReorg("old2", "new1", &mem)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	// The mempool holds one pending tx from each sender, so the second tx waits for the first to be mined
+	if len(result.Requeued) != 1 || result.Requeued[0].HashTx() != first.HashTx() {
+
+		t.Errorf("Expected the first tx to be requeued, got %+v", result.Requeued)
+	}
+
+	if len(result.Pending) != 1 || result.Pending[0].HashTx() != second.HashTx() || len(result.Dropped) != 0 {
+
+		t.Errorf("Expected the second tx to be pending, got %+v and dropped %+v", result.Pending, result.Dropped)
+	}
+}
+
+func TestMempoolConcurrent(t *testing.T) {
+
+	fees := make([]uint64, 20)
+
+	for index := range fees {
+
+		fees[index] = uint64(1000 + index)
+	}
+
+	mem, txs := newFundedMempool(t, fees...)
+
+	// The rpc server, the p2p handlers and the node miner all use the mempool at once
+	waitGroup := new(sync.WaitGroup)
+
+	for index := range txs {
+
+		waitGroup.Add(1)
+
+		go func(tx transactions.LuTx) {
+
+			defer waitGroup.Done()
+
+			if err := mem.AddTx(tx); err != nil {
+
+				t.Errorf("Expected the tx to be added, got %v", err)
+			}
+
+			mem.Pending()
+			mem.StateHash()
+		}(txs[index])
+	}
+
+	pulled := []transactions.LuTx{}
+	pullMutex := new(sync.Mutex)
+
+	for index := 0; index < 4; index += 1 {
+
+		waitGroup.Add(1)
+
+		go func() {
+
+			defer waitGroup.Done()
+
+			txs := mem.PullByFee(blockchain.MaxWeight)
+
+			pullMutex.Lock()
+			pulled = append(pulled, txs...)
+			pullMutex.Unlock()
+		}()
+	}
+
+	waitGroup.Wait()
+
+	// Every tx was either pulled once, or is still waiting
+	if len(pulled)+len(mem.Pending()) != len(txs) {
+
+		t.Errorf("Expected %d txs pulled or pending, got %d pulled and %d pending", len(txs), len(pulled), len(mem.Pending()))
+	}
+}
+
+// Creates a mempool on a blockchain where each tx sender has a mature block reward, and signs a tx from each sender.
+// The txs pay the fees inputted, in order.
+// Returns the mempool and the signed txs.
+func newFundedMempool(t *testing.T, fees ...uint64) (*Mempool, []transactions.LuTx) {
+
+	mem, txs, _ := newFundedMempoolKeys(t, fees...)
+
+	return mem, txs
+}
+
+// Creates a mempool like newFundedMempool, also returning the key of each tx sender so more txs can be signed.
+// Returns the mempool, the signed txs, and the keys of their senders.
+func newFundedMempoolKeys(t *testing.T, fees ...uint64) (*Mempool, []transactions.LuTx, []*ecdsa.PrivateKey) {
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = make([]blockchain.Block, len(fees)+int(blockchain.RewardMaturity)+1)
+
+	txs := []transactions.LuTx{}
+	keys := []*ecdsa.PrivateKey{}
+
+	for index, fee := range fees {
+
+		key, err := crypto.GenerateKey()
+
+		if err != nil {
+
+			t.Fatal(err)
+		}
+
+		pubKey := hex.EncodeToString(elliptic.Marshal(crypto.S256(), key.X, key.Y))
+		bc.Blocks[index].Miner = pubKey
+
+		tx := transactions.LuTx{TxFrom: pubKey, TxTo: "kaimorton123", Value: 2000, Fee: fee}
+		tx.Signature = hex.EncodeToString(ellip.SignHash(key, tx.SigHash()))
+
+		txs = append(txs, tx)
+		keys = append(keys, key)
+	}
+
+	wal := wallet.Init(bc)
+	mem := Init(&wal)
+
+	return &mem, txs, keys
+}
+
+func TestTrimToSize(t *testing.T) {
+
+	defer func(maxWeight uint) { MaxMempoolWeight = maxWeight }(MaxMempoolWeight)
+
+	mem, txs := newFundedMempool(t, 3000, 1000, 5000, 2000, 4000)
+
+	for index := range txs {
+
+		if err := mem.AddTx(txs[index]); err != nil {
+
+			t.Fatalf("Expected tx %d to be added, got %v", index, err)
+		}
+	}
+
+	// Shrink the mempool to fit only the three best paying txs
+	MaxMempoolWeight = mem.Weight() - txs[1].GetWeight() - txs[3].GetWeight()
+
+	evicted := mem.TrimToSize()
+	expected := []string{txs[1].HashTx(), txs[3].HashTx()}
+
+	if len(evicted) != len(expected) || evicted[0] != expected[0] || evicted[1] != expected[1] {
+
+		t.Fatalf("Expected the lowest fee txs %v to be evicted, got %v", expected, evicted)
+	}
+
+	if len(mem.Txs) != 3 || mem.Weight() > MaxMempoolWeight {
+
+		t.Error("Expected the mempool to be trimmed to its max weight")
+	}
+
+	if evicted := mem.TrimToSize(); len(evicted) != 0 {
+
+		t.Errorf("Expected nothing to be evicted from a mempool under its max weight, got %v", evicted)
+	}
+}
+
+func TestAddTxFullMempool(t *testing.T) {
+
+	defer func(maxWeight uint) { MaxMempoolWeight = maxWeight }(MaxMempoolWeight)
+
+	mem, txs := newFundedMempool(t, 3000, 4000, 5000, 1000, 9000)
+
+	for index := 0; index < 3; index += 1 {
+
+		mem.AddTx(txs[index])
+	}
+
+	// The mempool is full with the first three txs
+	MaxMempoolWeight = mem.Weight()
+
+	if err := mem.AddTx(txs[3]); err != ErrMempoolFull {
+
+		t.Errorf("Expected a tx paying less than the lowest tx of a full mempool to be rejected with ErrMempoolFull, got %v", err)
+	}
+
+	if err := mem.AddTx(txs[4]); err != nil {
+
+		t.Fatalf("Expected a tx paying more than the lowest tx of a full mempool to be added, got %v", err)
+	}
+
+	for index := range mem.Txs {
+
+		if mem.Txs[index].HashTx() == txs[0].HashTx() {
+
+			t.Error("Expected the lowest fee tx to be evicted for the new tx")
+		}
+	}
+}
+
+func TestStateHash(t *testing.T) {
+
+	txs := []transactions.LuTx{
+		{TxFrom: "aa", TxTo: "bb", Value: 10},
+		{TxFrom: "bb", TxTo: "cc", Value: 20},
+		{TxFrom: "cc", TxTo: "aa", Value: 30},
+	}
+
+	memA := Init(nil)
+	memA.Txs = []transactions.LuTx{txs[0], txs[1], txs[2]}
+
+	memB := Init(nil)
+	memB.Txs = []transactions.LuTx{txs[2], txs[0], txs[1]}
+
+	if memA.StateHash() != memB.StateHash() {
+
+		t.Error("Expected mempools with the same txs to have the same hash")
+	}
+
+	memB.Txs[0].Value = 31
+
+	if memA.StateHash() == memB.StateHash() {
+
+		t.Error("Expected mempools with different txs to have different hashes")
+	}
+
+	memB.Txs = memB.Txs[1:]
+
+	if memA.StateHash() == memB.StateHash() {
+
+		t.Error("Expected mempools with a missing tx to have different hashes")
+	}
+
+	if empty := Init(nil); empty.StateHash() == memA.StateHash() {
+
+		t.Error("Expected an empty mempool to have a different hash")
+	}
+}
+
+func TestAddTxFeeRange(t *testing.T) {
+
+	params := blockchain.MainnetParams
+	mem, txs := newFundedMempool(t, params.MinTxFee-1, params.MaxTxFee+1, params.MinTxFee, params.MaxTxFee)
+
+	if err := mem.AddTx(txs[0]); err != ErrFeeOutOfRange {
+
+		t.Error("Expected a tx with a fee below the min to be rejected")
+	}
+
+	if err := mem.AddTx(txs[1]); err != ErrFeeOutOfRange {
+
+		t.Error("Expected a tx with a fee above the max to be rejected")
+	}
+
+	if mem.AddTx(txs[2]) != nil || mem.AddTx(txs[3]) != nil {
+
+		t.Error("Expected txs with fees at the ends of the range to be added")
+	}
+}
+
+func TestEstimateConfirmTime(t *testing.T) {
+
+	defer func(maxWeight uint) { blockchain.MaxWeight = maxWeight }(blockchain.MaxWeight)
+
+	// Blocks are mined every 2 minutes
+	bc := new(blockchain.Blockchain)
+
+	for index := 0; index < 20; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, blockchain.Block{Timestamp: uint64(index * 120)})
+	}
+
+	// A congested mempool, with 10 txs at each fee rate
+	mem := Init(nil)
+
+	for _, fee := range []uint64{1000, 10000, 100000} {
+
+		for index := 0; index < 10; index += 1 {
+
+			tx := transactions.LuTx{TxFrom: fmt.Sprint(index), TxTo: "kaimorton123", Value: 2000, Fee: fee}
+			mem.Txs = append(mem.Txs, tx)
+		}
+	}
+
+	// Each block fits 5 txs
+	blockchain.MaxWeight = mem.Txs[0].GetWeight() * 5
+
+	lowFeeRate := uint64(feeRate(&mem.Txs[0]))
+	highFeeRate := uint64(feeRate(&mem.Txs[len(mem.Txs)-1]))
+
+	tests := []struct {
+		name         string
+		feePerWeight uint64
+		expected     time.Duration
+	}{
+		// All 30 txs are ahead, taking 6 blocks
+		{"lowest fee", lowFeeRate, 7 * 2 * time.Minute},
+		// The 10 txs paying the highest fee are ahead, taking 2 blocks
+		{"highest fee", highFeeRate, 3 * 2 * time.Minute},
+		// No tx is ahead
+		{"above every fee", highFeeRate + 1, 2 * time.Minute},
+	}
+
+	for _, test := range tests {
+
+		if estimate := bc.EstimateConfirmTime(test.feePerWeight, &mem); estimate != test.expected {
+
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, estimate)
+		}
+	}
+}
+
+func TestAddTxReplaceByFee(t *testing.T) {
+
+	mem, txs, keys := newFundedMempoolKeys(t, 2000)
+
+	if err := mem.AddTx(txs[0]); err != nil {
+
+		t.Fatal(err)
+	}
+
+	// The same sender and nonce, paying the same fee to someone else
+	sameFee := txs[0]
+	sameFee.TxTo = "kaimorton456"
+	sameFee.Signature = hex.EncodeToString(ellip.SignHash(keys[0], sameFee.SigHash()))
+
+	if err := mem.AddTx(sameFee); err != ErrReplacementFeeTooLow {
+
+		t.Errorf("Expected ErrReplacementFeeTooLow for a replacement paying the same fee, got %v", err)
+	}
+
+	higherFee := sameFee
+	higherFee.Fee = 3000
+	higherFee.Signature = hex.EncodeToString(ellip.SignHash(keys[0], higherFee.SigHash()))
+
+	if err := mem.AddTx(higherFee); err != nil {
+
+		t.Fatalf("Expected a replacement paying a higher fee to be added, got %v", err)
+	}
+
+	if len(mem.Txs) != 1 || mem.Txs[0].HashTx() != higherFee.HashTx() {
+
+		t.Errorf("Expected only the replacement to be left in the mempool, got %+v", mem.Txs)
+	}
+}
+
+func TestAddTxRejected(t *testing.T) {
+
+	mem, txs, keys := newFundedMempoolKeys(t, 2000, 2000)
+
+	// Signed by the key of another sender
+	badSig := txs[0]
+	badSig.Signature = hex.EncodeToString(ellip.SignHash(keys[1], badSig.SigHash()))
+
+	if err := mem.AddTx(badSig); err != ErrBadSignature {
+
+		t.Errorf("Expected ErrBadSignature, got %v", err)
+	}
+
+	// The first tx of the sender is already on the blockchain
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = make([]blockchain.Block, int(blockchain.RewardMaturity)+2)
+	bc.Blocks[0].Miner = txs[1].TxFrom
+	bc.Blocks[len(bc.Blocks)-1].Txs = []transactions.LuTx{txs[1]}
+
+	wal := wallet.Init(bc)
+	stale := Init(&wal)
+
+	if err := stale.AddTx(txs[1]); err != ErrStaleNonce {
+
+		t.Errorf("Expected ErrStaleNonce for a nonce used on the blockchain, got %v", err)
+	}
+
+	// More than the balance of the sender
+	broke := txs[0]
+	broke.Value = blockchain.MaxSupply
+	broke.Signature = hex.EncodeToString(ellip.SignHash(keys[0], broke.SigHash()))
+
+	if err := mem.AddTx(broke); err != ErrInvalidTx {
+
+		t.Errorf("Expected ErrInvalidTx for a tx spending more than the balance, got %v", err)
+	}
+
+	if len(mem.Txs) != 0 {
+
+		t.Errorf("Expected no rejected tx to be added, got %+v", mem.Txs)
+	}
+}
+
+func TestAddTxPendingNonces(t *testing.T) {
+
+	mem, txs, keys := newFundedMempoolKeys(t, 2000)
+
+	if err := mem.AddTx(txs[0]); err != nil {
+
+		t.Fatal(err)
+	}
+
+	// The next nonce of the sender can not be queued until the first tx is mined
+	next := txs[0]
+	next.Nonce += 1
+	next.Signature = hex.EncodeToString(ellip.SignHash(keys[0], next.SigHash()))
+
+	if err := mem.AddTx(next); err != ErrFutureNonce {
+
+		t.Errorf("Expected ErrFutureNonce for a second pending tx of the sender, got %v", err)
+	}
+
+	if len(mem.Txs) != 1 || mem.Txs[0].HashTx() != txs[0].HashTx() {
+
+		t.Errorf("Expected only the first tx of the sender to be pending, got %+v", mem.Txs)
+	}
+}
+
+func TestRemoveTx(t *testing.T) {
+
+	mem, txs := newFundedMempool(t, 1000, 2000, 3000)
+
+	for index := range txs {
+
+		if err := mem.AddTx(txs[index]); err != nil {
+
+			t.Fatal(err)
+		}
+	}
+
+	mem.RemoveTx(txs[1].HashTx())
+	mem.RemoveTx("not a txid")
+
+	if len(mem.Txs) != 2 || mem.Txs[0].HashTx() != txs[0].HashTx() || mem.Txs[1].HashTx() != txs[2].HashTx() {
+
+		t.Errorf("Expected only the removed tx to be gone, got %+v", mem.Txs)
+	}
+}
+
+func TestPullByFee(t *testing.T) {
+
+	defer func(maxWeight uint) { blockchain.MaxWeight = maxWeight }(blockchain.MaxWeight)
+
+	mem, txs := newFundedMempool(t, 3000, 1000, 5000, 2000, 4000)
+
+	for index := range txs {
+
+		if err := mem.AddTx(txs[index]); err != nil {
+
+			t.Fatal(err)
+		}
+	}
+
+	// Room for the three highest fee txs, and a little more that no tx fits in
+	maxWeight := txs[2].GetWeight() + txs[4].GetWeight() + txs[0].GetWeight() + 10
+
+	pulled := mem.PullByFee(maxWeight)
+	expected := []transactions.LuTx{txs[2], txs[4], txs[0]}
+
+	if len(pulled) != len(expected) {
+
+		t.Fatalf("Expected %d txs to be pulled, got %d", len(expected), len(pulled))
+	}
+
+	for index := range expected {
+
+		if pulled[index].HashTx() != expected[index].HashTx() {
+
+			t.Errorf("Expected tx %d to pay a fee of %d, got %d", index, expected[index].Fee, pulled[index].Fee)
+		}
+	}
+
+	// The pulled txs are taken out of the mempool
+	if len(mem.Txs) != 2 {
+
+		t.Errorf("Expected the two lowest fee txs to be left, got %d", len(mem.Txs))
+	}
+
+	// The weight can not be more than a block holds
+	blockchain.MaxWeight = txs[3].GetWeight()
+
+	if pulled := mem.PullByFee(blockchain.MaxWeight * 10); len(pulled) != 1 || pulled[0].HashTx() != txs[3].HashTx() {
+
+		t.Errorf("Expected only the highest fee tx to fit in a block, got %+v", pulled)
+	}
+
+	if pulled := mem.PullByFee(0); len(pulled) != 0 {
+
+		t.Errorf("Expected no txs to fit in a weight of 0, got %+v", pulled)
+	}
 }
@@ -2,14 +2,18 @@ package node
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/mempool"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/wallet"
 	"github.com/TwiN/go-color"
 )
@@ -20,7 +24,11 @@ type Node struct {
 	wal     *wallet.Wallet
 	mainnet bool
 
-	Peers []string
+	// The addresses of the peers, and their heights learned when handshaking with them
+	// Peers can be added, removed and handshaked with at the same time as the handlers run, so both are only used with the mutex
+	Peers       []string
+	peerHeights map[string]uint
+	peersMutex  sync.RWMutex
 }
 
 // Inits the Node.
@@ -35,13 +43,14 @@ func Init(bc *blockchain.Blockchain, mempool *mempool.Mempool, mainnet bool, wal
 	n.mem = mempool
 	n.mainnet = mainnet
 	n.wal = wallet
+	n.peerHeights = make(map[string]uint)
 
 	return n
 }
 
 // Initiates the mux for the server.
 // Returns the ServerMux of all of the Handled functions of the client.
-func (n *Node) InitMux() http.ServeMux {
+func (n *Node) InitMux() *http.ServeMux {
 
 	mux := http.NewServeMux()
 
@@ -50,8 +59,12 @@ func (n *Node) InitMux() http.ServeMux {
 	mux.HandleFunc("/status", n.Status)
 	mux.HandleFunc("/newblock", n.Newblock)
 	mux.HandleFunc("/getbc", n.SendBlockchain)
+	mux.HandleFunc("/handshake", n.Handshake)
+	mux.HandleFunc("/submitblock", n.SubmitBlock)
+	mux.HandleFunc("/getblocktemplate", n.GetBlockTemplate)
+	mux.HandleFunc("/health", n.Health)
 
-	return *mux
+	return mux
 }
 
 // Adds a tx to the mempool.
@@ -87,7 +100,16 @@ func (n *Node) AddTx(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add the tx to the mempool
-	n.mem.AddTx(tx)
+	err = n.mem.AddTx(*tx)
+
+	if err != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Transaction was rejected by the mempool. Err: ") + err.Error())
+
+		// Tells the client that the tx was not accepted
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
 
 	// Tells the client that the tx was accepted
 	w.WriteHeader(http.StatusAccepted)
@@ -172,3 +194,195 @@ func (n *Node) SendBlockchain(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// Handshakes with a node that is connecting to you.
+// The node sends its handshake, and if it is compatible, this node responds with its own.
+// Incompatible nodes are refused with a StatusNotAcceptable.
+// Returns nothing.
+// Accessed by "/handshake".
+func (n *Node) Handshake(w http.ResponseWriter, r *http.Request) {
+
+	// Get the body of the http message.
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Could not read handshake. Err:") + err.Error())
+
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	peerShake := new(Handshake)
+
+	err = json.Unmarshal(body, peerShake)
+
+	if err != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Could not unmarshal handshake. Err:") + err.Error())
+
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	// If the node can not talk with this one
+	if !peerShake.Compatible() {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Refused handshake from an incompatible node."))
+
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	shake := n.NewHandshake()
+
+	// Send this nodes handshake back
+	w.WriteHeader(http.StatusOK)
+	w.Write(shake.AsBytes())
+}
+
+// The response to a submitted block.
+// Reason is the reason the block was rejected, and is empty if the block was accepted.
+type SubmitResult struct {
+	Accepted bool
+	Reason   string
+}
+
+// Lets pools and external miners submit a block they have solved.
+// The body is the hex of the block, which is verified before being added to the chain and sent to all peers.
+// Responds with a SubmitResult, with a StatusAccepted if the block was added, or a StatusNotAcceptable if not.
+// Returns nothing.
+// Accessed by "/submitblock".
+func (n *Node) SubmitBlock(w http.ResponseWriter, r *http.Request) {
+
+	result := new(SubmitResult)
+	block := new(blockchain.Block)
+
+	// Get the body of the http message.
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err == nil {
+
+		*block, err = blockchain.BlockFromHex(string(body))
+	}
+
+	if err == nil {
+
+		err = n.wal.VerifyBlockE(block, true)
+	}
+
+	if err != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Rejected submitted block. Err: ") + err.Error())
+
+		result.Reason = err.Error()
+		resultBytes, _ := json.Marshal(result)
+
+		w.WriteHeader(http.StatusNotAcceptable)
+		w.Write(resultBytes)
+		return
+	}
+
+	// Add the block to the chain
+	n.bc.AddBlock(block)
+
+	result.Accepted = true
+	resultBytes, _ := json.Marshal(result)
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(resultBytes)
+
+	fmt.Println(color.Colorize(color.Green, "[NODE]: Successfully received new submitted block."))
+
+	// Send the block to all your known peers
+	n.SendDataToAll("/newblock", bytes.NewBuffer(block.AsBytes()))
+}
+
+// Gives external miners a template of the next block to work on, filled with the txs of the mempool.
+// The public key the block reward goes to is given by the "miner" query, ex "/getblocktemplate?miner=04ab...".
+// Solved templates are sent back through "/submitblock".
+// Returns nothing.
+// Accessed by "/getblocktemplate".
+func (n *Node) GetBlockTemplate(w http.ResponseWriter, r *http.Request) {
+
+	minerId := r.URL.Query().Get("miner")
+	minerKey, err := hex.DecodeString(minerId)
+
+	// If the block reward would go to an invalid public key
+	if err != nil || !n.bc.Params().SigScheme().ValidPubKey(minerKey) {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Refused block template for an invalid miner."))
+
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Copy the txs, so the template does not change the mempool
+	txs := n.mem.Pending()
+
+	template := n.bc.NewBlockTemplate(minerId, txs)
+	templateBytes, _ := json.Marshal(template)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(templateBytes)
+}
+
+// The oldest the tip can be before the node is reported as unhealthy, as the node has most likely stopped getting blocks
+var MaxTipAge = 30 * time.Minute
+
+// The response to a health check.
+// TipAge is the seconds since the tip was mined, and Syncing is true if a peer has a higher blockchain.
+type HealthStatus struct {
+	Height  uint
+	Peers   int
+	TipAge  uint64
+	Syncing bool
+}
+
+// Lets monitoring check that the node is alive and up to date.
+// Responds with a HealthStatus, with a StatusOK if the tip is newer than MaxTipAge, or a StatusServiceUnavailable if not.
+// Returns nothing.
+// Accessed by "/health".
+func (n *Node) Health(w http.ResponseWriter, r *http.Request) {
+
+	status := new(HealthStatus)
+
+	code := http.StatusServiceUnavailable
+	tip, found := n.bc.Tip()
+
+	if found {
+
+		status.Height = n.bc.GetHeight()
+
+		// A tip from the future is treated as just mined
+		if now := new(utilities.Time).Now(); now > tip.Timestamp {
+
+			status.TipAge = now - tip.Timestamp
+		}
+
+		if time.Duration(status.TipAge)*time.Second <= MaxTipAge {
+
+			code = http.StatusOK
+		}
+	}
+
+	// The peers and their heights can change while a handshake runs at the same time
+	n.peersMutex.RLock()
+
+	status.Peers = len(n.Peers)
+
+	for _, peerHeight := range n.peerHeights {
+
+		if peerHeight > status.Height {
+
+			status.Syncing = true
+		}
+	}
+
+	n.peersMutex.RUnlock()
+
+	statusBytes, _ := json.Marshal(status)
+
+	w.WriteHeader(code)
+	w.Write(statusBytes)
+}
@@ -0,0 +1,391 @@
+package node
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/mempool"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/wallet"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/sha3"
+)
+
+// A target easy enough to mine blocks in tests.
+const testTarget = 0x1f0fffff
+
+// Creates a node whose blockchain has a genisis block with an easy target, and one mined block.
+// Returns the node.
+func newMiningTestNode(t *testing.T) *Node {
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: newTestPubKey(t)})
+
+	block := bc.CreateBlock(newTestPubKey(t))
+	mineTestBlock(t, bc, &block)
+	bc.AddBlock(&block)
+
+	wal := wallet.Init(bc)
+	mem := mempool.Init(&wal)
+
+	return Init(bc, &mem, false, &wal)
+}
+
+// Creates a new random public key for testing.
+// Returns the hex string of the public key.
+func newTestPubKey(t *testing.T) string {
+
+	key, err := crypto.GenerateKey()
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return hex.EncodeToString(elliptic.Marshal(crypto.S256(), key.X, key.Y))
+}
+
+// Mines the block inputted on the blockchain inputted.
+// Returns nothing.
+func mineTestBlock(t *testing.T, bc *blockchain.Blockchain, block *blockchain.Block) {
+
+	miner := new(blockchain.Miner)
+	miner.Out = io.Discard
+
+	if found, err := miner.Start(block, bc, 1); !found || err != nil {
+
+		t.Fatalf("Could not mine the test block: %v", err)
+	}
+}
+
+// Hashes the block the same way the miner does.
+// Returns the hash of the block.
+func testBlockHash(block *blockchain.Block) []byte {
+
+	bytesUtil := new(utilities.ByteUtil)
+	data := append(block.ParseBlockToBytes(), bytesUtil.Uint32toB(block.Nonce)...)
+
+	hash := make([]byte, 32)
+	sha3.ShakeSum256(hash, data)
+
+	return hash
+}
+
+// Submits the block to the server.
+// Returns the status code and the result of the submission.
+func submitTestBlock(t *testing.T, url string, block *blockchain.Block) (int, SubmitResult) {
+
+	resp, err := http.Post(url+"/submitblock", "text/plain", bytes.NewBufferString(block.ToHex()))
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	result := SubmitResult{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, result
+}
+
+func TestSubmitBlock(t *testing.T) {
+
+	n := newMiningTestNode(t)
+
+	server := httptest.NewServer(n.InitMux())
+	defer server.Close()
+
+	block := n.bc.CreateBlock(newTestPubKey(t))
+	mineTestBlock(t, n.bc, &block)
+
+	status, result := submitTestBlock(t, server.URL, &block)
+
+	if status != http.StatusAccepted || !result.Accepted || result.Reason != "" {
+
+		t.Fatalf("Expected the solved block to be accepted, got status %d and %+v", status, result)
+	}
+
+	if n.bc.GetHeight() != 2 || n.bc.Blocks[2].BlockHash != block.BlockHash {
+
+		t.Error("Expected the accepted block to be added to the chain")
+	}
+}
+
+func TestSubmitBlockInvalidProofOfWork(t *testing.T) {
+
+	n := newMiningTestNode(t)
+
+	server := httptest.NewServer(n.InitMux())
+	defer server.Close()
+
+	block := n.bc.CreateBlock(newTestPubKey(t))
+	mineTestBlock(t, n.bc, &block)
+
+	unpacker := new(utilities.TargetUnpacker)
+	target := unpacker.UnpackAsBytes(block.PackedTarget)
+
+	// Find a nonce whose hash is above the target, and claim it as the block hash
+	for {
+
+		block.Nonce += 1
+		hash := testBlockHash(&block)
+
+		if bytes.Compare(hash, target) == 1 {
+
+			block.BlockHash = hex.EncodeToString(hash)
+			break
+		}
+	}
+
+	status, result := submitTestBlock(t, server.URL, &block)
+
+	if status != http.StatusNotAcceptable || result.Accepted {
+
+		t.Fatalf("Expected the unsolved block to be rejected, got status %d and %+v", status, result)
+	}
+
+	if result.Reason != wallet.ErrBadProofOfWork.Error() {
+
+		t.Errorf("Expected the reason %q, got %q", wallet.ErrBadProofOfWork.Error(), result.Reason)
+	}
+
+	if n.bc.GetHeight() != 1 {
+
+		t.Error("Expected the rejected block to not be added to the chain")
+	}
+}
+
+func TestGetBlockTemplate(t *testing.T) {
+
+	n := newMiningTestNode(t)
+
+	server := httptest.NewServer(n.InitMux())
+	defer server.Close()
+
+	minerPub := newTestPubKey(t)
+
+	resp, err := http.Get(server.URL + "/getblocktemplate?miner=" + minerPub)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	template := blockchain.BlockTemplate{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&template); err != nil {
+
+		t.Fatal(err)
+	}
+
+	tip := n.bc.Blocks[n.bc.GetHeight()]
+
+	if template.Height != 2 || template.PrevHash != tip.BlockHash || template.Miner != minerPub {
+
+		t.Errorf("Expected the template to build on the tip, got %+v", template)
+	}
+
+	if template.PackedTarget != n.bc.CalculatePackedTarget(2) || template.CoinbaseValue != n.bc.GetBlockReward(2) {
+
+		t.Errorf("Expected the template to have the next target and reward, got %+v", template)
+	}
+
+	// Mine the template like an external miner would, and submit it
+	block := template.Block()
+	mineTestBlock(t, n.bc, &block)
+
+	status, result := submitTestBlock(t, server.URL, &block)
+
+	if status != http.StatusAccepted || !result.Accepted {
+
+		t.Errorf("Expected the mined template to be accepted, got status %d and %+v", status, result)
+	}
+}
+
+func TestGetBlockTemplateInvalidMiner(t *testing.T) {
+
+	n := newMiningTestNode(t)
+
+	server := httptest.NewServer(n.InitMux())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/getblocktemplate?miner=kaimorton123")
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusBadRequest {
+
+		t.Errorf("Expected an invalid miner to be refused, got status %d", resp.StatusCode)
+	}
+}
+
+// Gets the health of the node at the url inputted.
+// Returns the status code and the health status.
+func getTestHealth(t *testing.T, url string) (int, HealthStatus) {
+
+	resp, err := http.Get(url + "/health")
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	status := HealthStatus{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, status
+}
+
+func TestHealth(t *testing.T) {
+
+	n := newMiningTestNode(t)
+	n.peerHeights["peer"] = 5
+
+	server := httptest.NewServer(n.InitMux())
+	defer server.Close()
+
+	// The tip was just mined
+	code, status := getTestHealth(t, server.URL)
+
+	if code != http.StatusOK {
+
+		t.Errorf("Expected a fresh tip to be healthy, got status %d", code)
+	}
+
+	if status.Height != 1 || status.TipAge > 60 || !status.Syncing {
+
+		t.Errorf("Expected height 1, a fresh tip and syncing, got %+v", status)
+	}
+
+	// The tip is older than MaxTipAge
+	tip, _ := n.bc.Tip()
+	tip.Timestamp -= uint64((MaxTipAge + time.Minute) / time.Second)
+
+	code, status = getTestHealth(t, server.URL)
+
+	if code != http.StatusServiceUnavailable {
+
+		t.Errorf("Expected a stale tip to be unhealthy, got status %d", code)
+	}
+
+	if status.TipAge < uint64(MaxTipAge/time.Second) {
+
+		t.Errorf("Expected the tip age to be over MaxTipAge, got %d", status.TipAge)
+	}
+}
+
+func TestHealthDuringRemoveNode(t *testing.T) {
+
+	n := newMiningTestNode(t)
+	minerPub := newTestPubKey(t)
+
+	for index := 0; index < 50; index += 1 {
+
+		n.Peers = append(n.Peers, fmt.Sprintf("http://127.0.0.1:%d", 9000+index))
+		n.mem.Txs = append(n.mem.Txs, transactions.LuTx{TxFrom: minerPub, Nonce: uint32(index)})
+	}
+
+	// Remove the peers and change the mempool while the handlers run, which go test -race checks
+	done := make(chan struct{})
+
+	go func() {
+
+		defer close(done)
+
+		for _, peer := range n.PeerList() {
+
+			n.RemoveNode(peer)
+			n.mem.GetTx()
+		}
+	}()
+
+	for removing := true; removing; {
+
+		select {
+
+		case <-done:
+			removing = false
+
+		default:
+			n.Health(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+			n.GetBlockTemplate(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/getblocktemplate?miner="+minerPub, nil))
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	n.Health(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	status := HealthStatus{}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil || status.Peers != 0 {
+
+		t.Errorf("Expected every peer to be removed, got %+v and %v", status, err)
+	}
+}
+
+func TestHealthDuringHandshake(t *testing.T) {
+
+	n := newMiningTestNode(t)
+
+	peer := httptest.NewServer(newTestNode(8).InitMux())
+	defer peer.Close()
+
+	// Check health over and over while handshaking, which go test -race checks
+	done := make(chan struct{})
+
+	go func() {
+
+		defer close(done)
+		n.SendHandshake(peer.URL)
+	}()
+
+	for handshaking := true; handshaking; {
+
+		select {
+
+		case <-done:
+			handshaking = false
+
+		default:
+			n.Health(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+		}
+	}
+
+	// The peer is ahead, so once the handshake is done the node is syncing
+	recorder := httptest.NewRecorder()
+	n.Health(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	status := HealthStatus{}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil || !status.Syncing {
+
+		t.Errorf("Expected the node to be syncing to the peer, got %+v and %v", status, err)
+	}
+}
@@ -45,13 +45,17 @@ func InitNodeMiner(node *Node, bc *blockchain.Blockchain, mem *mempool.Mempool,
 func (nm *NodeMiner) StartMining() {
 
 	// Save the empty blockchain
-	nm.bc.SaveBlockchain(nm.saveName)
+	nm.saveBlockchain()
 
 	// Mine the genisis block
-	nm.miner.Start(&nm.bc.Blocks[0], nm.bc, nm.bc.GetDifficulty())
+	if _, err := nm.miner.Start(&nm.bc.Blocks[0], nm.bc, nm.bc.GetDifficulty()); err != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Could not mine the genisis block. Err:") + err.Error())
+		return
+	}
 
 	// Save the genisis block
-	nm.bc.SaveBlockchain(nm.saveName)
+	nm.saveBlockchain()
 
 	// Create an endless loop of blockchaining
 	for {
@@ -65,10 +69,8 @@ func (nm *NodeMiner) StartMining() {
 		// valid tx could be put in the block, making this node waste time mining an invalid block.
 		nm.bc.AddBlock(&block)
 
-		// Add the txs
-		for txIndex := 0; txIndex < len(nm.mem.Txs); txIndex += 1 {
-
-			tx := nm.mem.GetTx()
+		// Add the txs paying the highest fee rate
+		for _, tx := range nm.mem.PullByFee(blockchain.MaxWeight) {
 
 			// If the tx is valid, add it
 			if nm.wallet.VerifyTx(tx) {
@@ -76,20 +78,28 @@ func (nm *NodeMiner) StartMining() {
 				// If it could not be added, put it back in the mempool
 				if !block.AddTx(tx) {
 
-					nm.mem.AddTx(&tx)
-					break
+					nm.mem.AddTx(tx)
 				}
 			}
 		}
 
+		found, err := nm.miner.Start(&block, nm.bc, nm.bc.GetDifficulty())
+
+		// If the block could not be mined at all
+		if err != nil {
+
+			fmt.Println(color.Colorize(color.Red, "[NODE]: Could not mine block. Err:") + err.Error())
+			return
+		}
+
 		// If the miner finds the block
-		if nm.miner.Start(&block, nm.bc, nm.bc.GetDifficulty()) {
+		if found {
 
 			// Add the newly mined block
 			nm.bc.AddBlock(&block)
 
 			// Save the blockchain
-			nm.bc.SaveBlockchain(nm.saveName)
+			nm.saveBlockchain()
 
 			buffer := bytes.NewBuffer(block.AsBytes())
 
@@ -100,3 +110,14 @@ func (nm *NodeMiner) StartMining() {
 		}
 	}
 }
+
+// Saves the blockchain of the node miner, logging the error if it could not be saved.
+// A failed save does not stop the miner, as the blockchain is saved again with the next block.
+// Returns nothing.
+func (nm *NodeMiner) saveBlockchain() {
+
+	if err := nm.bc.SaveBlockchain(nm.saveName); err != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Could not save the blockchain. Err:") + err.Error())
+	}
+}
@@ -2,13 +2,119 @@ package node
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 
+	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
 	"github.com/TwiN/go-color"
 )
 
+// The version of the p2p protocol.
+// Nodes can only talk with each other if they have the same protocol version.
+const ProtocolVersion uint32 = 1
+
+// The message nodes exchange when they connect.
+// Lets the nodes check they can talk with each other, and learn how far along the others blockchain is.
+type Handshake struct {
+	SoftwareVersion string
+	ProtocolVersion uint32
+	Height          uint
+}
+
+// Creates the handshake of this node.
+// Returns the handshake.
+func (n *Node) NewHandshake() Handshake {
+
+	return Handshake{
+		SoftwareVersion: utilities.SoftwareVersion,
+		ProtocolVersion: ProtocolVersion,
+		Height:          n.bc.GetHeight(),
+	}
+}
+
+// Checks if the node that sent the handshake can talk with this node.
+// Returns true if it is compatible, false if not.
+func (h *Handshake) Compatible() bool {
+
+	return h.ProtocolVersion == ProtocolVersion
+}
+
+// Converts the handshake into its bytes.
+// Returns the byte slice of the handshake.
+func (h *Handshake) AsBytes() []byte {
+
+	hAsBytes, err := json.Marshal(h)
+
+	if err != nil {
+
+		panic(err)
+	}
+
+	return hAsBytes
+}
+
+// This function handshakes with a node, sending it this nodes handshake and reading its handshake back.
+// If the node is incompatible or does not respond, it is dropped from the peers.
+// Input is the ip of the node.
+// Returns true if the handshake was successful, false if not.
+func (n *Node) SendHandshake(nodeIp string) bool {
+
+	shake := n.NewHandshake()
+
+	resp, httpErr := http.Post(nodeIp+"/handshake", "data/json", bytes.NewBuffer(shake.AsBytes()))
+
+	if httpErr != nil {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Could not handshake with peer. Error: "+httpErr.Error()))
+
+		n.RemoveNode(nodeIp)
+		return false
+	}
+
+	defer resp.Body.Close()
+
+	// If the peer refused the handshake
+	if resp.StatusCode != http.StatusOK {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Peer refused the handshake. Dropping peer"))
+
+		n.RemoveNode(nodeIp)
+		return false
+	}
+
+	peerShake := new(Handshake)
+
+	// If the peer responded with a bad handshake, or is incompatible
+	if decodeErr := json.NewDecoder(resp.Body).Decode(peerShake); decodeErr != nil || !peerShake.Compatible() {
+
+		fmt.Println(color.Colorize(color.Red, "[NODE]: Peer is incompatible. Dropping peer"))
+
+		n.RemoveNode(nodeIp)
+		return false
+	}
+
+	n.peersMutex.Lock()
+	n.peerHeights[nodeIp] = peerShake.Height
+	n.peersMutex.Unlock()
+
+	return true
+}
+
+// Gets the height of a peer, learned when handshaking with it.
+// Input is the ip of the peer.
+// Returns the height, and false if the node has not handshaked with the peer.
+func (n *Node) PeerHeight(nodeIp string) (uint, bool) {
+
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+
+	height, found := n.peerHeights[nodeIp]
+
+	return height, found
+}
+
 // This function adds a node to your copy of peers.
 // If they are unresponsive, they will not be added.
 // Input is the ip of the node being added, and whether this is on the mainnet. Set true if this node is on the mainnet.
@@ -51,16 +157,40 @@ func (n *Node) AddNode(nodeIp string, mainnet bool) bool {
 		return false
 	}
 
+	// Make sure the node can talk with this one
+	if !n.SendHandshake(nodeIp) {
+
+		return false
+	}
+
+	n.peersMutex.Lock()
 	n.Peers = append(n.Peers, nodeIp)
+	n.peersMutex.Unlock()
 
 	return true
 }
 
+// Gets the addresses of the peers of the node.
+// Returns a copy of the peers, so they can be used while peers are added or removed.
+func (n *Node) PeerList() []string {
+
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+
+	peers := make([]string, len(n.Peers))
+	copy(peers, n.Peers)
+
+	return peers
+}
+
 // This function removes a peer from the list.
 // Input is the ip of the node.
 // Returns true if they were removed, false if they were not on the list of peers.
 func (n *Node) RemoveNode(nodeIp string) bool {
 
+	n.peersMutex.Lock()
+	defer n.peersMutex.Unlock()
+
 	// Search for the node
 	for index := 0; index < len(n.Peers); index += 1 {
 
@@ -68,6 +198,8 @@ func (n *Node) RemoveNode(nodeIp string) bool {
 		if n.Peers[index] == nodeIp {
 
 			n.Peers = append(n.Peers[:index], n.Peers[index+1:]...)
+			delete(n.peerHeights, nodeIp)
+
 			return true
 		}
 	}
@@ -116,16 +248,18 @@ func (n *Node) SendData(nodeIp string, path string, data *bytes.Buffer) bool {
 // Returns nothing.
 func (n *Node) SendDataToAll(path string, data *bytes.Buffer) {
 
-	// Loops through all of the known peers.
-	for index := 0; index < len(n.Peers); index += 1 {
+	// Loops through all of the known peers, from a copy so peers can be removed along the way
+	peers := n.PeerList()
+
+	for index := 0; index < len(peers); index += 1 {
 
-		resp, httpErr := http.Post(n.Peers[index]+path, "data/json", data)
+		resp, httpErr := http.Post(peers[index]+path, "data/json", data)
 
 		if httpErr != nil {
 
 			fmt.Println(color.Colorize(color.Red, "[NODE]: Error: "+httpErr.Error()))
 
-			if n.RemoveNode(n.Peers[index]) {
+			if n.RemoveNode(peers[index]) {
 				// If the node was known/saved
 
 				fmt.Println(color.Colorize(color.Red, "[NODE]: Non-responsive node removed"))
@@ -135,6 +269,8 @@ func (n *Node) SendDataToAll(path string, data *bytes.Buffer) {
 
 				fmt.Println(color.Colorize(color.Red, "[NODE]: Tried to contact a non-recognized peer"))
 			}
+
+			continue
 		}
 
 		// If the http response given was good
@@ -0,0 +1,149 @@
+package node
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/mempool"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/wallet"
+)
+
+// Creates a node with a blockchain of the length inputted.
+// Returns the node.
+func newTestNode(length int) *Node {
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = make([]blockchain.Block, length)
+
+	wal := wallet.Init(bc)
+	mem := mempool.Init(&wal)
+
+	return Init(bc, &mem, false, &wal)
+}
+
+func TestHandshake(t *testing.T) {
+
+	nodeA := newTestNode(3)
+	nodeB := newTestNode(8)
+
+	server := httptest.NewServer(nodeB.InitMux())
+	defer server.Close()
+
+	nodeA.Peers = append(nodeA.Peers, server.URL)
+
+	if !nodeA.SendHandshake(server.URL) {
+
+		t.Fatal("Expected the compatible nodes to handshake")
+	}
+
+	if height, _ := nodeA.PeerHeight(server.URL); height != 7 {
+
+		t.Errorf("Expected to learn the peer height of 7, got %d", height)
+	}
+
+	if len(nodeA.Peers) != 1 {
+
+		t.Error("Expected the compatible peer to be kept")
+	}
+}
+
+func TestHandshakeConcurrent(t *testing.T) {
+
+	nodeA := newTestNode(3)
+	urls := []string{}
+
+	for index := 0; index < 4; index += 1 {
+
+		server := httptest.NewServer(newTestNode(5 + index).InitMux())
+		defer server.Close()
+
+		urls = append(urls, server.URL)
+	}
+
+	// Handshake with every peer at once, while the heights are read, which go test -race checks
+	var wg sync.WaitGroup
+
+	for _, url := range urls {
+
+		wg.Add(2)
+
+		go func(url string) {
+
+			defer wg.Done()
+			nodeA.SendHandshake(url)
+		}(url)
+
+		go func(url string) {
+
+			defer wg.Done()
+			nodeA.PeerHeight(url)
+		}(url)
+	}
+
+	wg.Wait()
+
+	for index, url := range urls {
+
+		if height, found := nodeA.PeerHeight(url); !found || height != uint(4+index) {
+
+			t.Errorf("Expected to learn the peer height of %d, got %d (found: %t)", 4+index, height, found)
+		}
+	}
+}
+
+func TestHandshakeIncompatiblePeer(t *testing.T) {
+
+	nodeA := newTestNode(3)
+
+	// A peer that talks a different protocol
+	badShake := Handshake{SoftwareVersion: "v9.9", ProtocolVersion: ProtocolVersion + 1, Height: 100}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Write(badShake.AsBytes())
+	}))
+	defer server.Close()
+
+	nodeA.Peers = append(nodeA.Peers, server.URL)
+
+	if nodeA.SendHandshake(server.URL) {
+
+		t.Fatal("Expected the handshake with an incompatible peer to fail")
+	}
+
+	if len(nodeA.Peers) != 0 {
+
+		t.Error("Expected the incompatible peer to be dropped")
+	}
+
+	if _, found := nodeA.PeerHeight(server.URL); found {
+
+		t.Error("Expected the incompatible peer height to not be saved")
+	}
+}
+
+func TestHandshakeRefusesIncompatibleNode(t *testing.T) {
+
+	nodeB := newTestNode(8)
+
+	server := httptest.NewServer(nodeB.InitMux())
+	defer server.Close()
+
+	badShake := Handshake{SoftwareVersion: "v9.9", ProtocolVersion: ProtocolVersion + 1, Height: 100}
+
+	resp, err := http.Post(server.URL+"/handshake", "data/json", bytes.NewBuffer(badShake.AsBytes()))
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusNotAcceptable {
+
+		t.Errorf("Expected the incompatible node to be refused, got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,216 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/mempool"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/wallet"
+)
+
+// Lets programs that are not written in Go query a running node over HTTP, with every response in JSON.
+// Unlike the node, which talks to its peers, this is meant for users and their tools.
+type Server struct {
+	bc  *blockchain.Blockchain
+	mem *mempool.Mempool
+	wal *wallet.Wallet
+}
+
+// The address the rpc server listens on if none is given.
+// Only reachable from this machine, as the rpc server can queue txs, and on its own port, apart from the p2p ports of the node (8180 and 8181).
+const DefaultAddr = "localhost:8182"
+
+// The response of "/balance".
+type BalanceResponse struct {
+	PubKey  string
+	Balance uint64
+}
+
+// The response of "/height".
+type HeightResponse struct {
+	Height uint
+}
+
+// The response of "/tx", with the hash of the tx that was queued.
+type TxResponse struct {
+	TxId string
+}
+
+// The response of any request that failed, with the reason it failed.
+type ErrorResponse struct {
+	Error string
+}
+
+// The errors a request can fail with, sent back in an ErrorResponse.
+var (
+	ErrBadMethod      = errors.New("method not allowed")
+	ErrBadPubKey      = errors.New("pubkey is not a valid public key")
+	ErrBadBlockNumber = errors.New("n is not a valid block number")
+	ErrUnknownBlock   = errors.New("block is not in the blockchain")
+	ErrBadTx          = errors.New("body is not a valid json tx")
+)
+
+// Inits the rpc Server.
+// Input is the blockchain, mempool and wallet the Server answers queries from.
+// Returns the new Server.
+func Init(bc *blockchain.Blockchain, mem *mempool.Mempool, wal *wallet.Wallet) *Server {
+
+	s := new(Server)
+
+	s.bc = bc
+	s.mem = mem
+	s.wal = wal
+
+	return s
+}
+
+// Initiates the mux for the rpc server.
+// Returns the ServerMux of all of the Handled functions of the Server.
+func (s *Server) InitMux() *http.ServeMux {
+
+	mux := http.NewServeMux()
+
+	// Handled Funcs
+	mux.HandleFunc("/balance", s.Balance)
+	mux.HandleFunc("/height", s.Height)
+	mux.HandleFunc("/block", s.Block)
+	mux.HandleFunc("/tx", s.Tx)
+
+	return mux
+}
+
+// Gets the available balance of a public key, given by the "pubkey" query, ex "/balance?pubkey=04ab...".
+// Responds with a BalanceResponse, or a StatusBadRequest if the public key is not valid.
+// Returns nothing.
+// Accessed by GET "/balance".
+func (s *Server) Balance(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+
+		return
+	}
+
+	pubKey := r.URL.Query().Get("pubkey")
+	pubKeyBytes, err := hex.DecodeString(pubKey)
+
+	if err != nil || !s.bc.Params().SigScheme().ValidPubKey(pubKeyBytes) {
+
+		writeError(w, http.StatusBadRequest, ErrBadPubKey)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BalanceResponse{PubKey: pubKey, Balance: s.wal.ScanChainForBalance(pubKey)})
+}
+
+// Gets the height of the tip of the blockchain.
+// Responds with a HeightResponse.
+// Returns nothing.
+// Accessed by GET "/height".
+func (s *Server) Height(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, HeightResponse{Height: s.bc.GetHeight()})
+}
+
+// Gets a block by its height, given by the "n" query, ex "/block?n=10".
+// Responds with the block, a StatusBadRequest if n is not a number, or a StatusNotFound if there is no block at n.
+// Returns nothing.
+// Accessed by GET "/block".
+func (s *Server) Block(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+
+		return
+	}
+
+	blockNum, err := strconv.ParseUint(r.URL.Query().Get("n"), 10, 0)
+
+	if err != nil {
+
+		writeError(w, http.StatusBadRequest, ErrBadBlockNumber)
+		return
+	}
+
+	block, found := s.bc.GetBlock(uint(blockNum))
+
+	if !found {
+
+		writeError(w, http.StatusNotFound, ErrUnknownBlock)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, block)
+}
+
+// Queues a tx into the mempool, with the body being the tx as JSON.
+// The tx is checked by the mempool (through AddTx) before being queued.
+// Responds with a TxResponse and StatusAccepted if queued, a StatusBadRequest if the body is not a tx,
+// or a StatusNotAcceptable with the reason from the mempool if the tx was rejected.
+// The tx is not sent to the peers of the node, which happens when it is sent to the "/tx" of the node instead.
+// Returns nothing.
+// Accessed by POST "/tx".
+func (s *Server) Tx(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodPost) {
+
+		return
+	}
+
+	tx := new(transactions.LuTx)
+
+	if err := json.NewDecoder(r.Body).Decode(tx); err != nil {
+
+		writeError(w, http.StatusBadRequest, ErrBadTx)
+		return
+	}
+
+	if err := s.mem.AddTx(*tx); err != nil {
+
+		writeError(w, http.StatusNotAcceptable, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, TxResponse{TxId: tx.HashTx()})
+}
+
+// Checks that the request uses the method inputted, responding with a StatusMethodNotAllowed if not.
+// Returns true if the request can be handled, false if it was already responded to.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+
+	if r.Method == method {
+
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, ErrBadMethod)
+
+	return false
+}
+
+// Writes the value inputted as the JSON body of the response, with the status code inputted.
+// Returns nothing.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+
+	body, _ := json.Marshal(v)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// Writes the error inputted as an ErrorResponse, with the status code inputted.
+// Returns nothing.
+func writeError(w http.ResponseWriter, code int, err error) {
+
+	writeJSON(w, code, ErrorResponse{Error: err.Error()})
+}
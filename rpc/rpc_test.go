@@ -0,0 +1,231 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/ellip"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/mempool"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/wallet"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Creates a Server whose blockchain has a mature block reward for a new key.
+// Returns the Server, the key with the block reward and the hex string of its public key.
+func newTestServer(t *testing.T) (*Server, *ecdsa.PrivateKey, string) {
+
+	key, err := crypto.GenerateKey()
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	pubKey := hex.EncodeToString(elliptic.Marshal(crypto.S256(), key.X, key.Y))
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = make([]blockchain.Block, blockchain.RewardMaturity+2)
+	bc.Blocks[0].Miner = pubKey
+
+	wal := wallet.Init(bc)
+	mem := mempool.Init(&wal)
+
+	return Init(bc, &mem, &wal), key, pubKey
+}
+
+// Sends a request to the mux of the Server, and decodes the JSON response into the value inputted.
+// Returns the status code of the response.
+func serve(t *testing.T, s *Server, req *http.Request, v interface{}) int {
+
+	recorder := httptest.NewRecorder()
+	s.InitMux().ServeHTTP(recorder, req)
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+
+		t.Fatalf("%s %s: could not decode the response %q: %v", req.Method, req.URL, recorder.Body.String(), err)
+	}
+
+	return recorder.Code
+}
+
+func TestDefaultAddr(t *testing.T) {
+
+	host, port, err := net.SplitHostPort(DefaultAddr)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	// Only this machine can reach it, and it does not take a p2p port of the node
+	if host != "localhost" || port == "8180" || port == "8181" {
+
+		t.Errorf("Expected a localhost address apart from the p2p ports, got %s", DefaultAddr)
+	}
+}
+
+func TestBalance(t *testing.T) {
+
+	s, _, pubKey := newTestServer(t)
+
+	balance := new(BalanceResponse)
+
+	if code := serve(t, s, httptest.NewRequest(http.MethodGet, "/balance?pubkey="+pubKey, nil), balance); code != http.StatusOK {
+
+		t.Fatalf("Expected %d, got %d", http.StatusOK, code)
+	}
+
+	if balance.PubKey != pubKey || balance.Balance != s.bc.GetBlockReward(0) {
+
+		t.Errorf("Expected the block reward of %d, got %+v", s.bc.GetBlockReward(0), balance)
+	}
+
+	for _, badKey := range []string{"", "zz", "04aabb", pubKey[:len(pubKey)-2]} {
+
+		errResp := new(ErrorResponse)
+
+		if code := serve(t, s, httptest.NewRequest(http.MethodGet, "/balance?pubkey="+badKey, nil), errResp); code != http.StatusBadRequest {
+
+			t.Errorf("%q: expected %d, got %d", badKey, http.StatusBadRequest, code)
+		}
+
+		if errResp.Error != ErrBadPubKey.Error() {
+
+			t.Errorf("%q: expected the error %q, got %q", badKey, ErrBadPubKey, errResp.Error)
+		}
+	}
+}
+
+func TestHeight(t *testing.T) {
+
+	s, _, _ := newTestServer(t)
+
+	height := new(HeightResponse)
+
+	if code := serve(t, s, httptest.NewRequest(http.MethodGet, "/height", nil), height); code != http.StatusOK {
+
+		t.Fatalf("Expected %d, got %d", http.StatusOK, code)
+	}
+
+	if height.Height != s.bc.GetHeight() {
+
+		t.Errorf("Expected a height of %d, got %d", s.bc.GetHeight(), height.Height)
+	}
+
+	// Only GET is allowed
+	if code := serve(t, s, httptest.NewRequest(http.MethodPost, "/height", nil), new(ErrorResponse)); code != http.StatusMethodNotAllowed {
+
+		t.Errorf("Expected %d, got %d", http.StatusMethodNotAllowed, code)
+	}
+}
+
+func TestBlock(t *testing.T) {
+
+	s, _, pubKey := newTestServer(t)
+
+	block := new(blockchain.Block)
+
+	if code := serve(t, s, httptest.NewRequest(http.MethodGet, "/block?n=0", nil), block); code != http.StatusOK {
+
+		t.Fatalf("Expected %d, got %d", http.StatusOK, code)
+	}
+
+	if block.Miner != pubKey {
+
+		t.Errorf("Expected the genisis block, got %+v", block)
+	}
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"", http.StatusBadRequest},
+		{"n=abc", http.StatusBadRequest},
+		{"n=-1", http.StatusBadRequest},
+		{"n=12", http.StatusNotFound},
+		{"n=99999", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+
+		if code := serve(t, s, httptest.NewRequest(http.MethodGet, "/block?"+test.query, nil), new(ErrorResponse)); code != test.expected {
+
+			t.Errorf("%q: expected %d, got %d", test.query, test.expected, code)
+		}
+	}
+}
+
+func TestTx(t *testing.T) {
+
+	s, key, pubKey := newTestServer(t)
+
+	tx := transactions.LuTx{TxFrom: pubKey, TxTo: "kaimorton123", Value: 2000, Fee: 5000}
+	tx.Signature = hex.EncodeToString(ellip.SignHash(key, tx.SigHash()))
+
+	txBytes, _ := json.Marshal(tx)
+	txResp := new(TxResponse)
+
+	if code := serve(t, s, httptest.NewRequest(http.MethodPost, "/tx", bytes.NewReader(txBytes)), txResp); code != http.StatusAccepted {
+
+		t.Fatalf("Expected %d, got %d", http.StatusAccepted, code)
+	}
+
+	if txResp.TxId != tx.HashTx() || len(s.mem.Txs) != 1 || s.mem.Txs[0].HashTx() != tx.HashTx() {
+
+		t.Errorf("Expected the tx %s to be queued, got %+v", tx.HashTx(), txResp)
+	}
+
+	// A tx that is not signed by the sender
+	unsigned := tx
+	unsigned.Value += 1
+	unsignedBytes, _ := json.Marshal(unsigned)
+
+	tests := []struct {
+		method   string
+		body     string
+		expected int
+	}{
+		{http.MethodPost, "{not json", http.StatusBadRequest},
+		{http.MethodPost, string(unsignedBytes), http.StatusNotAcceptable},
+		{http.MethodGet, string(txBytes), http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+
+		errResp := new(ErrorResponse)
+
+		if code := serve(t, s, httptest.NewRequest(test.method, "/tx", strings.NewReader(test.body)), errResp); code != test.expected {
+
+			t.Errorf("%s %q: expected %d, got %d", test.method, test.body, test.expected, code)
+		}
+
+		if errResp.Error == "" {
+
+			t.Errorf("%s %q: expected the reason the tx was rejected", test.method, test.body)
+		}
+	}
+
+	// The reason comes from the mempool
+	errResp := new(ErrorResponse)
+	serve(t, s, httptest.NewRequest(http.MethodPost, "/tx", bytes.NewReader(unsignedBytes)), errResp)
+
+	if errResp.Error != mempool.ErrBadSignature.Error() {
+
+		t.Errorf("Expected the error %q, got %q", mempool.ErrBadSignature, errResp.Error)
+	}
+
+	if len(s.mem.Txs) != 1 {
+
+		t.Errorf("Expected only the valid tx to be queued, got %d txs", len(s.mem.Txs))
+	}
+}
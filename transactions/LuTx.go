@@ -35,6 +35,7 @@ func (l *LuTx) AddScriptStr(scriptstr string) {
 }
 
 // Function converts the tx into bytes.
+// The bytes are the JSON of the tx, with the fields always in the same order, so the same tx always gives the same bytes.
 // Returns the byte array of the tx.
 func (l *LuTx) AsBytes() []byte {
 
@@ -58,16 +59,26 @@ func (l *LuTx) HashTx() string {
 	return hex.EncodeToString(hash)
 }
 
-// This function gets the weight of the transaction.
-// Returns the weight in a uint32.
-func (l *LuTx) GetWeight() uint {
+// This function calculates the hash that the signature of the transaction signs.
+// It is the shake256 hash of the transaction bytes, with the signature left blank.
+// Both signing and verifying a transaction must use this hash.
+// Returns the 32 byte hash.
+func (l *LuTx) SigHash() []byte {
 
-	lAsBytes, jsonErr := json.Marshal(l)
+	// Blank the signature on a copy, so the tx itself keeps its signature
+	txCopy := *l
+	txCopy.Signature = ""
 
-	if jsonErr != nil {
+	hash := make([]byte, 32)
+	sha3.ShakeSum256(hash, txCopy.AsBytes())
 
-		panic(jsonErr)
-	}
+	return hash
+}
+
+// This function gets the weight of the transaction.
+// The weight is the size of the serialized tx, every field with its name, so it always matches the length of AsBytes.
+// Returns the weight in a uint32.
+func (l *LuTx) GetWeight() uint {
 
-	return uint(len(lAsBytes))
+	return uint(len(l.AsBytes()))
 }
@@ -0,0 +1,72 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLuTxRoundTrip(t *testing.T) {
+
+	txs := []LuTx{
+		{},
+		{TxFrom: "04aabb", TxTo: "04ccdd", Value: 2000, Nonce: 3, Fee: 1000, Signature: "eeff"},
+		{TxFrom: "04aabb", TxTo: "04ccdd", Value: 1, Script: "TXID 123", Nonce: 4294967295, Fee: 18446744073709551615},
+	}
+
+	for _, tx := range txs {
+
+		txBytes := tx.AsBytes()
+
+		decoded := LuTx{}
+
+		if err := json.Unmarshal(txBytes, &decoded); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(decoded, tx) {
+
+			t.Errorf("Expected %+v, got %+v", tx, decoded)
+		}
+
+		if !bytes.Equal(decoded.AsBytes(), txBytes) {
+
+			t.Errorf("Expected the same tx to always give the same bytes, got %s and %s", txBytes, decoded.AsBytes())
+		}
+
+		if tx.GetWeight() != uint(len(txBytes)) {
+
+			t.Errorf("Expected a weight of %d, the length of the tx bytes, got %d", len(txBytes), tx.GetWeight())
+		}
+	}
+}
+
+func TestAddScriptStr(t *testing.T) {
+
+	defer func(valuePair, noPair []string) {
+		keyWordsValuePair, keyWordsNoPair = valuePair, noPair
+	}(keyWordsValuePair, keyWordsNoPair)
+
+	keyWordsValuePair = []string{"TXID"}
+	keyWordsNoPair = []string{"SELF"}
+
+	tx := LuTx{TxFrom: "04aabb", TxTo: "04ccdd", Value: 2000}
+
+	// Junk is removed, and the script is kept on the tx itself
+	tx.AddScriptStr("TXID 123 junk SELF")
+
+	if tx.Script != "TXID 123 SELF " {
+
+		t.Fatalf("Expected the script to be set on the tx, got %q", tx.Script)
+	}
+
+	// A blank script leaves the script as it was
+	tx.AddScriptStr("")
+
+	if tx.Script != "TXID 123 SELF " {
+
+		t.Errorf("Expected a blank script to change nothing, got %q", tx.Script)
+	}
+}
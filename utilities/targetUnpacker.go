@@ -1,6 +1,9 @@
 package utilities
 
 import (
+	"fmt"
+	"math/big"
+
 	"github.com/GoblinBear/beson/types"
 )
 
@@ -25,6 +28,7 @@ type TargetUnpacker struct {
 }
 
 // Unpacks the packed target input value.
+// Targets that were unpacked recently are taken from a cache, rather than being unpacked again.
 // Returns a type uint256.
 func (t *TargetUnpacker) Unpack(packedTarget uint32) types.UInt256 {
 
@@ -37,6 +41,16 @@ func (t *TargetUnpacker) Unpack(packedTarget uint32) types.UInt256 {
 	// Stores the first byte out of it (as this byte dictates the amount of bits the sig figs are from lowest value)
 	t.exponent = t.packedTarget >> (3 * 8) // Gets the first byte out of it (big endian style)
 
+	// If the target was already unpacked
+	if target, found := targetCache.get(packedTarget); found {
+
+		t.unpackedTarget.Set(target)
+
+		return t.unpackedTarget
+	}
+
+	countUnpack()
+
 	// Sets the non shifted value of the target
 	t.unpackedTarget.Set(t.util.Uint32toB(t.packedTarget & 0x00ffffff)) // Masks the first byte and inputs the rest into the uint235
 
@@ -46,32 +60,75 @@ func (t *TargetUnpacker) Unpack(packedTarget uint32) types.UInt256 {
 	// Shifts the value based on the exponent
 	t.unpackedTarget = t.lShift(uint(8 * (t.exponent - 3)))
 
+	targetCache.add(packedTarget, t.unpackedTarget.Get())
+
 	return t.unpackedTarget
 }
 
+// The error of a packed target that does not unpack to a valid 256 bit target.
+type MalformedTargetError struct {
+	PackedTarget uint32
+	Reason       string
+}
+
+// Returns the error as a string, with the packed target.
+func (e *MalformedTargetError) Error() string {
+
+	return fmt.Sprintf("malformed packed target %08x: %s", e.PackedTarget, e.Reason)
+}
+
+// Unpacks the packed target input value, after checking it is a valid target.
+// Used for targets from peers, as Unpack gives a wrong target for a malformed one instead of failing.
+// Returns a type uint256, or a *MalformedTargetError if the target is malformed.
+func (t *TargetUnpacker) UnpackChecked(packedTarget uint32) (types.UInt256, error) {
+
+	exponent := packedTarget >> (3 * 8)
+	mantissa := packedTarget & 0x00ffffff
+
+	// The exponent has to shift the 3 bytes of the mantissa left, and keep them within the 32 bytes of the target
+	if exponent < 3 {
+
+		return types.UInt256{}, &MalformedTargetError{PackedTarget: packedTarget, Reason: "exponent is under 3"}
+	}
+
+	if exponent > 32 {
+
+		return types.UInt256{}, &MalformedTargetError{PackedTarget: packedTarget, Reason: "mantissa overflows 256 bits"}
+	}
+
+	// A target of zero can never be met
+	if mantissa == 0 {
+
+		return types.UInt256{}, &MalformedTargetError{PackedTarget: packedTarget, Reason: "mantissa is zero"}
+	}
+
+	return t.Unpack(packedTarget), nil
+}
+
 // Unpacks the packed target input value.
 // Returns a byte array (256 bits long).
 func (t *TargetUnpacker) UnpackAsBytes(packedTarget uint32) []byte {
 
-	// Init the t.unpackedTarget (as without the uint256 is blank rather than being 0's)
-	t.unpackedTarget = *types.NewUInt256("0", 1)
+	unpackedTarget := t.Unpack(packedTarget)
 
-	// Store the value in the struct for later use / organization
-	t.packedTarget = packedTarget
+	return unpackedTarget.Get()
+}
 
-	// Stores the first byte out of it (as this byte dictates the amount of bits the sig figs are from lowest value)
-	t.exponent = t.packedTarget >> (3 * 8) // Gets the first byte out of it (big endian style)
+// Calculates the expected amount of hashes it takes to find a block hash that meets a target.
+// This is 2^256 / (target+1), as every hash has a (target+1) / 2^256 chance of meeting the target.
+// Returns the expected amount of hashes.
+func ExpectedHashes(packedTarget uint32) float64 {
 
-	// Sets the non shifted value of the target
-	t.unpackedTarget.Set(t.util.Uint32toB(t.packedTarget & 0x00ffffff)) // Masks the first byte and inputs the rest into the uint235
+	unpacker := new(TargetUnpacker)
 
-	// Swaps from little endian to big endian order
-	t.byteSwap()
+	target := new(big.Int).SetBytes(unpacker.UnpackAsBytes(packedTarget))
+	target.Add(target, big.NewInt(1))
 
-	// Shifts the value based on the exponent
-	t.unpackedTarget = t.lShift(uint(8 * (t.exponent - 3)))
+	hashSpace := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	hashes, _ := new(big.Float).Quo(new(big.Float).SetInt(hashSpace), new(big.Float).SetInt(target)).Float64()
 
-	return t.unpackedTarget.Get()
+	return hashes
 }
 
 // Function bitshifts left and returns the value for better looking code above.
@@ -0,0 +1,65 @@
+package utilities
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestUnpackChecked(t *testing.T) {
+
+	unpacker := new(TargetUnpacker)
+
+	target, err := unpacker.UnpackChecked(0x1d0fffff)
+
+	if err != nil {
+
+		t.Fatalf("Expected a valid target, got %v", err)
+	}
+
+	if expected := unpacker.UnpackAsBytes(0x1d0fffff); !bytes.Equal(target.Get(), expected) {
+
+		t.Errorf("Expected the checked target %x to match %x", target.Get(), expected)
+	}
+
+	for _, packedTarget := range []uint32{0x02ffffff, 0x00000001, 0x21000001, 0xff0fffff, 0x1d000000} {
+
+		_, err := unpacker.UnpackChecked(packedTarget)
+
+		var malformedErr *MalformedTargetError
+
+		if !errors.As(err, &malformedErr) || malformedErr.PackedTarget != packedTarget {
+
+			t.Errorf("Expected %08x to be malformed, got %v", packedTarget, err)
+		}
+	}
+}
+
+func TestExpectedHashes(t *testing.T) {
+
+	tests := []struct {
+		packedTarget uint32
+		expected     float64
+	}{
+		// Almost every hash meets the largest target
+		{0x20ffffff, 1},
+		// 0xffff * 2^(8*28), so about 2^256 / 2^240
+		{0x1f00ffff, 1 << 16},
+		// 0x0fffff * 2^(8*26), so about 2^256 / 2^228
+		{0x1d0fffff, 1 << 28},
+		// The easy test target, 16 times easier
+		{0x1f0fffff, 1 << 12},
+	}
+
+	for _, test := range tests {
+
+		hashes := ExpectedHashes(test.packedTarget)
+
+		// Within 0.01%, as the mantissas are one under a power of 2
+		if math.Abs(hashes-test.expected)/test.expected > 0.0001 {
+
+			t.Errorf("Target %08x: expected about %f hashes, got %f", test.packedTarget, test.expected, hashes)
+		}
+	}
+}
@@ -4,6 +4,13 @@ import (
 	"time"
 )
 
+// Something that tells the current time, so code checking times can be tested with a clock that does not move.
+type TimeSource interface {
+
+	// The current unix time in seconds
+	Now() uint64
+}
+
 type Time struct {
 	timerLog   uint64
 	timerValue uint64
@@ -15,6 +22,12 @@ func (t *Time) CurrentUnix() uint64 {
 	return uint64(time.Now().Unix())
 }
 
+// Function returns the current unix time in seconds, so Time is a TimeSource of the real clock.
+func (t *Time) Now() uint64 {
+
+	return t.CurrentUnix()
+}
+
 // Function returns the current unix time in milli-seconds.
 func (t *Time) CurrentUnixMilli() uint64 {
 
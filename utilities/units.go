@@ -0,0 +1,36 @@
+package utilities
+
+import (
+	"math"
+)
+
+// The amount of LUNCHEON (the smallest unit) in one LNCH.
+const LuncheonPerLNCH = 1000000
+
+// Converts an amount of LNCH into LUNCHEON, rounding to the nearest LUNCHEON.
+// Negative amounts (and NaN) are 0, and amounts too big for a uint64 are the max uint64.
+// Returns the amount in LUNCHEON.
+func ToLuncheon(lnch float64) uint64 {
+
+	luncheon := math.Round(lnch * LuncheonPerLNCH)
+
+	if !(luncheon > 0) {
+
+		return 0
+	}
+
+	// Past the largest float64 below 2^64
+	if luncheon >= math.MaxUint64 {
+
+		return math.MaxUint64
+	}
+
+	return uint64(luncheon)
+}
+
+// Converts an amount of LUNCHEON into LNCH.
+// Returns the amount in LNCH.
+func ToLNCH(luncheon uint64) float64 {
+
+	return float64(luncheon) / LuncheonPerLNCH
+}
@@ -0,0 +1,51 @@
+package utilities
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToLuncheon(t *testing.T) {
+
+	tests := []struct {
+		lnch     float64
+		expected uint64
+	}{
+		{200, 200000000},
+		{1, LuncheonPerLNCH},
+		{0.000001, 1},
+		{12.5, 12500000},
+		// Rounded to the nearest LUNCHEON
+		{0.0000004, 0},
+		{0.0000006, 1},
+		{0, 0},
+		{-5, 0},
+		{math.NaN(), 0},
+		{math.Inf(1), math.MaxUint64},
+		{1e20, math.MaxUint64},
+	}
+
+	for _, test := range tests {
+
+		if luncheon := ToLuncheon(test.lnch); luncheon != test.expected {
+
+			t.Errorf("%v LNCH: expected %d LUNCHEON, got %d", test.lnch, test.expected, luncheon)
+		}
+	}
+}
+
+func TestLNCHRoundTrip(t *testing.T) {
+
+	for _, luncheon := range []uint64{0, 1, 999999, 1000000, 200000000, 208597500 * LuncheonPerLNCH} {
+
+		if roundTrip := ToLuncheon(ToLNCH(luncheon)); roundTrip != luncheon {
+
+			t.Errorf("Expected %d LUNCHEON to round trip, got %d", luncheon, roundTrip)
+		}
+	}
+
+	if lnch := ToLNCH(1500000); lnch != 1.5 {
+
+		t.Errorf("Expected 1.5 LNCH, got %f", lnch)
+	}
+}
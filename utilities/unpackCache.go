@@ -0,0 +1,116 @@
+package utilities
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// The amount of unpacked targets kept in the cache by default.
+// A blockchain only has a new target once every retarget, so this covers a long history of targets.
+const DefaultUnpackCacheSize = 64
+
+// A least recently used cache of packed targets to their unpacked bytes, shared by every TargetUnpacker.
+// Safe to use from many go-routines, like the miner and the wallet at the same time.
+type unpackCache struct {
+	mutex sync.Mutex
+
+	size    int
+	order   *list.List // The packed targets, from the most recently used to the least
+	entries map[uint32]*list.Element
+}
+
+// An unpacked target in the cache.
+type unpackCacheEntry struct {
+	packedTarget uint32
+	target       []byte
+}
+
+// The cache used by TargetUnpacker.
+var targetCache = newUnpackCache(DefaultUnpackCacheSize)
+
+// The amount of targets that had to be unpacked because they were not in the cache.
+var unpackComputations uint64
+
+// Creates an empty cache holding up to the amount of targets inputted.
+// Returns the cache.
+func newUnpackCache(size int) *unpackCache {
+
+	c := new(unpackCache)
+
+	c.size = size
+	c.order = list.New()
+	c.entries = map[uint32]*list.Element{}
+
+	return c
+}
+
+// Sets the amount of unpacked targets the cache holds, emptying it.
+// A size of 0 or less turns the cache off.
+// Returns nothing.
+func SetUnpackCacheSize(size int) {
+
+	targetCache.mutex.Lock()
+	defer targetCache.mutex.Unlock()
+
+	targetCache.size = size
+	targetCache.order.Init()
+	targetCache.entries = map[uint32]*list.Element{}
+}
+
+// Gets the unpacked bytes of a packed target, and marks it as the most recently used.
+// Returns a copy of the bytes, and false if the target is not in the cache.
+func (c *unpackCache) get(packedTarget uint32) ([]byte, bool) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, found := c.entries[packedTarget]
+
+	if !found {
+
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+
+	return append([]byte{}, element.Value.(*unpackCacheEntry).target...), true
+}
+
+// Adds the unpacked bytes of a packed target, evicting the least recently used target if the cache is full.
+// Returns nothing.
+func (c *unpackCache) add(packedTarget uint32, target []byte) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.size <= 0 {
+
+		return
+	}
+
+	if element, found := c.entries[packedTarget]; found {
+
+		c.order.MoveToFront(element)
+
+		return
+	}
+
+	entry := &unpackCacheEntry{packedTarget: packedTarget, target: append([]byte{}, target...)}
+	c.entries[packedTarget] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+
+		oldest := c.order.Back()
+
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*unpackCacheEntry).packedTarget)
+	}
+}
+
+// Counts a target being unpacked without the cache.
+// Returns nothing.
+func countUnpack() {
+
+	atomic.AddUint64(&unpackComputations, 1)
+}
@@ -0,0 +1,106 @@
+package utilities
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUnpackCache(t *testing.T) {
+
+	defer SetUnpackCacheSize(DefaultUnpackCacheSize)
+	SetUnpackCacheSize(2)
+
+	unpacker := new(TargetUnpacker)
+
+	// The bytes unpacked without the cache
+	expected := map[uint32][]byte{}
+
+	for _, packedTarget := range []uint32{0x1d0fffff, 0x1c0fffff, 0x1b0fffff} {
+
+		expected[packedTarget] = unpacker.UnpackAsBytes(packedTarget)
+	}
+
+	// 0x1d0fffff was evicted when 0x1b0fffff was added
+	before := atomic.LoadUint64(&unpackComputations)
+
+	for _, packedTarget := range []uint32{0x1c0fffff, 0x1b0fffff, 0x1c0fffff, 0x1d0fffff} {
+
+		if target := unpacker.UnpackAsBytes(packedTarget); !bytes.Equal(target, expected[packedTarget]) {
+
+			t.Errorf("Target %08x: expected %x, got %x", packedTarget, expected[packedTarget], target)
+		}
+	}
+
+	if computations := atomic.LoadUint64(&unpackComputations) - before; computations != 1 {
+
+		t.Errorf("Expected only the evicted target to be unpacked again, got %d unpacks", computations)
+	}
+
+	// The cached bytes can not be changed through a returned target
+	target := unpacker.UnpackAsBytes(0x1c0fffff)
+	target[0] = 0xff
+
+	if !bytes.Equal(unpacker.UnpackAsBytes(0x1c0fffff), expected[0x1c0fffff]) {
+
+		t.Error("Expected the cached target to not change")
+	}
+}
+
+func TestUnpackCacheConcurrent(t *testing.T) {
+
+	var wait sync.WaitGroup
+
+	for routine := 0; routine < 8; routine += 1 {
+
+		wait.Add(1)
+
+		go func(routine int) {
+
+			defer wait.Done()
+
+			unpacker := new(TargetUnpacker)
+
+			for index := 0; index < 1000; index += 1 {
+
+				unpacker.UnpackAsBytes(0x1d0fffff - uint32((routine+index)%100))
+			}
+		}(routine)
+	}
+
+	wait.Wait()
+}
+
+// Unpacks the few targets of a blockchain over and over, like validating a long blockchain does.
+// Reports the amount of targets actually unpacked for each run.
+func benchmarkUnpackRepetitive(b *testing.B, cacheSize int) {
+
+	defer SetUnpackCacheSize(DefaultUnpackCacheSize)
+	SetUnpackCacheSize(cacheSize)
+
+	unpacker := new(TargetUnpacker)
+	packedTargets := []uint32{0x1d0fffff, 0x1d07ffff, 0x1c3fffff, 0x1c1fffff}
+
+	before := atomic.LoadUint64(&unpackComputations)
+
+	for index := 0; index < b.N; index += 1 {
+
+		for targetIndex := 0; targetIndex < 100; targetIndex += 1 {
+
+			unpacker.UnpackAsBytes(packedTargets[targetIndex%len(packedTargets)])
+		}
+	}
+
+	b.ReportMetric(float64(atomic.LoadUint64(&unpackComputations)-before)/float64(b.N), "unpacks/op")
+}
+
+func BenchmarkUnpackCached(b *testing.B) {
+
+	benchmarkUnpackRepetitive(b, DefaultUnpackCacheSize)
+}
+
+func BenchmarkUnpackUncached(b *testing.B) {
+
+	benchmarkUnpackRepetitive(b, 0)
+}
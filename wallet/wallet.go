@@ -1,22 +1,139 @@
 package wallet
 
 import (
+	"bytes"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/ellip"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
 	"github.com/Sucks-To-Suck/LuncheonNetwork/utilities"
-	"golang.org/x/crypto/sha3"
 )
 
 type Wallet struct {
 	chain   *blockchain.Blockchain
 	mainKey ellip.MainKey
+
+	// The key pair the wallet sends txs from, set by SetKey, for networks that do not use the secp256k1 main key
+	privKey []byte
+	pubKey  []byte
+
+	// The txs whose signatures have already been proven valid
+	sigCache *signatureCache
+
+	// The fee CreateTx pays for each weight of a tx, in LUNCHEON
+	FeePerWeight uint64
+
+	// The clock blocks are checked against, so a block can not be from the future
+	Clock utilities.TimeSource
+
+	// How far VerifyBlockchain got, so the blocks it already verified are not verified again
+	verified *verifyMarker
+
+	// The balances found since the blockchain last changed
+	balances *balanceCache
+
+	// The blocks removed from the blockchain, for ReconcileAfterReorg
+	orphans *orphanStore
+
+	// The funcs that unregister the hooks the wallet added to its blockchain
+	unhooks []func()
+}
+
+// The balances ScanChainForBalance has found, which are forgotten whenever the blockchain changes.
+// Shared by every copy of the wallet, like the sigCache.
+type balanceCache struct {
+	mutex    sync.Mutex
+	balances map[string]uint64
+
+	// The amount of blocks and the hash of the tip the balances were found at,
+	// so blocks changed without AddBlock or RemoveBlock (which call no hooks) are noticed too
+	blocks  uint
+	tipHash string
+}
+
+// The key of a tx in the sigCache, as a signature is only valid under the signature scheme it was checked with.
+type sigCacheKey struct {
+	scheme string
+	txid   string
+}
+
+// The most signatures the sigCache remembers, so it can not grow without bound.
+var MaxSigCacheSize = 100000
+
+// The txs whose signatures have already been proven valid, which forgets the oldest once there are MaxSigCacheSize.
+// Shared by every copy of the wallet.
+type signatureCache struct {
+	mutex sync.Mutex
+	valid map[sigCacheKey]bool
+
+	// The keys of the valid signatures, oldest first
+	order []sigCacheKey
+}
+
+// Checks if a signature was already proven valid.
+// Returns true if it is in the cache.
+func (c *signatureCache) has(key sigCacheKey) bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.valid[key]
+}
+
+// Remembers a valid signature, forgetting the oldest if there are too many.
+// Returns nothing.
+func (c *signatureCache) add(key sigCacheKey) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.valid[key] {
+
+		return
+	}
+
+	c.valid[key] = true
+	c.order = append(c.order, key)
+
+	for len(c.order) > MaxSigCacheSize {
+
+		delete(c.valid, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// Forgets every signature.
+// Returns nothing.
+func (c *signatureCache) clear() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.valid = map[sigCacheKey]bool{}
+	c.order = nil
 }
 
+// The blocks at the bottom of the blockchain that were already verified by VerifyBlockchain.
+// Shared by every copy of the wallet, like the sigCache.
+type verifyMarker struct {
+	mutex sync.Mutex
+
+	// The amount of blocks verified (the lastVerifiedHeight + 1), and the hash of the last one
+	blocks  uint
+	tipHash string
+
+	// The network the blocks were verified under, as the blocks of one network are not valid on another
+	network string
+}
+
+// The weight a signature adds to a tx, as txs are weighed before they are signed
+const SignatureOverhead = 64
+
 // Initialize a wallet by calling this function.
 // Input is the blockchain the wallet is on.
 // Returns a new wallet.
@@ -25,87 +142,369 @@ func Init(b *blockchain.Blockchain) Wallet {
 	w := new(Wallet)
 
 	w.chain = b
+	w.sigCache = &signatureCache{valid: map[sigCacheKey]bool{}}
+	w.FeePerWeight = 100
+	w.Clock = new(utilities.Time)
+	w.verified = new(verifyMarker)
+	w.balances = new(balanceCache)
+	w.orphans = &orphanStore{blocks: map[string]blockchain.Block{}, heights: map[string]uint{}}
+
+	// Blocks removed in a reorg have to be verified again if they come back, and so do the blocks replacing them
+	if b != nil {
+
+		marker := w.verified
+		cache := w.balances
+		orphans := w.orphans
+		sigs := w.sigCache
+
+		// Every new or removed block can change any balance
+		unhookConnect := b.OnConnect(func(block blockchain.Block, height uint) { cache.clear() })
+
+		unhookDisconnect := b.OnDisconnect(func(block blockchain.Block, height uint) {
+
+			cache.clear()
+			orphans.add(block, height)
+
+			// The txs of removed blocks go back to the pool, or are dropped, so their signatures are forgotten with the block
+			sigs.clear()
+
+			marker.mutex.Lock()
+			defer marker.mutex.Unlock()
+
+			if marker.blocks > height {
+
+				marker.blocks = height
+				marker.tipHash = block.PrevHash
+			}
+		})
+
+		w.unhooks = []func(){unhookConnect, unhookDisconnect}
+	}
 
 	return *w
 }
 
-// Scans the blockchain for the available balance of a publicKey.
+// Unregisters the hooks Init added to the blockchain, so a wallet that is no longer used is not kept alive by them.
+// Closing one copy of the wallet closes them all, as they share the hooks, and closing it again does nothing.
+// After it is closed, blocks removed from the blockchain no longer make VerifyBlockchain verify them again,
+// so the wallet should not be used anymore.
+// Returns nothing.
+func (w *Wallet) Close() {
+
+	for index := 0; index < len(w.unhooks); index += 1 {
+
+		w.unhooks[index]()
+	}
+}
+
+// Gets the params of the blockchain the wallet is on.
+// Returns a copy of the params.
+func (w *Wallet) ChainParams() blockchain.ChainParams {
+
+	return w.chain.Params()
+}
+
+// Gets the available balance of a publicKey from the ledger of the blockchain.
+// Block rewards only count once they are past RewardMaturity (the miner has to wait before it can be spent).
+// The balance is cached until the blockchain changes.
 // Returns the balance of the publicKey.
 func (w *Wallet) ScanChainForBalance(pubKey string) (balance uint64) {
 
-	// Scans the blockchain, starting from the newest block to the first
+	// A wallet not made with Init has no cache
+	if w.balances == nil {
+
+		return w.chain.IndexedBalance(pubKey)
+	}
+
+	w.balances.mutex.Lock()
+	defer w.balances.mutex.Unlock()
+
+	tipHash := ""
+
+	if tip, found := w.chain.Tip(); found {
+
+		tipHash = tip.BlockHash
+	}
+
+	// If the blockchain changed without calling the hooks
+	if w.balances.blocks != w.chain.Len() || w.balances.tipHash != tipHash {
+
+		w.balances.balances = nil
+	}
+
+	if balance, found := w.balances.balances[pubKey]; found {
+
+		return balance
+	}
+
+	if w.balances.balances == nil {
+
+		w.balances.balances = make(map[string]uint64)
+		w.balances.blocks = w.chain.Len()
+		w.balances.tipHash = tipHash
+	}
+
+	balance = w.chain.IndexedBalance(pubKey)
+	w.balances.balances[pubKey] = balance
+
+	return balance
+}
+
+// Forgets the cached balances, so they are found from the blockchain again.
+// Called whenever a block is added to or removed from the blockchain, so the cached balances never go stale.
+// Returns nothing.
+func (w *Wallet) RefreshCache() {
+
+	if w.balances != nil {
+
+		w.balances.clear()
+	}
+}
+
+// Forgets every cached balance.
+// Returns nothing.
+func (c *balanceCache) clear() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.balances = nil
+}
+
+// Gets the available balances of many publicKeys, like every address of a wallet.
+// Each balance is counted the same way as ScanChainForBalance.
+// Returns a map of each publicKey to its balance, including the keys with no balance.
+func (w *Wallet) BalancesOf(pubKeys []string) map[string]uint64 {
+
+	balances := make(map[string]uint64, len(pubKeys))
+
+	for index := 0; index < len(pubKeys); index += 1 {
+
+		balances[pubKeys[index]] = w.ScanChainForBalance(pubKeys[index])
+	}
+
+	return balances
+}
+
+// Scans the blockchain for the block rewards of a publicKey that can not be spent yet.
+// These are the rewards that ScanChainForBalance leaves out, as their blocks are still within the maturity window.
+// Returns the immature balance of the publicKey.
+func (w *Wallet) ImmatureBalance(pubKey string) (balance uint64) {
+
+	// Scans the blockchain, starting from the first block to the newest (only the miner is read, which pruning keeps)
 	for index := 0; index < len(w.chain.Blocks); index += 1 {
 
-		// Check if they got the block reward (+10 makes the miner wait at least 10 blocks before it can be spent)
-		if w.chain.Blocks[index].Miner == pubKey && (index+10) < int(w.chain.GetHeight()) {
+		if w.chain.Blocks[index].Miner == pubKey && (uint(index)+blockchain.RewardMaturity) >= w.chain.GetHeight() {
 
 			balance += w.chain.GetBlockReward(uint32(index))
 		}
+	}
 
-		// Check each tx in the block
-		for txIndex := 0; txIndex < len(w.chain.Blocks[index].Txs); txIndex += 1 {
+	return balance
+}
 
-			if w.chain.Blocks[index].Txs[txIndex].TxTo == pubKey {
+// The balance of a publicKey, split into where its coins are.
+// Confirmed is what ScanChainForBalance counts, Immature is the block rewards that can not be spent yet,
+// PendingIn and PendingOut are what the txs waiting to be mined would add and take away (PendingOut includes the fees),
+// and Spendable is what can be sent now, which is Confirmed minus PendingOut.
+type BalanceBreakdown struct {
+	Confirmed  uint64
+	Immature   uint64
+	PendingIn  uint64
+	PendingOut uint64
+	Spendable  uint64
+}
 
-				balance += w.chain.Blocks[index].Txs[txIndex].Value
-			}
+// Somewhere txs wait to be mined, that can list the waiting txs, like the mempool.
+type PendingPool interface {
+	Pending() []transactions.LuTx
+}
+
+// Gets every part of the balance of a publicKey at once, for showing in a wallet.
+// Input is the publicKey, and the pool of txs waiting to be mined.
+// Returns the balance breakdown.
+func (w *Wallet) FullBalance(pubKey string, pool PendingPool) BalanceBreakdown {
+
+	balance := BalanceBreakdown{
+		Confirmed: w.ScanChainForBalance(pubKey),
+		Immature:  w.ImmatureBalance(pubKey),
+	}
+
+	pending := pool.Pending()
+
+	for index := 0; index < len(pending); index += 1 {
+
+		if pending[index].TxTo == pubKey {
+
+			balance.PendingIn += pending[index].Value
+		}
+
+		if pending[index].TxFrom == pubKey {
+
+			balance.PendingOut += pending[index].Value + pending[index].Fee
 		}
 	}
 
+	// The pending txs could spend more than is confirmed, if the balance changed since they were made
+	if balance.PendingOut < balance.Confirmed {
+
+		balance.Spendable = balance.Confirmed - balance.PendingOut
+	}
+
 	return balance
 }
 
-// Scans the blockchain for the available balance of a publicKey.
-// Returns the balance of the publicKey.
-func (w *Wallet) ScanChainForNonce(pubKey string) (nonce uint32) {
+// The reward of a single block mined by a publicKey.
+type RewardInfo struct {
+	Height         uint
+	Reward         uint64
+	MaturityHeight uint
+	Mature         bool
+}
 
-	// Scans the blockchain, starting from the newest block to the first
-	for index := 0; index < len(w.chain.Blocks); index += 1 {
+// Scans the blockchain for every block reward of a publicKey.
+// MaturityHeight is the first blockchain height where the reward can be spent.
+// Only the miner of each block is read, which is kept in memory when the body of a block is pruned.
+// Returns the rewards, from the oldest block to the newest.
+func (w *Wallet) UnspentRewards(pubKey string) []RewardInfo {
 
-		// Check each tx in the block
-		for txIndex := 0; txIndex < len(w.chain.Blocks[index].Txs); txIndex += 1 {
+	rewards := []RewardInfo{}
 
-			if w.chain.Blocks[index].Txs[txIndex].TxFrom == pubKey {
+	for index := 0; index < len(w.chain.Blocks); index += 1 {
 
-				nonce += 1
-			}
+		if w.chain.Blocks[index].Miner != pubKey {
+
+			continue
 		}
+
+		// The reward can be spent once the blockchain is past the maturity window
+		maturityHeight := uint(index) + blockchain.RewardMaturity + 1
+
+		rewards = append(rewards, RewardInfo{
+			Height:         uint(index),
+			Reward:         w.chain.GetBlockReward(uint32(index)),
+			MaturityHeight: maturityHeight,
+			Mature:         w.chain.GetHeight() >= maturityHeight,
+		})
 	}
 
-	return nonce
+	return rewards
+}
+
+// Gets the nonce of a publicKey from the ledger of the blockchain, which is the amount of txs it has sent.
+// Returns the nonce the next tx of the publicKey has to use.
+func (w *Wallet) ScanChainForNonce(pubKey string) (nonce uint32) {
+
+	return w.chain.IndexedNonce(pubKey)
 }
 
 // This function creates a tx and verifys it.
 // Inputs are the publicKey the tx is going to, and the amount of Luncheon that is being sent.
-// Outputs are the tx, which if empty, means that the amount specified is not possible with your balance.
+// Outputs are the tx, which if empty, means that the amount specified is not possible with your balance,
+// that the fee is outside of the MinTxFee and MaxTxFee of the network,
+// or that the key of the wallet can not sign under the signature scheme of the network (see SetKey).
 func (w *Wallet) CreateTx(toPub string, amount uint64) (tx transactions.LuTx) {
 
+	privKey, pubKey := w.signingKey()
+	scheme := w.chain.Params().SigScheme()
+
+	// If the key can not sign for the signature scheme of the network, like the secp256k1 main key on an Ed25519 network
+	if !scheme.ValidPubKey(pubKey) {
+
+		return transactions.LuTx{}
+	}
+
 	// Say the tx is from you
-	tx.TxFrom = w.mainKey.GetPubKeyStr()
+	tx.TxFrom = hex.EncodeToString(pubKey)
 
 	tx.TxTo = toPub
 	tx.Value = amount
 
 	tx.Nonce = w.ScanChainForNonce(tx.TxFrom)
 
-	// Simple calculation to get a tx fee
-	tx.Fee = uint64((tx.GetWeight() + 64) * 100) // The +64 is to add the weight of the signature
+	// Simple calculation to get a tx fee, including the weight of the signature that is not in the tx yet
+	tx.Fee = uint64(tx.GetWeight()+SignatureOverhead) * w.FeePerWeight
+
+	// If the fee is not allowed by the network
+	if !w.chain.Params().FeeInRange(tx.Fee) {
+
+		return transactions.LuTx{}
+	}
+
+	// Sign the same hash that verifyTxSig checks the signature against, with the scheme of the network
+	signature, err := scheme.Sign(privKey, tx.SigHash())
+
+	if err != nil {
 
-	txBytes, _ := json.Marshal(tx)
+		return transactions.LuTx{}
+	}
 
-	_, sig := w.mainKey.SignMsg(txBytes)
-	tx.Signature = hex.EncodeToString(sig)
+	tx.Signature = hex.EncodeToString(signature)
 
 	return tx
 }
 
+// The error SetKey returns when the private key inputted does not sign for the public key inputted.
+var ErrKeyMismatch = errors.New("private key does not sign for the public key under the signature scheme of the network")
+
+// Sets the key pair the wallet sends its txs from, as the bytes the signature scheme of the network takes.
+// Without a key set, the wallet sends from its main key, which is a secp256k1 key, so networks using another scheme need a key set.
+// Every copy of the wallet made before the key is set keeps sending from the old key.
+// Input is the private key and public key.
+// Returns ErrKeyMismatch if the key pair can not sign under the signature scheme of the network.
+func (w *Wallet) SetKey(privKey []byte, pubKey []byte) error {
+
+	scheme := w.chain.Params().SigScheme()
+
+	// Sign a hash with the private key, to check it is the pair of the public key
+	testHash := make([]byte, 32)
+	signature, err := scheme.Sign(privKey, testHash)
+
+	if err != nil || !scheme.Verify(pubKey, testHash, signature) {
+
+		return ErrKeyMismatch
+	}
+
+	w.privKey = privKey
+	w.pubKey = pubKey
+
+	return nil
+}
+
+// Gets the public key the wallet sends its txs from, as a hex string.
+// Returns the public key set by SetKey, or the main key if none was set.
+func (w *Wallet) PubKey() string {
+
+	_, pubKey := w.signingKey()
+
+	return hex.EncodeToString(pubKey)
+}
+
+// Gets the key pair the wallet sends its txs from.
+// Returns the private key and public key set by SetKey, or the main key if none was set.
+func (w *Wallet) signingKey() (privKey []byte, pubKey []byte) {
+
+	if w.pubKey != nil {
+
+		return w.privKey, w.pubKey
+	}
+
+	return w.mainKey.PrivKeyBytes(), w.mainKey.PubKeyBytes()
+}
+
 // Function calculates whether the tx input is valid or not.
 // Input is the tx.
 // Returns true if valid, false if not valid.
 func (w *Wallet) VerifyTx(tx transactions.LuTx) bool {
 
-	// If the tx has a spendable amount of coin from the persons balance
-	if w.ScanChainForBalance(tx.TxFrom)-(tx.Value+tx.Fee) > 0 {
+	// If the fee is too low for the weight of the tx
+	if tx.Fee < w.chain.Params().MinFeeForWeight(tx.GetWeight()) {
+
+		return false
+	}
+
+	// If the tx spends more coin than the persons balance (or so much that the total overflows)
+	if tx.Value+tx.Fee < tx.Value || w.ScanChainForBalance(tx.TxFrom) < tx.Value+tx.Fee {
 
 		return false
 	}
@@ -116,19 +515,102 @@ func (w *Wallet) VerifyTx(tx transactions.LuTx) bool {
 		return false
 	}
 
-	// Remove the sig from the tx and save it, as to get the tx hash input data
-	signature, _ := hex.DecodeString(tx.Signature)
-	tx.Signature = ""
+	return w.verifyTxSig(tx)
+}
+
+// Checks if the signature of the tx is valid for the tx.TxFrom public key.
+// Txs that are proven valid are remembered by their txid and the signature scheme, so the same tx is not verified twice.
+// The txid covers the signature, so a cached tx never has to be re-checked, even after a reorg,
+// but the params of the blockchain can change its signature scheme, which the tx then has to be checked under.
+// Returns true if the signature is valid, false if not.
+func (w *Wallet) verifyTxSig(tx transactions.LuTx) bool {
+
+	scheme := w.chain.Params().SigScheme()
+	key := sigCacheKey{scheme: fmt.Sprintf("%T", scheme), txid: tx.HashTx()}
 
-	txBytes := tx.AsBytes()
-	txHash := make([]byte, 32)
-	pubKey, _ := hex.DecodeString(tx.TxFrom)
+	// If the signature was already verified
+	if w.sigCache != nil {
 
-	sha3.ShakeSum256(txHash, txBytes)
+		if w.sigCache.has(key) {
 
-	// If the signature is not valid
-	// If this is true, than the tx is true
-	return ellip.ValidateSig(pubKey, txHash, signature)
+			return true
+		}
+	}
+
+	signature, sigErr := hex.DecodeString(tx.Signature)
+	pubKey, pubKeyErr := hex.DecodeString(tx.TxFrom)
+
+	if sigErr != nil || pubKeyErr != nil {
+
+		return false
+	}
+
+	// If the signature is not valid, under the signature scheme of the network
+	// The signature has to be checked against the exact key of TxFrom, so the scheme also checks it is a valid public key
+	if !scheme.Verify(pubKey, tx.SigHash(), signature) {
+
+		return false
+	}
+
+	if w.sigCache != nil {
+
+		w.sigCache.add(key)
+	}
+
+	return true
+}
+
+// The reasons a block can be invalid, returned by VerifyBlockE.
+var (
+	ErrBadSoftwareVersion = errors.New("block has a different software version")
+	ErrBadMiner           = errors.New("block miner is not a valid public key")
+	ErrBadCoinbaseTag     = errors.New("block coinbase tag is too long")
+	ErrBadBlockHash       = errors.New("block hash does not match the block")
+	ErrBadProofOfWork     = errors.New("block hash is above the block target")
+	ErrBadPrevHash        = errors.New("block does not point to the previous block")
+	ErrBadTimestamp       = errors.New("block timestamp is out of range")
+	ErrBadTarget          = errors.New("block target is not the expected target")
+	ErrBadMerkleRoot      = errors.New("block merkle root does not match its txs")
+	ErrBadTxOrder         = errors.New("block txs are not in order")
+	ErrBadTxSig           = errors.New("block has a tx with an invalid signature")
+	ErrKnownInvalid       = errors.New("block or the block it builds on is known to be invalid")
+	ErrGenesisPrevHash    = errors.New("block that is not the genisis block has the genisis prev hash")
+)
+
+// How badly a peer broke the rules by sending an invalid block, used as the amount to raise its ban score by.
+const (
+	// The block was valid
+	SeverityNone = 0
+
+	// The block could have come from an honest peer, like one that is out of sync or has a skewed clock
+	SeverityMinor = 10
+
+	// The block could only have come from a broken or malicious peer, like one with bad proof of work
+	SeverityFatal = 100
+)
+
+// Finds how badly the rule broken by an invalid block was broken, for ban scoring the peer that sent it.
+// Input is the error returned by VerifyBlockE, which may be wrapped.
+// Unknown errors are treated as minor, so a peer is not banned over an error that is not its fault.
+// Returns the severity of the error, SeverityNone if the error is nil.
+func RuleSeverity(err error) int {
+
+	switch {
+
+	case err == nil:
+		return SeverityNone
+
+	case errors.Is(err, ErrBadSoftwareVersion), errors.Is(err, ErrBadPrevHash), errors.Is(err, ErrBadTimestamp):
+		return SeverityMinor
+
+	case errors.Is(err, ErrBadMiner), errors.Is(err, ErrBadCoinbaseTag), errors.Is(err, ErrBadBlockHash),
+		errors.Is(err, ErrBadProofOfWork), errors.Is(err, ErrBadTarget), errors.Is(err, ErrBadMerkleRoot),
+		errors.Is(err, ErrBadTxOrder), errors.Is(err, ErrBadTxSig), errors.Is(err, ErrKnownInvalid),
+		errors.Is(err, ErrGenesisPrevHash):
+		return SeverityFatal
+	}
+
+	return SeverityMinor
 }
 
 // Verifies of the block inputted is valid or not.
@@ -137,10 +619,30 @@ func (w *Wallet) VerifyTx(tx transactions.LuTx) bool {
 // Returns true if it is valid, false if not valid.
 func (w *Wallet) VerifyBlock(block *blockchain.Block, checkSoftwareVersion bool) bool {
 
-	// If it is the genisis block
-	if len(w.chain.Blocks) == 1 {
+	return w.VerifyBlockE(block, checkSoftwareVersion) == nil
+}
 
-		return true
+// Verifies of the block inputted is valid or not, the same as VerifyBlock.
+// Invalid txs are removed from the block, rather than making the whole block invalid.
+// Returns nil if it is valid, or the error of the reason it is not valid.
+func (w *Wallet) VerifyBlockE(block *blockchain.Block, checkSoftwareVersion bool) error {
+
+	// If there is no block for it to point to
+	if w.chain.Len() == 0 {
+
+		return ErrBadPrevHash
+	}
+
+	// If the block was already proven invalid, or builds on a block that was
+	if w.chain.IsInvalid(block.BlockHash) || w.chain.IsInvalid(block.PrevHash) {
+
+		// A block building on an invalid block is invalid too
+		if hex.EncodeToString(block.CalcHash()) == block.BlockHash {
+
+			w.chain.MarkInvalid(block.BlockHash)
+		}
+
+		return ErrKnownInvalid
 	}
 
 	// Checks if the software version, if the func is told to do so
@@ -148,80 +650,236 @@ func (w *Wallet) VerifyBlock(block *blockchain.Block, checkSoftwareVersion bool)
 
 		if block.SoftwareVersion != utilities.SoftwareVersion {
 
-			return false
+			return ErrBadSoftwareVersion
 		}
 	}
 
-	bytesUtil := new(utilities.ByteUtil)
+	if err := w.verifyBlockAt(block, uint(len(w.chain.Blocks))); err != nil {
 
-	// Check the Block hash
-	softwareVersion := []byte(block.SoftwareVersion)
-	prevBlockHash, _ := hex.DecodeString(block.PrevHash)
-	merkleRoot, _ := hex.DecodeString(block.MerkleRoot)
-	blockTime := bytesUtil.Uint64toB(block.Timestamp)
-	packedTargetBytes := bytesUtil.Uint32toB(block.PackedTarget)
-	nonceBytes := bytesUtil.Uint32toB(block.Nonce)
+		// Remember the blocks that can never be valid, which are the ones failing the checks after the block hash is checked
+		// A block with the wrong block hash is not remembered, as it could be claiming the hash of a valid block
+		if errors.Is(err, ErrBadProofOfWork) || errors.Is(err, ErrBadTarget) {
 
-	// Shove them together (into softwareVerion bc it is first declared)
-	softwareVersion = append(softwareVersion, prevBlockHash...)
-	softwareVersion = append(softwareVersion, merkleRoot...)
-	softwareVersion = append(softwareVersion, packedTargetBytes...)
-	softwareVersion = append(softwareVersion, blockTime...)
-	softwareVersion = append(softwareVersion, nonceBytes...)
+			w.chain.MarkInvalid(block.BlockHash)
+		}
 
-	hash := make([]byte, 32)
+		return err
+	}
+
+	// Check the txs
+	for index := 0; index < len(block.Txs); {
+
+		// If the tx is not valid, just remove it
+		// The next tx slides into its index, so the index is not moved on
+		if !w.VerifyTx(block.Txs[index]) {
+
+			block.RemoveTx(uint(index))
+			continue
+		}
+
+		index += 1
+	}
+
+	return nil
+}
 
-	// Hash the data
-	sha3.ShakeSum256(hash, softwareVersion)
+// Verifies a block that is already on the blockchain, with the rules that were active at its height.
+// The balances and nonces of its txs are not checked, as they depend on the chain as it was at that height.
+// The tx signatures are not checked for blocks at or below the AssumeValid block of the params.
+// Input is the height of the block.
+// Returns nil if it is valid, or the error of the reason it is not valid.
+func (w *Wallet) VerifyHistoricalBlock(height uint) error {
+
+	assumeValidHeight, assumeValid := w.assumeValidHeight()
+
+	return w.verifyHistoricalBlock(height, !assumeValid || height > assumeValidHeight)
+}
+
+// Verifies a block that is already on the blockchain, the same as VerifyHistoricalBlock.
+// Input is the height of the block, and whether the tx signatures should be checked.
+// Returns nil if it is valid, or the error of the reason it is not valid.
+func (w *Wallet) verifyHistoricalBlock(height uint, checkSigs bool) error {
+
+	block, found := w.chain.GetBlock(height)
+
+	// The genisis block has nothing to be verified against
+	if !found || height == 0 {
+
+		return nil
+	}
+
+	if err := w.verifyBlockAt(&block, height); err != nil {
+
+		return err
+	}
+
+	if !checkSigs {
+
+		return nil
+	}
+
+	for index := 0; index < len(block.Txs); index += 1 {
+
+		if !w.verifyTxSig(block.Txs[index]) {
+
+			return ErrBadTxSig
+		}
+	}
+
+	return nil
+}
+
+// Finds the height of the AssumeValid block of the params on the blockchain.
+// Returns the height, and true if the params have an AssumeValid block that is on the blockchain.
+func (w *Wallet) assumeValidHeight() (uint, bool) {
+
+	assumeValid := w.chain.Params().AssumeValid
+
+	if assumeValid == "" {
+
+		return 0, false
+	}
+
+	for index := 0; index < len(w.chain.Blocks); index += 1 {
+
+		if w.chain.Blocks[index].BlockHash == assumeValid {
+
+			return uint(index), true
+		}
+	}
+
+	return 0, false
+}
+
+// Finds the lowest height VerifyBlockchain checks the tx signatures of.
+// The signatures of the AssumeValid block and below, and of the blocks more than SigCheckDepth below the tip, are trusted.
+// Returns the height, which is 0 if every signature is checked.
+func (w *Wallet) sigCheckHeight() uint {
+
+	height := uint(0)
+
+	if assumeValidHeight, assumeValid := w.assumeValidHeight(); assumeValid {
+
+		height = assumeValidHeight + 1
+	}
+
+	depth := w.chain.Params().SigCheckDepth
+	tip := w.chain.GetHeight()
+
+	if depth != 0 && tip > depth && tip-depth > height {
+
+		height = tip - depth
+	}
+
+	return height
+}
+
+// Verifies the header of a block as the block at the height inputted, against the block before it.
+// Only the rules that are active at the height are checked.
+// Returns nil if it is valid, or the error of the reason it is not valid.
+func (w *Wallet) verifyBlockAt(block *blockchain.Block, height uint) error {
+
+	params := w.chain.Params()
+	prevBlock := w.chain.Blocks[height-1]
+
+	// If the block is trying to pass itself off as a genisis block
+	if block.PrevHash == blockchain.GenesisPrevHash {
+
+		return ErrGenesisPrevHash
+	}
+
+	if params.RuleActive(blockchain.RuleValidMiner, height) {
+
+		minerKey, err := hex.DecodeString(block.Miner)
+
+		// If the block reward would go to an invalid public key
+		if err != nil || !params.SigScheme().ValidPubKey(minerKey) {
+
+			return ErrBadMiner
+		}
+	}
+
+	// If the miner put more in the coinbase than is allowed
+	if len(block.CoinbaseData) > blockchain.MaxCoinbaseTagLen {
+
+		return ErrBadCoinbaseTag
+	}
+
+	hash := block.CalcHash()
 
 	// If the blockhash is invalid
 	if hex.EncodeToString(hash) != block.BlockHash {
 
-		fmt.Println(hex.EncodeToString(hash))
-		return false
+		return ErrBadBlockHash
 	}
 
-	// Check if the block points to the previous block
-	if block.PrevHash != w.chain.Blocks[w.chain.GetHeight()].BlockHash {
+	if params.RuleActive(blockchain.RuleProofOfWork, height) {
 
-		return false
+		unpacker := new(utilities.TargetUnpacker)
+		target, err := unpacker.UnpackChecked(block.PackedTarget)
+
+		// If the target of the block can not be unpacked
+		if err != nil {
+
+			return fmt.Errorf("%w: %s", ErrBadTarget, err.Error())
+		}
+
+		// If the block hash does not meet its own target
+		if bytes.Compare(hash, target.Get()) == 1 {
+
+			return ErrBadProofOfWork
+		}
 	}
 
-	timeUtil := new(utilities.Time)
+	// Check if the block points to the previous block
+	if block.PrevHash != prevBlock.BlockHash {
+
+		return ErrBadPrevHash
+	}
 
-	// Check if the timestamp is valid
+	// Check if the timestamp is after the block before it, like Validate checks, and is up to MaxFutureDrift ahead of the clock
 	// TODO: make more advanced
-	if block.Timestamp < w.chain.Blocks[w.chain.GetHeight()].Timestamp || block.Timestamp > timeUtil.CurrentUnix() {
+	if block.Timestamp <= prevBlock.Timestamp || block.Timestamp > w.now()+params.MaxFutureDrift {
 
-		return false
+		return ErrBadTimestamp
 	}
 
 	// Check if the target is correct
-	if block.PackedTarget != w.chain.CalculatePackedTarget(uint(len(w.chain.Blocks))) {
+	if block.PackedTarget != w.chain.CalculatePackedTarget(height) {
 
-		return false
+		return ErrBadTarget
+	}
+
+	// Check the txs are in the order every node puts them in
+	if params.RuleActive(blockchain.RuleTxOrder, height) && !block.TxsOrdered() {
+
+		return ErrBadTxOrder
 	}
 
 	// Check the merkle root
-	if block.MerkleRoot != block.GetMerkleRoot() {
+	if !block.VerifyMerkleRoot(block.MerkleRoot) {
 
-		return false
+		return ErrBadMerkleRoot
 	}
 
-	// Check the txs
-	for index := 0; index < len(block.Txs); index += 1 {
+	return nil
+}
 
-		// If the tx is not valid, just remove it
-		if !w.VerifyTx(block.Txs[index]) {
+// Gets the current time from the clock of the wallet, or the real clock if the wallet has none.
+// Returns the unix time in seconds.
+func (w *Wallet) now() uint64 {
 
-			block.RemoveTx(uint(index))
-		}
+	if w.Clock == nil {
+
+		return new(utilities.Time).Now()
 	}
 
-	return true
+	return w.Clock.Now()
 }
 
 // Verifys whether the blockchain attached to the wallet is valid or not.
+// Only the blocks added since the last call are verified, as the blocks before them were already proven valid.
+// If the blocks were rolled back in a reorg, the blocks from the fork are verified again.
 // Returns true if valid, false if invalid.
 func (w *Wallet) VerifyBlockchain() bool {
 
@@ -231,31 +889,53 @@ func (w *Wallet) VerifyBlockchain() bool {
 		return true
 	}
 
-	//****
-	// Check the genisis block:
-
-	if len(w.chain.Blocks[0].Txs) != 0 {
+	// A wallet not made with Init has nowhere to remember the verified blocks
+	if w.verified == nil {
 
-		return false
+		w.verified = new(verifyMarker)
 	}
 
-	if w.chain.Blocks[0].PackedTarget != 0x1d0fffff {
+	w.verified.mutex.Lock()
+	defer w.verified.mutex.Unlock()
 
-		return false
-	}
+	startIndex := w.verified.resumeFrom(w.chain)
 
-	// Check the genisis block
-	//****
+	if startIndex == 0 {
+
+		//****
+		// Check the genisis block:
+
+		if len(w.chain.Blocks[0].Txs) != 0 {
+
+			return false
+		}
+
+		if w.chain.Blocks[0].PackedTarget != w.chain.Params().GenesisTarget {
+
+			return false
+		}
+
+		w.verified.mark(w.chain, 0)
+		startIndex = 1
+
+		// Check the genisis block
+		//****
+	}
 
 	//****
 	// Checks the rest of the blocks
 
-	for blockIndex := 1; blockIndex < len(w.chain.Blocks); blockIndex += 1 {
+	// Only find where the signatures start being checked once, instead of for every block
+	sigHeight := w.sigCheckHeight()
+
+	for blockIndex := startIndex; blockIndex < uint(len(w.chain.Blocks)); blockIndex += 1 {
 
-		if !w.VerifyBlock(&w.chain.Blocks[blockIndex], false) {
+		if w.verifyHistoricalBlock(blockIndex, blockIndex >= sigHeight) != nil {
 
 			return false
 		}
+
+		w.verified.mark(w.chain, blockIndex)
 	}
 
 	// Checks the rest of the blocks
@@ -263,3 +943,268 @@ func (w *Wallet) VerifyBlockchain() bool {
 
 	return true
 }
+
+// Finds the first block VerifyBlockchain has not verified yet.
+// If the last verified block is no longer on the blockchain (like when the blocks were changed without RemoveBlock),
+// or the blockchain is on a different network, every block is verified again.
+// Returns the height of the first block to verify.
+func (m *verifyMarker) resumeFrom(bc *blockchain.Blockchain) uint {
+
+	if m.blocks == 0 || m.blocks > bc.Len() || bc.Blocks[m.blocks-1].BlockHash != m.tipHash || bc.Params().Name != m.network {
+
+		m.blocks = 0
+		m.tipHash = ""
+	}
+
+	return m.blocks
+}
+
+// Remembers that every block up to the height inputted was verified.
+// Returns nothing.
+func (m *verifyMarker) mark(bc *blockchain.Blockchain, height uint) {
+
+	m.blocks = height + 1
+	m.tipHash = bc.Blocks[height].BlockHash
+	m.network = bc.Params().Name
+}
+
+// Verifies only the newest blocks of the blockchain, trusting the blocks before them.
+// Used for fast restarts, where the blocks were already verified when they were first added.
+// Input is the amount of blocks from the tip to verify.
+// Returns nil if they are valid, or the error of the first invalid block with its height.
+func (w *Wallet) VerifyRecent(n uint) error {
+
+	// Nothing to verify besides the genisis block
+	if len(w.chain.Blocks) < 2 {
+
+		return nil
+	}
+
+	tip := w.chain.GetHeight()
+
+	startHeight := uint(1)
+
+	if n < tip {
+
+		startHeight = tip - n + 1
+	}
+
+	assumeValidHeight, assumeValid := w.assumeValidHeight()
+
+	for height := startHeight; height <= tip; height += 1 {
+
+		checkSigs := !assumeValid || height > assumeValidHeight
+
+		if err := w.verifyHistoricalBlock(height, checkSigs); err != nil {
+
+			return fmt.Errorf("block %d: %w", height, err)
+		}
+	}
+
+	return nil
+}
+
+// Somewhere txs wait to be mined, like the mempool.
+type TxPool interface {
+	AddTx(tx transactions.LuTx) error
+}
+
+// A tx that was no longer confirmed after a reorg, but that the pool would not take back.
+type DroppedTx struct {
+	Tx transactions.LuTx
+
+	// The reason the pool rejected the tx, from its AddTx
+	Err error
+}
+
+// The txs of the wallet a reorg took out of the blockchain, by what happened to them.
+type ReorgResult struct {
+
+	// The txs that were added back to the pool
+	Requeued []transactions.LuTx
+
+	// The txs the pool would not take yet, as a tx of the wallet with a lower nonce is waiting in it.
+	// These have to be sent again once the txs before them are mined.
+	Pending []transactions.LuTx
+
+	// The txs the pool rejected, which have to be sent again
+	Dropped []DroppedTx
+}
+
+// The most blocks removed from the blockchain the wallet remembers for ReconcileAfterReorg.
+const MaxOrphanedBlocks = 100
+
+// The blocks removed from the blockchain, so the branch a reorg orphaned can be found from its old tip.
+// Shared by every copy of the wallet, like the sigCache.
+type orphanStore struct {
+	mutex sync.Mutex
+
+	// The removed blocks and the height they were removed from, by block hash
+	blocks  map[string]blockchain.Block
+	heights map[string]uint
+
+	// The hashes of the removed blocks, oldest first, so the oldest is forgotten once there are MaxOrphanedBlocks
+	order []string
+}
+
+// Remembers a block removed from the blockchain, forgetting the oldest block if there are too many.
+// Input is the block and the height it was removed from.
+// Returns nothing.
+func (o *orphanStore) add(block blockchain.Block, height uint) {
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if _, found := o.blocks[block.BlockHash]; !found {
+
+		o.order = append(o.order, block.BlockHash)
+	}
+
+	o.blocks[block.BlockHash] = block
+	o.heights[block.BlockHash] = height
+
+	for len(o.order) > MaxOrphanedBlocks {
+
+		delete(o.blocks, o.order[0])
+		delete(o.heights, o.order[0])
+		o.order = o.order[1:]
+	}
+}
+
+// Gets a block removed from the blockchain.
+// Input is the hash of the block.
+// Returns the block, the height it was removed from, and false if the block is not remembered.
+func (o *orphanStore) get(hash string) (block blockchain.Block, height uint, found bool) {
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	block, found = o.blocks[hash]
+
+	return block, o.heights[hash], found
+}
+
+// The errors ReconcileAfterReorg returns when it can not find the txs the reorg orphaned.
+var (
+	ErrNotOnNewTip = errors.New("blockchain is not on the new tip of the reorg")
+	ErrUnknownTip  = errors.New("old tip is not on the blockchain or a branch the wallet saw removed from it")
+)
+
+// Finds the txs sent by the wallet that were confirmed on the branch a reorg orphaned, and are not on the new best chain.
+// These txs are back in limbo, so they are added back to the pool to be mined again, in nonce order.
+// As a pool may only take one pending tx from each sender, a tx the pool rejects after an earlier tx of the wallet
+// was taken back is pending, not dropped.
+// The blockchain of the wallet has to already be on the new best chain, and the orphaned blocks have to have been
+// removed from it (like by RollbackTo) while the wallet was open, as the wallet remembers the blocks removed from its blockchain.
+// Input is the hash of the tip before the reorg, the hash of the tip after the reorg, and the pool the txs go back into.
+// Returns the txs of the wallet by what happened to them, or an error if the orphaned branch can not be found.
+func (w *Wallet) ReconcileAfterReorg(oldTip string, newTip string, pool TxPool) (result ReorgResult, err error) {
+
+	result.Requeued = []transactions.LuTx{}
+	result.Pending = []transactions.LuTx{}
+	result.Dropped = []DroppedTx{}
+
+	if tip, found := w.chain.Tip(); !found || tip.BlockHash != newTip {
+
+		return result, ErrNotOnNewTip
+	}
+
+	// Walk back from the old tip, through the removed blocks, to the block the branch has in common with the blockchain
+	orphaned := []blockchain.Block{}
+	hash := oldTip
+
+	for {
+
+		block, height, found := w.orphans.get(hash)
+
+		if !found || w.isOnChain(hash, height) {
+
+			break
+		}
+
+		orphaned = append(orphaned, block)
+		hash = block.PrevHash
+	}
+
+	// If the branch was not all remembered, or the old tip was never on the blockchain
+	if !w.hashOnChain(hash) {
+
+		return result, ErrUnknownTip
+	}
+
+	pubKey := w.PubKey()
+	orphanedTxs := []transactions.LuTx{}
+
+	for index := 0; index < len(orphaned); index += 1 {
+
+		for txIndex := 0; txIndex < len(orphaned[index].Txs); txIndex += 1 {
+
+			tx := orphaned[index].Txs[txIndex]
+
+			if tx.TxFrom != pubKey {
+
+				continue
+			}
+
+			// If the new best chain confirmed the tx too
+			if _, _, found := w.chain.GetBlockByTxid(tx.HashTx()); found {
+
+				continue
+			}
+
+			orphanedTxs = append(orphanedTxs, tx)
+		}
+	}
+
+	// A tx can only be mined after the txs of the wallet with lower nonces
+	sort.SliceStable(orphanedTxs, func(i, j int) bool { return orphanedTxs[i].Nonce < orphanedTxs[j].Nonce })
+
+	for index := 0; index < len(orphanedTxs); index += 1 {
+
+		tx := orphanedTxs[index]
+		err := pool.AddTx(tx)
+
+		if err == nil {
+
+			result.Requeued = append(result.Requeued, tx)
+			continue
+		}
+
+		// If an earlier tx of the wallet is waiting, the pool may take this tx once that one is mined
+		if len(result.Requeued)+len(result.Pending) != 0 {
+
+			result.Pending = append(result.Pending, tx)
+			continue
+		}
+
+		result.Dropped = append(result.Dropped, DroppedTx{Tx: tx, Err: err})
+	}
+
+	return result, nil
+}
+
+// Checks if a block is on the blockchain of the wallet.
+// Input is the hash of the block and its height.
+// Returns true if the blockchain has the block at that height.
+func (w *Wallet) isOnChain(hash string, height uint) bool {
+
+	block, found := w.chain.GetBlock(height)
+
+	return found && block.BlockHash == hash
+}
+
+// Checks if a block is anywhere on the blockchain of the wallet, starting from the tip.
+// Input is the hash of the block.
+// Returns true if the blockchain has the block.
+func (w *Wallet) hashOnChain(hash string) bool {
+
+	for index := len(w.chain.Blocks) - 1; index >= 0; index -= 1 {
+
+		if w.chain.Blocks[index].BlockHash == hash {
+
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,1781 @@
+package wallet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sucks-To-Suck/LuncheonNetwork/blockchain"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/ellip"
+	"github.com/Sucks-To-Suck/LuncheonNetwork/transactions"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Creates a new random key pair for testing.
+// Returns the private key and the hex string of its public key.
+func newTestKey(t testing.TB) (*ecdsa.PrivateKey, string) {
+
+	key, err := crypto.GenerateKey()
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return key, hex.EncodeToString(elliptic.Marshal(crypto.S256(), key.X, key.Y))
+}
+
+// Creates a tx from the public key of the private key inputted, and signs it.
+// Returns the signed tx.
+func newSignedTx(key *ecdsa.PrivateKey, toPub string, amount uint64, fee uint64) transactions.LuTx {
+
+	tx := transactions.LuTx{
+		TxFrom: hex.EncodeToString(elliptic.Marshal(crypto.S256(), key.X, key.Y)),
+		TxTo:   toPub,
+		Value:  amount,
+		Fee:    fee,
+	}
+
+	tx.Signature = hex.EncodeToString(ellip.SignHash(key, tx.SigHash()))
+
+	return tx
+}
+
+func TestVerifyTxSigCache(t *testing.T) {
+
+	bc := new(blockchain.Blockchain)
+	wal := Init(bc)
+
+	key, _ := newTestKey(t)
+	tx := newSignedTx(key, "kaimorton123", 2000, 100)
+
+	if !wal.verifyTxSig(tx) {
+
+		t.Fatal("Expected the signature to be valid")
+	}
+
+	if !wal.sigCache.has(sigCacheKey{scheme: "ellip.Secp256k1", txid: tx.HashTx()}) {
+
+		t.Error("Expected the valid tx to be cached")
+	}
+
+	// The cached tx is checked again under a different signature scheme
+	params := blockchain.MainnetParams
+	params.SignatureScheme = ellip.Ed25519{}
+	bc.SetParams(params)
+
+	if wal.verifyTxSig(tx) {
+
+		t.Error("Expected the cached tx to be invalid under a different signature scheme")
+	}
+
+	params.SignatureScheme = ellip.Secp256k1{}
+	bc.SetParams(params)
+
+	// Tamper with the tx, which gives it a new txid
+	tx.Value = 3000
+
+	if wal.verifyTxSig(tx) {
+
+		t.Fatal("Expected the tampered signature to be invalid")
+	}
+
+	if wal.sigCache.has(sigCacheKey{scheme: "ellip.Secp256k1", txid: tx.HashTx()}) {
+
+		t.Error("Expected the invalid tx to not be cached")
+	}
+}
+
+func TestVerifyTxSigCacheBounded(t *testing.T) {
+
+	defer func(size int) { MaxSigCacheSize = size }(MaxSigCacheSize)
+	MaxSigCacheSize = 2
+
+	bc := newRewardChain(3, "someoneElse")
+	wal := Init(bc)
+
+	key, _ := newTestKey(t)
+	txs := []transactions.LuTx{}
+
+	for index := 0; index < 3; index += 1 {
+
+		tx := newSignedTx(key, "kaimorton123", uint64(2000+index), 100)
+		wal.verifyTxSig(tx)
+
+		txs = append(txs, tx)
+	}
+
+	// Only the newest signatures are remembered
+	for index, expected := range []bool{false, true, true} {
+
+		if cached := wal.sigCache.has(sigCacheKey{scheme: "ellip.Secp256k1", txid: txs[index].HashTx()}); cached != expected {
+
+			t.Errorf("Tx %d: expected cached to be %v", index, expected)
+		}
+	}
+
+	// A block removed from the blockchain takes the cached signatures with it
+	bc.RemoveBlock()
+
+	if wal.sigCache.has(sigCacheKey{scheme: "ellip.Secp256k1", txid: txs[2].HashTx()}) {
+
+		t.Error("Expected the cache to be cleared when a block is removed")
+	}
+}
+
+// Verifies the same tx over and over, like when a tx is checked in the mempool and again in a block.
+func BenchmarkVerifyTxSigCached(b *testing.B) {
+
+	wal := Init(new(blockchain.Blockchain))
+
+	key, _ := newTestKey(b)
+	tx := newSignedTx(key, "kaimorton123", 2000, 100)
+
+	for index := 0; index < b.N; index += 1 {
+
+		wal.verifyTxSig(tx)
+	}
+}
+
+// The same work as above, without the cache.
+func BenchmarkVerifyTxSigUncached(b *testing.B) {
+
+	wal := Init(new(blockchain.Blockchain))
+	wal.sigCache = nil
+
+	key, _ := newTestKey(b)
+	tx := newSignedTx(key, "kaimorton123", 2000, 100)
+
+	for index := 0; index < b.N; index += 1 {
+
+		wal.verifyTxSig(tx)
+	}
+}
+
+// Creates a blockchain of unmined blocks, where the blocks at the heights inputted are mined by the miner inputted.
+// Returns the blockchain.
+func newRewardChain(length int, miner string, minedHeights ...int) *blockchain.Blockchain {
+
+	bc := new(blockchain.Blockchain)
+
+	for index := 0; index < length; index += 1 {
+
+		bc.Blocks = append(bc.Blocks, blockchain.Block{Miner: "someoneElse"})
+	}
+
+	for _, height := range minedHeights {
+
+		bc.Blocks[height].Miner = miner
+	}
+
+	return bc
+}
+
+func TestImmatureBalance(t *testing.T) {
+
+	// The tip is at height 19, so rewards from height 9 and up are still immature
+	bc := newRewardChain(20, "miner", 3, 8, 9, 12, 19)
+	wal := Init(bc)
+
+	reward := bc.GetBlockReward(0)
+
+	if balance := wal.ImmatureBalance("miner"); balance != 3*reward {
+
+		t.Errorf("Expected an immature balance of %d, got %d", 3*reward, balance)
+	}
+
+	if balance := wal.ScanChainForBalance("miner"); balance != 2*reward {
+
+		t.Errorf("Expected a mature balance of %d, got %d", 2*reward, balance)
+	}
+
+	if balance := wal.ImmatureBalance("nobody"); balance != 0 {
+
+		t.Errorf("Expected no immature balance, got %d", balance)
+	}
+}
+
+// The easy target the test blockchains use, so blocks mine in a few thousand hashes.
+const testTarget = 0x1f0fffff
+
+// Creates a blockchain with a genisis block and one mined block, using the easy test target.
+// Returns the blockchain.
+func newMinedChain(t testing.TB) *blockchain.Blockchain {
+
+	_, minerPub := newTestKey(t)
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: minerPub})
+
+	block := bc.CreateBlock(minerPub)
+	mineBlock(t, bc, &block)
+	bc.AddBlock(&block)
+
+	return bc
+}
+
+// Mines the block inputted on the blockchain inputted.
+// Returns nothing.
+func mineBlock(t testing.TB, bc *blockchain.Blockchain, block *blockchain.Block) {
+
+	miner := new(blockchain.Miner)
+	miner.Out = io.Discard
+
+	if found, err := miner.Start(block, bc, 1); !found || err != nil {
+
+		t.Fatalf("Could not mine the test block: %v", err)
+	}
+}
+
+func TestVerifyBlockMiner(t *testing.T) {
+
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	_, minerPub := newTestKey(t)
+
+	block := bc.CreateBlock(minerPub)
+	mineBlock(t, bc, &block)
+
+	if !wal.VerifyBlock(&block, true) {
+
+		t.Error("Expected a block with a valid miner to be valid")
+	}
+
+	for _, badMiner := range []string{"", "kaimorton123", "04" + minerPub[2:len(minerPub)-2]} {
+
+		block := bc.CreateBlock(badMiner)
+		mineBlock(t, bc, &block)
+
+		if wal.VerifyBlock(&block, true) {
+
+			t.Errorf("Expected a block with the miner %q to be invalid", badMiner)
+		}
+	}
+}
+
+func TestUnspentRewards(t *testing.T) {
+
+	// The tip is at height 19
+	bc := newRewardChain(20, "miner", 3, 9, 19)
+	wal := Init(bc)
+
+	reward := bc.GetBlockReward(0)
+
+	expected := []RewardInfo{
+		{Height: 3, Reward: reward, MaturityHeight: 14, Mature: true},
+		{Height: 9, Reward: reward, MaturityHeight: 20, Mature: false},
+		{Height: 19, Reward: reward, MaturityHeight: 30, Mature: false},
+	}
+
+	rewards := wal.UnspentRewards("miner")
+
+	if len(rewards) != len(expected) {
+
+		t.Fatalf("Expected %d rewards, got %d", len(expected), len(rewards))
+	}
+
+	var matureTotal uint64
+
+	for index := range expected {
+
+		if rewards[index] != expected[index] {
+
+			t.Errorf("Reward %d: expected %+v, got %+v", index, expected[index], rewards[index])
+		}
+
+		if rewards[index].Mature {
+
+			matureTotal += rewards[index].Reward
+		}
+	}
+
+	// The mature rewards are what the balance scan counts
+	if balance := wal.ScanChainForBalance("miner"); balance != matureTotal {
+
+		t.Errorf("Expected the mature rewards to match the balance of %d, got %d", balance, matureTotal)
+	}
+}
+
+func TestVerifyHistoricalBlockRuleActivation(t *testing.T) {
+
+	_, minerPub := newTestKey(t)
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: minerPub})
+
+	// The valid miner rule starts at height 3
+	params := blockchain.MainnetParams
+	params.RuleHeights = map[blockchain.Rule]uint{blockchain.RuleValidMiner: 3}
+	bc.SetParams(params)
+
+	// Blocks 1 to 4 all reward an invalid public key
+	for index := 0; index < 4; index += 1 {
+
+		block := bc.CreateBlock("kaimorton123")
+		mineBlock(t, bc, &block)
+		bc.AddBlock(&block)
+	}
+
+	wal := Init(bc)
+
+	for height := uint(1); height <= 4; height += 1 {
+
+		err := wal.VerifyHistoricalBlock(height)
+
+		if height < 3 && err != nil {
+
+			t.Errorf("Block %d: expected a block from before the rule to be valid, got %v", height, err)
+		}
+
+		if height >= 3 && err != ErrBadMiner {
+
+			t.Errorf("Block %d: expected %v, got %v", height, ErrBadMiner, err)
+		}
+	}
+
+	if wal.VerifyBlockchain() {
+
+		t.Error("Expected the blockchain with blocks breaking an active rule to be invalid")
+	}
+
+	// Without the rule ever activating, the whole chain is valid
+	params.RuleHeights[blockchain.RuleValidMiner] = 10
+	bc.SetParams(params)
+
+	for height := uint(1); height <= 4; height += 1 {
+
+		if err := wal.VerifyHistoricalBlock(height); err != nil {
+
+			t.Errorf("Block %d: expected the block to be valid, got %v", height, err)
+		}
+	}
+}
+
+func TestVerifyTxSigHash(t *testing.T) {
+
+	bc := new(blockchain.Blockchain)
+	wal := Init(bc)
+
+	// A tx made by the wallet is signed over the same hash it is verified with
+	tx := wal.CreateTx("kaimorton123", 2000)
+
+	if !wal.verifyTxSig(tx) {
+
+		t.Error("Expected the tx created by the wallet to have a valid signature")
+	}
+
+	key, _ := newTestKey(t)
+	tx = newSignedTx(key, "kaimorton123", 2000, 100)
+
+	// Sign the tx bytes with a different hash algorithm
+	wrongHash := crypto.Keccak256(tx.AsBytes())
+	tx.Signature = hex.EncodeToString(ellip.SignHash(key, wrongHash))
+
+	if wal.verifyTxSig(tx) {
+
+		t.Error("Expected a tx signed over the wrong hash to be invalid")
+	}
+}
+
+func TestCreateTxRoundTrip(t *testing.T) {
+
+	wal := Init(new(blockchain.Blockchain))
+	pubKey := wal.mainKey.GetPubKeyStr()
+
+	// The wallet has one mature block reward to spend
+	bc := newRewardChain(20, pubKey, 2)
+	wal.chain = bc
+
+	tx := wal.CreateTx("kaimorton123", 2000)
+
+	if !wal.VerifyTx(tx) {
+
+		t.Fatal("Expected the tx created by the wallet to be valid")
+	}
+
+	// More than the balance can not be spent
+	tx = wal.CreateTx("kaimorton123", bc.GetBlockReward(2))
+
+	if wal.VerifyTx(tx) {
+
+		t.Error("Expected a tx spending more than the balance to be invalid")
+	}
+
+	// A tx changed after signing is no longer valid
+	tx = wal.CreateTx("kaimorton123", 2000)
+	tx.Value = 3000
+
+	if wal.VerifyTx(tx) {
+
+		t.Error("Expected a tx changed after signing to be invalid")
+	}
+}
+
+func TestVerifyTxBalance(t *testing.T) {
+
+	wal := Init(new(blockchain.Blockchain))
+	pubKey := wal.mainKey.GetPubKeyStr()
+	wal.chain = newRewardChain(20, pubKey, 2)
+
+	balance := wal.ScanChainForBalance(pubKey)
+	fee := uint64(2000)
+
+	tests := []struct {
+		name  string
+		value uint64
+		fee   uint64
+		valid bool
+	}{
+		{"leftover balance", balance / 2, fee, true},
+		{"exact balance", balance - fee, fee, true},
+		{"one over the balance", balance - fee + 1, fee, false},
+		{"fee over the balance", 0, balance + 1, false},
+		{"value and fee overflow", math.MaxUint64 - fee + 1, fee, false},
+		{"value and fee overflow to under the balance", math.MaxUint64, fee + 1, false},
+	}
+
+	for _, test := range tests {
+
+		tx := transactions.LuTx{TxFrom: pubKey, TxTo: "kaimorton123", Value: test.value, Fee: test.fee}
+		tx.Signature = hex.EncodeToString(wal.mainKey.SignHash(tx.SigHash()))
+
+		if valid := wal.VerifyTx(tx); valid != test.valid {
+
+			t.Errorf("%s: expected valid to be %t, got %t", test.name, test.valid, valid)
+		}
+	}
+}
+
+func TestVerifyTxMinRelayFee(t *testing.T) {
+
+	wal := Init(new(blockchain.Blockchain))
+	pubKey := wal.mainKey.GetPubKeyStr()
+	wal.chain = newRewardChain(20, pubKey, 2)
+	params := wal.chain.Params()
+
+	// A heavy tx claiming the least fee in range of the network
+	tx := wal.CreateTx("kaimorton123", 2000)
+	tx.Script = strings.Repeat("TXID 123 ", 200)
+	tx.Fee = params.MinTxFee
+
+	minFee := params.MinFeeForWeight(tx.GetWeight() + SignatureOverhead)
+
+	if tx.Fee >= minFee {
+
+		t.Fatalf("Expected the heavy tx to need more than %d, got a min of %d", tx.Fee, minFee)
+	}
+
+	tx.Signature = hex.EncodeToString(wal.mainKey.SignHash(tx.SigHash()))
+
+	if wal.VerifyTx(tx) {
+
+		t.Errorf("Expected a tx paying %d under the min of %d to be invalid", tx.Fee, params.MinFeeForWeight(tx.GetWeight()))
+	}
+
+	// Paying for its weight makes it valid
+	tx.Fee = minFee
+	tx.Signature = hex.EncodeToString(wal.mainKey.SignHash(tx.SigHash()))
+
+	if !wal.VerifyTx(tx) {
+
+		t.Errorf("Expected a tx paying the min fee of %d to be valid", minFee)
+	}
+}
+
+func TestCreateTxFeePerWeight(t *testing.T) {
+
+	wal := Init(new(blockchain.Blockchain))
+
+	if wal.FeePerWeight != 100 {
+
+		t.Errorf("Expected a default fee rate of 100, got %d", wal.FeePerWeight)
+	}
+
+	tx := wal.CreateTx("kaimorton123", 2000)
+
+	// The fee is paid on the weight of the unsigned tx, plus the signature
+	unsignedTx := tx
+	unsignedTx.Fee = 0
+	unsignedTx.Signature = ""
+
+	if expected := uint64(unsignedTx.GetWeight()+SignatureOverhead) * 100; tx.Fee != expected {
+
+		t.Errorf("Expected a fee of %d, got %d", expected, tx.Fee)
+	}
+
+	wal.FeePerWeight = 250
+
+	if scaledTx := wal.CreateTx("kaimorton123", 2000); scaledTx.Fee != tx.Fee/100*250 {
+
+		t.Errorf("Expected the fee to scale to %d, got %d", tx.Fee/100*250, scaledTx.Fee)
+	}
+
+	wal.FeePerWeight = 0
+
+	if freeTx := wal.CreateTx("kaimorton123", 2000); freeTx.TxTo != "" {
+
+		t.Errorf("Expected no tx at a rate of 0, as it is below the min fee, got %+v", freeTx)
+	}
+}
+
+func TestVerifyHistoricalBlockAssumeValid(t *testing.T) {
+
+	key, minerPub := newTestKey(t)
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: minerPub})
+
+	// Blocks 1 to 4 each have a tx with a bad signature
+	for index := 0; index < 4; index += 1 {
+
+		badTx := newSignedTx(key, "kaimorton123", 2000, 100)
+		badTx.Value = uint64(3000 + index)
+
+		block := bc.CreateBlock(minerPub)
+		block.AddTx(badTx)
+		mineBlock(t, bc, &block)
+		bc.AddBlock(&block)
+	}
+
+	wal := Init(bc)
+
+	// Without an AssumeValid block, every signature is checked
+	for height := uint(1); height <= 4; height += 1 {
+
+		if err := wal.VerifyHistoricalBlock(height); err != ErrBadTxSig {
+
+			t.Errorf("Block %d: expected %v, got %v", height, ErrBadTxSig, err)
+		}
+	}
+
+	params := blockchain.MainnetParams
+	params.AssumeValid = bc.Blocks[2].BlockHash
+	bc.SetParams(params)
+
+	for height := uint(1); height <= 4; height += 1 {
+
+		err := wal.VerifyHistoricalBlock(height)
+
+		if height <= 2 && err != nil {
+
+			t.Errorf("Block %d: expected the signatures to be skipped, got %v", height, err)
+		}
+
+		if height > 2 && err != ErrBadTxSig {
+
+			t.Errorf("Block %d: expected %v, got %v", height, ErrBadTxSig, err)
+		}
+	}
+
+	// The rest of the block is still checked below the AssumeValid block
+	bc.Blocks[1].Nonce += 1
+
+	if err := wal.VerifyHistoricalBlock(1); err != ErrBadBlockHash {
+
+		t.Errorf("Expected %v, got %v", ErrBadBlockHash, err)
+	}
+}
+
+func TestVerifyRecent(t *testing.T) {
+
+	_, minerPub := newTestKey(t)
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: minerPub})
+
+	for index := 0; index < 6; index += 1 {
+
+		block := bc.CreateBlock(minerPub)
+		mineBlock(t, bc, &block)
+		bc.AddBlock(&block)
+	}
+
+	wal := Init(bc)
+
+	if err := wal.VerifyRecent(3); err != nil {
+
+		t.Fatalf("Expected the recent blocks to be valid, got %v", err)
+	}
+
+	// Corrupt block 2, which is outside of a window of 3 but inside a window of 5
+	bc.Blocks[2].Nonce += 1
+
+	if err := wal.VerifyRecent(3); err != nil {
+
+		t.Errorf("Expected the corrupt block outside of the window to be skipped, got %v", err)
+	}
+
+	if err := wal.VerifyRecent(5); !errors.Is(err, ErrBadBlockHash) {
+
+		t.Errorf("Expected %v, got %v", ErrBadBlockHash, err)
+	}
+
+	// A window bigger than the chain verifies every block
+	if err := wal.VerifyRecent(100); !errors.Is(err, ErrBadBlockHash) {
+
+		t.Errorf("Expected %v, got %v", ErrBadBlockHash, err)
+	}
+}
+
+func TestVerifyBlockchainResume(t *testing.T) {
+
+	_, minerPub := newTestKey(t)
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: minerPub})
+
+	params := blockchain.MainnetParams
+	params.GenesisTarget = testTarget
+	bc.SetParams(params)
+
+	addMinedBlock := func() {
+
+		block := bc.CreateBlock(minerPub)
+		mineBlock(t, bc, &block)
+		bc.AddBlock(&block)
+	}
+
+	for index := 0; index < 5; index += 1 {
+
+		addMinedBlock()
+	}
+
+	wal := Init(bc)
+
+	if !wal.VerifyBlockchain() {
+
+		t.Fatal("Expected the blockchain to be valid")
+	}
+
+	// Block 2 was already verified, so corrupting it is not noticed, only the new block is verified
+	bc.Blocks[2].Nonce += 1
+	addMinedBlock()
+
+	if !wal.VerifyBlockchain() {
+
+		t.Error("Expected only the new block to be verified")
+	}
+
+	// A bad new block is still caught
+	badBlock := bc.CreateBlock(minerPub)
+	mineBlock(t, bc, &badBlock)
+	badBlock.Nonce += 1
+	bc.AddBlock(&badBlock)
+
+	if wal.VerifyBlockchain() {
+
+		t.Error("Expected the bad new block to be verified and found invalid")
+	}
+
+	bc.RemoveBlock()
+
+	// A reorg back to height 4, replacing blocks 5 and 6
+	orphaned := bc.Blocks[5]
+	bc.RollbackTo(4)
+	addMinedBlock()
+
+	if !wal.VerifyBlockchain() {
+
+		t.Error("Expected verifying to resume from the fork, not from the genisis block")
+	}
+
+	// A block that comes back after a reorg is verified again
+	bc.RollbackTo(4)
+	orphaned.Nonce += 1
+	bc.AddBlock(&orphaned)
+
+	if wal.VerifyBlockchain() {
+
+		t.Error("Expected the blocks from the fork to be verified again")
+	}
+
+	// Blocks changed without RemoveBlock are all verified again
+	bc.Blocks = bc.Blocks[:3]
+
+	if wal.VerifyBlockchain() {
+
+		t.Error("Expected every block to be verified again, finding the corrupt block 2")
+	}
+}
+
+func TestVerifyBlockchainGenesisTarget(t *testing.T) {
+
+	// A testnet genisis block, with the easier testnet target
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: blockchain.TestnetParams.GenesisTarget})
+	bc.SetParams(blockchain.TestnetParams)
+
+	wal := Init(bc)
+
+	if !wal.VerifyBlockchain() {
+
+		t.Error("Expected the testnet genisis block to be valid under the testnet params")
+	}
+
+	bc.SetParams(blockchain.MainnetParams)
+
+	if wal.VerifyBlockchain() {
+
+		t.Error("Expected the testnet genisis block to be invalid under the mainnet params")
+	}
+}
+
+func TestVerifyTxSigSchemes(t *testing.T) {
+
+	secpKey, _ := newTestKey(t)
+	edPub, edPriv, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scheme  ellip.SignatureScheme
+		privKey []byte
+		pubKey  []byte
+	}{
+		{ellip.Secp256k1{}, crypto.FromECDSA(secpKey), elliptic.Marshal(crypto.S256(), secpKey.X, secpKey.Y)},
+		{ellip.Ed25519{}, edPriv, edPub},
+	}
+
+	for index, test := range tests {
+
+		bc := new(blockchain.Blockchain)
+		params := blockchain.MainnetParams
+		params.SignatureScheme = test.scheme
+		bc.SetParams(params)
+
+		wal := Init(bc)
+
+		tx := transactions.LuTx{TxFrom: hex.EncodeToString(test.pubKey), TxTo: "kaimorton123", Value: 2000, Fee: 1000}
+		sig, err := test.scheme.Sign(test.privKey, tx.SigHash())
+
+		if err != nil {
+
+			t.Fatal(err)
+		}
+
+		tx.Signature = hex.EncodeToString(sig)
+
+		if !wal.verifyTxSig(tx) {
+
+			t.Errorf("%T: expected the tx to be valid", test.scheme)
+		}
+
+		changed := tx
+		changed.Value += 1
+
+		if wal.verifyTxSig(changed) {
+
+			t.Errorf("%T: expected a tx changed after signing to be invalid", test.scheme)
+		}
+
+		// A tx signed with the scheme of another network
+		other := tests[(index+1)%len(tests)]
+
+		otherTx := transactions.LuTx{TxFrom: hex.EncodeToString(other.pubKey), TxTo: "kaimorton123", Value: 2000, Fee: 1000}
+		otherSig, _ := other.scheme.Sign(other.privKey, otherTx.SigHash())
+		otherTx.Signature = hex.EncodeToString(otherSig)
+
+		if wal.verifyTxSig(otherTx) {
+
+			t.Errorf("%T: expected a tx signed with %T to be invalid", test.scheme, other.scheme)
+		}
+	}
+}
+
+func TestCreateTxSchemeMismatch(t *testing.T) {
+
+	edPub, edPriv, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	bc := new(blockchain.Blockchain)
+	params := blockchain.MainnetParams
+	params.SignatureScheme = ellip.Ed25519{}
+	bc.SetParams(params)
+
+	wal := Init(bc)
+
+	// The secp256k1 main key can not sign on an Ed25519 network
+	if tx := wal.CreateTx("kaimorton123", 2000); tx.Signature != "" {
+
+		t.Error("Expected no tx from the secp256k1 main key on an Ed25519 network")
+	}
+
+	if err := wal.SetKey(edPriv, otherPub); !errors.Is(err, ErrKeyMismatch) {
+
+		t.Errorf("Expected ErrKeyMismatch for a private key of another public key, got %v", err)
+	}
+
+	if err := wal.SetKey(wal.mainKey.PrivKeyBytes(), wal.mainKey.PubKeyBytes()); !errors.Is(err, ErrKeyMismatch) {
+
+		t.Errorf("Expected ErrKeyMismatch for a secp256k1 key on an Ed25519 network, got %v", err)
+	}
+
+	if err := wal.SetKey(edPriv, edPub); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if wal.PubKey() != hex.EncodeToString(edPub) {
+
+		t.Errorf("Expected the wallet to send from the key set, got %q", wal.PubKey())
+	}
+}
+
+func TestVerifyTxSigDifferentSigner(t *testing.T) {
+
+	wal := Init(new(blockchain.Blockchain))
+
+	_, claimedPub := newTestKey(t)
+	signerKey, _ := newTestKey(t)
+
+	// A tx claiming to be from one key, but signed by another
+	tx := newSignedTx(signerKey, "kaimorton123", 2000, 100)
+	tx.TxFrom = claimedPub
+	tx.Signature = hex.EncodeToString(ellip.SignHash(signerKey, tx.SigHash()))
+
+	if wal.verifyTxSig(tx) {
+
+		t.Error("Expected a tx signed by a key other than TxFrom to be invalid")
+	}
+
+	// A TxFrom that is not a public key at all
+	for _, badFrom := range []string{"", "kaimorton123", "zz"} {
+
+		tx.TxFrom = badFrom
+		tx.Signature = hex.EncodeToString(ellip.SignHash(signerKey, tx.SigHash()))
+
+		if wal.verifyTxSig(tx) {
+
+			t.Errorf("Expected a tx from %q to be invalid", badFrom)
+		}
+	}
+}
+
+func TestVerifyBlockEmptyChain(t *testing.T) {
+
+	wal := Init(new(blockchain.Blockchain))
+
+	if err := wal.VerifyBlockE(&blockchain.Block{}, false); err != ErrBadPrevHash {
+
+		t.Errorf("Expected %v on an empty blockchain, got %v", ErrBadPrevHash, err)
+	}
+}
+
+func TestCreateTxFeeRange(t *testing.T) {
+
+	wal := Init(new(blockchain.Blockchain))
+
+	tx := wal.CreateTx("kaimorton123", 2000)
+	params := wal.ChainParams()
+
+	if tx.TxTo == "" || !params.FeeInRange(tx.Fee) {
+
+		t.Fatalf("Expected a tx with a fee in range, got %+v", tx)
+	}
+
+	// A rate so low the fee is below the min
+	wal.FeePerWeight = 1
+
+	if tx := wal.CreateTx("kaimorton123", 2000); tx.TxTo != "" {
+
+		t.Errorf("Expected no tx with a fee below the min, got %+v", tx)
+	}
+
+	// A rate so high the fee is above the max
+	wal.FeePerWeight = params.MaxTxFee
+
+	if tx := wal.CreateTx("kaimorton123", 2000); tx.TxTo != "" {
+
+		t.Errorf("Expected no tx with a fee above the max, got %+v", tx)
+	}
+}
+
+func TestBalancesOf(t *testing.T) {
+
+	bc := new(blockchain.Blockchain)
+	reward := bc.GetBlockReward(0)
+
+	bc.Blocks = []blockchain.Block{
+		{Miner: "alice"},
+		{Miner: "bob", Txs: []transactions.LuTx{{TxFrom: "carol", TxTo: "dave", Value: 500}}},
+		{Miner: "alice", Txs: []transactions.LuTx{{TxFrom: "alice", TxTo: "bob", Value: 300}}},
+	}
+
+	// The rest of the blocks are within the maturity window of the tip, so erin has only mined immature rewards
+	for len(bc.Blocks) < 3+int(blockchain.RewardMaturity)+1 {
+
+		bc.Blocks = append(bc.Blocks, blockchain.Block{Miner: "erin"})
+	}
+
+	wal := Init(bc)
+
+	// Balances are the mature rewards mined and the values received, and a key that is not on the blockchain has none
+	expected := map[string]uint64{
+		"alice":  2 * reward,
+		"bob":    reward + 300,
+		"carol":  0,
+		"dave":   500,
+		"erin":   0,
+		"nobody": 0,
+	}
+
+	if balances := wal.BalancesOf([]string{"alice", "bob", "carol", "dave", "erin", "nobody"}); !reflect.DeepEqual(balances, expected) {
+
+		t.Errorf("Expected %v, got %v", expected, balances)
+	}
+
+	if len(wal.BalancesOf(nil)) != 0 {
+
+		t.Error("Expected no balances for no keys")
+	}
+}
+
+// A clock that is always at the same unix time.
+type fixedClock uint64
+
+func (c fixedClock) Now() uint64 {
+
+	return uint64(c)
+}
+
+func TestVerifyBlockFutureTimestamp(t *testing.T) {
+
+	for _, drift := range []uint64{0, 60} {
+
+		bc := newMinedChain(t)
+
+		params := blockchain.MainnetParams
+		params.MaxFutureDrift = drift
+		bc.SetParams(params)
+
+		wal := Init(bc)
+
+		_, minerPub := newTestKey(t)
+
+		block := bc.CreateBlock(minerPub)
+		mineBlock(t, bc, &block)
+
+		// The clock is a second further behind the block than the drift allows
+		wal.Clock = fixedClock(block.Timestamp - drift - 1)
+
+		if err := wal.VerifyBlockE(&block, true); !errors.Is(err, ErrBadTimestamp) {
+
+			t.Errorf("Drift %d: expected a block past the drift to be invalid, got %v", drift, err)
+		}
+
+		wal.Clock = fixedClock(block.Timestamp - drift)
+
+		if err := wal.VerifyBlockE(&block, true); err != nil {
+
+			t.Errorf("Drift %d: expected a block at the drift to be valid, got %v", drift, err)
+		}
+	}
+}
+
+func TestVerifyBlockSameSecond(t *testing.T) {
+
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	tip, _ := bc.Tip()
+	prevTimestamp := tip.Timestamp
+
+	_, minerPub := newTestKey(t)
+
+	// The miner finds the next block in the same second as the tip
+	miner := new(blockchain.Miner)
+	miner.Out = io.Discard
+	miner.Clock = fixedClock(prevTimestamp)
+	wal.Clock = fixedClock(prevTimestamp)
+
+	block := bc.CreateBlock(minerPub)
+	block.Timestamp = 0
+
+	if found, err := miner.Start(&block, bc, 1); !found || err != nil {
+
+		t.Fatalf("Could not mine the test block: %v", err)
+	}
+
+	if block.Timestamp != prevTimestamp+1 {
+
+		t.Errorf("Expected the miner to give the block the second after the tip (%d), got %d", prevTimestamp+1, block.Timestamp)
+	}
+
+	if err := wal.VerifyBlockE(&block, true); err != nil {
+
+		t.Errorf("Expected the block a second after the tip to be valid, got %v", err)
+	}
+
+	// Mined without the blockchain to check, the block keeps the second of the tip
+	block = bc.CreateBlock(minerPub)
+	block.Timestamp = 0
+
+	block, _, err := miner.StartBudget(block, 0)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if err := wal.VerifyBlockE(&block, true); !errors.Is(err, ErrBadTimestamp) || block.Timestamp != prevTimestamp {
+
+		t.Errorf("Expected a block from the same second as the tip to have a bad timestamp, got %v", err)
+	}
+}
+
+// A pool that keeps every tx added to it, or rejects every tx with err if it is set.
+type recordingPool struct {
+	txs []transactions.LuTx
+	err error
+}
+
+func (p *recordingPool) AddTx(tx transactions.LuTx) error {
+
+	if p.err != nil {
+
+		return p.err
+	}
+
+	p.txs = append(p.txs, tx)
+
+	return nil
+}
+
+func TestReconcileAfterReorg(t *testing.T) {
+
+	// The wallet has one mature block reward to spend
+	bc := newRewardChain(20, "someoneElse")
+	bc.Blocks[19].BlockHash = "common"
+
+	wal := Init(bc)
+	bc.Blocks[2].Miner = wal.PubKey()
+
+	orphanedTx := wal.CreateTx("kaimorton123", 2000)
+	keptTx := wal.CreateTx("kaimorton456", 3000)
+	otherTx := transactions.LuTx{TxFrom: "someoneElse", TxTo: "kaimorton123", Value: 1000}
+
+	bc.AddBlock(&blockchain.Block{Miner: "someoneElse", BlockHash: "old", PrevHash: "common", Txs: []transactions.LuTx{orphanedTx, keptTx, otherTx}})
+
+	// The reorg replaces the block with one that only has one of the wallet's txs
+	bc.RollbackTo(19)
+	bc.AddBlock(&blockchain.Block{Miner: "someoneElse", BlockHash: "new1", PrevHash: "common", Txs: []transactions.LuTx{keptTx}})
+	bc.AddBlock(&blockchain.Block{Miner: "someoneElse", BlockHash: "new2", PrevHash: "new1"})
+
+	pool := new(recordingPool)
+	result, err := wal.ReconcileAfterReorg("old", "new2", pool)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if len(result.Requeued) != 1 || result.Requeued[0].HashTx() != orphanedTx.HashTx() || len(result.Pending)+len(result.Dropped) != 0 {
+
+		t.Fatalf("Expected only the orphaned tx of the wallet to be requeued, got %+v", result)
+	}
+
+	if len(pool.txs) != 1 || pool.txs[0].HashTx() != orphanedTx.HashTx() {
+
+		t.Errorf("Expected the orphaned tx to be added back to the pool, got %+v", pool.txs)
+	}
+
+	// A pool that rejects the tx
+	errRejected := errors.New("rejected")
+	result, _ = wal.ReconcileAfterReorg("old", "new2", &recordingPool{err: errRejected})
+
+	if len(result.Requeued) != 0 || len(result.Dropped) != 1 || result.Dropped[0].Tx.HashTx() != orphanedTx.HashTx() || !errors.Is(result.Dropped[0].Err, errRejected) {
+
+		t.Errorf("Expected the orphaned tx to be dropped with the error of the pool, got %+v", result)
+	}
+
+	tests := []struct {
+		oldTip string
+		newTip string
+		err    error
+	}{
+		{"old", "new1", ErrNotOnNewTip},
+		{"unknown", "new2", ErrUnknownTip},
+		{"new1", "new2", nil},
+	}
+
+	for index, test := range tests {
+
+		result, err := wal.ReconcileAfterReorg(test.oldTip, test.newTip, new(recordingPool))
+
+		if !errors.Is(err, test.err) || len(result.Requeued) != 0 {
+
+			t.Errorf("Test %d: expected %v and no requeued txs, got %v and %+v", index, test.err, err, result)
+		}
+	}
+}
+
+func TestReconcileAfterReorgNonceOrder(t *testing.T) {
+
+	bc := newRewardChain(20, "someoneElse")
+	bc.Blocks[19].BlockHash = "common"
+
+	wal := Init(bc)
+	bc.Blocks[2].Miner = wal.PubKey()
+
+	// Two txs of the wallet, one after the other, on a branch of two blocks
+	txs := []transactions.LuTx{}
+
+	for index := 0; index < 2; index += 1 {
+
+		tx := wal.CreateTx("kaimorton123", 2000)
+		tx.Nonce = uint32(index)
+		tx.Signature = hex.EncodeToString(wal.mainKey.SignHash(tx.SigHash()))
+
+		txs = append(txs, tx)
+	}
+
+	bc.AddBlock(&blockchain.Block{Miner: "someoneElse", BlockHash: "old1", PrevHash: "common", Txs: txs[:1]})
+	bc.AddBlock(&blockchain.Block{Miner: "someoneElse", BlockHash: "old2", PrevHash: "old1", Txs: txs[1:]})
+
+	bc.RollbackTo(19)
+	bc.AddBlock(&blockchain.Block{Miner: "someoneElse", BlockHash: "new1", PrevHash: "common"})
+
+	pool := new(recordingPool)
+	result, err := wal.ReconcileAfterReorg("old2", "new1", pool)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if len(result.Requeued) != 2 || len(pool.txs) != 2 || pool.txs[0].Nonce != 0 || pool.txs[1].Nonce != 1 {
+
+		t.Errorf("Expected both txs to be added back in nonce order, got %+v", pool.txs)
+	}
+}
+
+func TestVerifyBlockKnownInvalid(t *testing.T) {
+
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	_, minerPub := newTestKey(t)
+
+	// Mined on a harder target than the blockchain expects
+	badBlock := bc.CreateBlock(minerPub)
+	badBlock.PackedTarget = 0x1e0fffff
+	mineBlock(t, bc, &badBlock)
+
+	if err := wal.VerifyBlockE(&badBlock, true); !errors.Is(err, ErrBadTarget) {
+
+		t.Fatalf("Expected the block to have a bad target, got %v", err)
+	}
+
+	// Sent again, it is rejected before being verified
+	if err := wal.VerifyBlockE(&badBlock, true); !errors.Is(err, ErrKnownInvalid) {
+
+		t.Errorf("Expected the re-sent block to be known invalid, got %v", err)
+	}
+
+	// A block building on the invalid block
+	child := badBlock
+	child.PrevHash = badBlock.BlockHash
+	child.BlockHash = hex.EncodeToString(child.CalcHash())
+
+	if err := wal.VerifyBlockE(&child, true); !errors.Is(err, ErrKnownInvalid) || !bc.IsInvalid(child.BlockHash) {
+
+		t.Errorf("Expected a block building on the invalid block to be known invalid, got %v", err)
+	}
+
+	// A block claiming the hash of a valid block is not remembered
+	validBlock := bc.CreateBlock(minerPub)
+	mineBlock(t, bc, &validBlock)
+
+	forged := validBlock
+	forged.Nonce += 1
+
+	if err := wal.VerifyBlockE(&forged, true); !errors.Is(err, ErrBadBlockHash) {
+
+		t.Fatalf("Expected the forged block to have a bad block hash, got %v", err)
+	}
+
+	if err := wal.VerifyBlockE(&validBlock, true); err != nil {
+
+		t.Errorf("Expected the valid block to still be valid, got %v", err)
+	}
+}
+
+func TestVerifyBlockStripsAdjacentInvalidTxs(t *testing.T) {
+
+	wal := Init(new(blockchain.Blockchain))
+	pubKey := wal.mainKey.GetPubKeyStr()
+
+	// The wallet mines the genisis block, and its reward is mature by the tip
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: pubKey})
+
+	for index := uint(0); index <= blockchain.RewardMaturity; index += 1 {
+
+		block := bc.CreateBlock(pubKey)
+		mineBlock(t, bc, &block)
+		bc.AddBlock(&block)
+	}
+
+	wal.chain = bc
+	validTx := wal.CreateTx("kaimorton123", 2000)
+
+	block := bc.CreateBlock(pubKey)
+	block.AddTx(validTx)
+
+	// Unfunded txs paying more than the valid tx, so they are next to each other before it
+	for _, fee := range []uint64{validTx.Fee * 4, validTx.Fee * 3, validTx.Fee * 2} {
+
+		block.AddTx(transactions.LuTx{TxFrom: "alice", TxTo: "bob", Value: 2000, Fee: fee})
+	}
+
+	mineBlock(t, bc, &block)
+
+	if err := wal.VerifyBlockE(&block, true); err != nil {
+
+		t.Fatalf("Expected the block to be valid with its invalid txs removed, got %v", err)
+	}
+
+	if len(block.Txs) != 1 || block.Txs[0].HashTx() != validTx.HashTx() {
+
+		t.Errorf("Expected every invalid tx to be removed, leaving only the valid tx, got %+v", block.Txs)
+	}
+}
+
+func TestVerifyBlockTxOrder(t *testing.T) {
+
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	_, minerPub := newTestKey(t)
+
+	block := bc.CreateBlock(minerPub)
+
+	for _, fee := range []uint64{1000, 3000, 2000} {
+
+		block.AddTx(transactions.LuTx{TxFrom: "alice", TxTo: "bob", Value: 2000, Fee: fee})
+	}
+
+	scrambled := block
+	scrambled.Txs = []transactions.LuTx{block.Txs[2], block.Txs[0], block.Txs[1]}
+	scrambled.MerkleRoot = scrambled.GetMerkleRoot()
+
+	mineBlock(t, bc, &scrambled)
+
+	if err := wal.VerifyBlockE(&scrambled, true); !errors.Is(err, ErrBadTxOrder) {
+
+		t.Errorf("Expected the scrambled block to be invalid, got %v", err)
+	}
+
+	mineBlock(t, bc, &block)
+
+	// The txs are not funded, so they are removed after the block is verified
+	if err := wal.VerifyBlockE(&block, true); err != nil {
+
+		t.Errorf("Expected the ordered block to be valid, got %v", err)
+	}
+}
+
+func TestVerifyBlockMinedInParallel(t *testing.T) {
+
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	_, minerPub := newTestKey(t)
+
+	miner := new(blockchain.Miner)
+	miner.Out = io.Discard
+
+	block, err := miner.StartParallel(bc.CreateBlock(minerPub), 4)
+
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if err := wal.VerifyBlockE(&block, true); err != nil {
+
+		t.Errorf("Expected the block mined in parallel to be valid, got %v", err)
+	}
+}
+
+func TestVerifyBlockCoinbaseTag(t *testing.T) {
+
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	_, minerPub := newTestKey(t)
+
+	block := bc.CreateBlock(minerPub)
+	block.CoinbaseData = make([]byte, blockchain.MaxCoinbaseTagLen+1)
+	mineBlock(t, bc, &block)
+
+	if err := wal.VerifyBlockE(&block, true); !errors.Is(err, ErrBadCoinbaseTag) {
+
+		t.Errorf("Expected a block with a tag that is too long to be invalid, got %v", err)
+	}
+
+	block.CoinbaseData = []byte("LuncheonPool/1")
+	mineBlock(t, bc, &block)
+
+	if err := wal.VerifyBlockE(&block, true); err != nil {
+
+		t.Errorf("Expected a block with a tag to be valid, got %v", err)
+	}
+
+	// The tag is part of the hash, so it can not be changed after the block is mined
+	block.CoinbaseData = []byte("LuncheonPool/2")
+
+	if err := wal.VerifyBlockE(&block, true); !errors.Is(err, ErrBadBlockHash) {
+
+		t.Errorf("Expected a block with a changed tag to have a bad hash, got %v", err)
+	}
+}
+
+func TestVerifyBlockGenesisPrevHash(t *testing.T) {
+
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	_, minerPub := newTestKey(t)
+
+	block := bc.CreateBlock(minerPub)
+	block.PrevHash = blockchain.GenesisPrevHash
+	mineBlock(t, bc, &block)
+
+	if err := wal.VerifyBlockE(&block, true); !errors.Is(err, ErrGenesisPrevHash) {
+
+		t.Errorf("Expected a block with the genisis prev hash to be invalid, got %v", err)
+	}
+
+	// Already on the blockchain
+	bc.AddBlock(&block)
+
+	if err := wal.VerifyHistoricalBlock(bc.GetHeight()); !errors.Is(err, ErrGenesisPrevHash) {
+
+		t.Errorf("Expected the block on the blockchain to be invalid, got %v", err)
+	}
+
+	// A blockchain of only the genisis block checks the block after it the same way
+	genesisOnly := new(blockchain.Blockchain)
+	genesisOnly.Blocks = append(genesisOnly.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: minerPub})
+	genesisWal := Init(genesisOnly)
+
+	block = genesisOnly.CreateBlock(minerPub)
+	block.PrevHash = blockchain.GenesisPrevHash
+	mineBlock(t, genesisOnly, &block)
+
+	if err := genesisWal.VerifyBlockE(&block, true); !errors.Is(err, ErrGenesisPrevHash) {
+
+		t.Errorf("Expected a height 1 block with the genisis prev hash to be invalid, got %v", err)
+	}
+
+	block = genesisOnly.CreateBlock(minerPub)
+	mineBlock(t, genesisOnly, &block)
+
+	if err := genesisWal.VerifyBlockE(&block, true); err != nil {
+
+		t.Errorf("Expected a valid height 1 block to be valid, got %v", err)
+	}
+}
+
+// A pool of txs waiting to be mined.
+type pendingTxs []transactions.LuTx
+
+func (p pendingTxs) Pending() []transactions.LuTx {
+
+	return p
+}
+
+func TestFullBalance(t *testing.T) {
+
+	// The tip is at height 19, so the reward from height 3 is mature and the rewards from 12 and 19 are not
+	bc := newRewardChain(20, "miner", 3, 12, 19)
+	bc.Blocks[5].Txs = []transactions.LuTx{{TxFrom: "someoneElse", TxTo: "miner", Value: 5000}}
+
+	wal := Init(bc)
+	reward := bc.GetBlockReward(0)
+
+	pool := pendingTxs{
+		{TxFrom: "miner", TxTo: "bob", Value: 20000, Fee: 1000},
+		{TxFrom: "carol", TxTo: "miner", Value: 3000, Fee: 1000},
+		{TxFrom: "carol", TxTo: "bob", Value: 4000, Fee: 1000},
+		{TxFrom: "miner", TxTo: "carol", Value: 10000, Fee: 2000},
+	}
+
+	expected := BalanceBreakdown{
+		Confirmed:  reward + 5000,
+		Immature:   2 * reward,
+		PendingIn:  3000,
+		PendingOut: 33000,
+		Spendable:  reward + 5000 - 33000,
+	}
+
+	if balance := wal.FullBalance("miner", pool); balance != expected {
+
+		t.Errorf("Expected %+v, got %+v", expected, balance)
+	}
+
+	// Pending txs spending more than is confirmed leave nothing spendable
+	if balance := wal.FullBalance("carol", pool); balance.Spendable != 0 || balance.PendingOut != 9000 || balance.PendingIn != 10000 {
+
+		t.Errorf("Expected nothing spendable for carol, got %+v", balance)
+	}
+}
+
+func TestVerifyBlockMinedRoundTrip(t *testing.T) {
+
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	_, minerPub := newTestKey(t)
+
+	miner := new(blockchain.Miner)
+	miner.Out = io.Discard
+
+	tests := []struct {
+		name string
+		mine func(block blockchain.Block) (blockchain.Block, error)
+	}{
+		{"Start", func(block blockchain.Block) (blockchain.Block, error) {
+
+			_, err := miner.Start(&block, bc, 1)
+			return block, err
+		}},
+		{"StartBudget", func(block blockchain.Block) (blockchain.Block, error) {
+
+			block, _, err := miner.StartBudget(block, 0)
+			return block, err
+		}},
+		{"StartContext", func(block blockchain.Block) (blockchain.Block, error) {
+
+			return miner.StartContext(context.Background(), block)
+		}},
+		{"StartParallel", func(block blockchain.Block) (blockchain.Block, error) {
+
+			return miner.StartParallel(block, 2)
+		}},
+	}
+
+	for _, test := range tests {
+
+		block, err := test.mine(bc.CreateBlock(minerPub))
+
+		if err != nil {
+
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if err := wal.VerifyBlockE(&block, true); err != nil {
+
+			t.Errorf("%s: Expected the mined block to be valid, got %v", test.name, err)
+		}
+
+		// The stored nonce has to be the one that produced the hash
+		block.Nonce += 1
+
+		if err := wal.VerifyBlockE(&block, true); !errors.Is(err, ErrBadBlockHash) {
+
+			t.Errorf("%s: Expected a block with a different nonce to have a bad hash, got %v", test.name, err)
+		}
+	}
+}
+
+func TestVerifyBlockFromTemplateZeroTarget(t *testing.T) {
+
+	_, minerPub := newTestKey(t)
+
+	// A genesis block without a target, so every block before the first retarget falls back to the genesis target
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{Miner: minerPub})
+
+	params := blockchain.MainnetParams
+	params.GenesisTarget = testTarget
+	bc.SetParams(params)
+
+	wal := Init(bc)
+
+	template := bc.NewBlockTemplate(minerPub, nil)
+
+	if template.PackedTarget != testTarget {
+
+		t.Fatalf("Expected the template to fall back to the genesis target %08x, got %08x", testTarget, template.PackedTarget)
+	}
+
+	block := template.Block()
+	mineBlock(t, bc, &block)
+
+	if err := wal.VerifyBlockE(&block, true); err != nil {
+
+		t.Errorf("Expected the block mined from the template to be valid, got %v", err)
+	}
+}
+
+func TestRuleSeverity(t *testing.T) {
+
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{nil, SeverityNone},
+		{ErrBadSoftwareVersion, SeverityMinor},
+		{ErrBadPrevHash, SeverityMinor},
+		{ErrBadTimestamp, SeverityMinor},
+		{ErrBadMiner, SeverityFatal},
+		{ErrBadCoinbaseTag, SeverityFatal},
+		{ErrBadBlockHash, SeverityFatal},
+		{ErrBadProofOfWork, SeverityFatal},
+		{ErrBadTarget, SeverityFatal},
+		{ErrBadMerkleRoot, SeverityFatal},
+		{ErrBadTxOrder, SeverityFatal},
+		{ErrBadTxSig, SeverityFatal},
+		{ErrKnownInvalid, SeverityFatal},
+		{ErrGenesisPrevHash, SeverityFatal},
+
+		// Wrapped the way VerifyBlockE wraps a malformed target
+		{fmt.Errorf("%w: %s", ErrBadTarget, "malformed"), SeverityFatal},
+		{errors.New("some other error"), SeverityMinor},
+	}
+
+	for _, test := range tests {
+
+		if severity := RuleSeverity(test.err); severity != test.expected {
+
+			t.Errorf("%v: expected a severity of %d, got %d", test.err, test.expected, severity)
+		}
+	}
+
+	// A block changed after it was mined no longer matches its hash
+	bc := newMinedChain(t)
+	wal := Init(bc)
+
+	_, minerPub := newTestKey(t)
+
+	block := bc.CreateBlock(minerPub)
+	mineBlock(t, bc, &block)
+	block.Timestamp += 1
+
+	if severity := RuleSeverity(wal.VerifyBlockE(&block, true)); severity != SeverityFatal {
+
+		t.Errorf("Expected a tampered block to be fatal, got %d", severity)
+	}
+}
+
+// A signature scheme that counts how many signatures it verifies.
+type countingScheme struct {
+	ellip.Secp256k1
+	verifies *int
+}
+
+func (c countingScheme) Verify(pubKey []byte, msgHash []byte, sig []byte) bool {
+
+	*c.verifies += 1
+
+	return c.Secp256k1.Verify(pubKey, msgHash, sig)
+}
+
+func TestVerifyBlockchainSigCheckDepth(t *testing.T) {
+
+	key, minerPub := newTestKey(t)
+
+	bc := new(blockchain.Blockchain)
+	bc.Blocks = append(bc.Blocks, blockchain.Block{PackedTarget: testTarget, Miner: minerPub})
+
+	// Blocks 1 to 4 each have a tx, and only the tx of block 1 has a bad signature
+	for index := 0; index < 4; index += 1 {
+
+		tx := newSignedTx(key, "kaimorton123", uint64(2000+index), 100)
+
+		if index == 0 {
+
+			tx.Value += 1000
+		}
+
+		block := bc.CreateBlock(minerPub)
+		block.AddTx(tx)
+		mineBlock(t, bc, &block)
+		bc.AddBlock(&block)
+	}
+
+	tests := []struct {
+		depth    uint
+		valid    bool
+		verifies int
+	}{
+		// Every block is checked, stopping at the bad signature of block 1
+		{0, false, 1},
+
+		// The tip is 4, so blocks 1 and up are within the depth
+		{3, false, 1},
+		{10, false, 1},
+
+		// Block 1 is below the depth, so only the signatures of blocks 2 to 4 are checked
+		{2, true, 3},
+		{1, true, 2},
+	}
+
+	for _, test := range tests {
+
+		verifies := 0
+
+		params := blockchain.MainnetParams
+		params.GenesisTarget = testTarget
+		params.SigCheckDepth = test.depth
+		params.SignatureScheme = countingScheme{verifies: &verifies}
+		bc.SetParams(params)
+
+		// A new wallet, so nothing was verified or cached before
+		wal := Init(bc)
+
+		if valid := wal.VerifyBlockchain(); valid != test.valid {
+
+			t.Errorf("Depth %d: expected the blockchain to be valid %t, got %t", test.depth, test.valid, valid)
+		}
+
+		if verifies != test.verifies {
+
+			t.Errorf("Depth %d: expected %d signatures to be checked, got %d", test.depth, test.verifies, verifies)
+		}
+	}
+
+	// The proof of work of a block below the depth is still checked
+	params := blockchain.MainnetParams
+	params.GenesisTarget = testTarget
+	params.SigCheckDepth = 2
+	bc.SetParams(params)
+
+	bc.Blocks[1].Nonce += 1
+	wal := Init(bc)
+
+	if wal.VerifyBlockchain() {
+
+		t.Error("Expected a block below the depth with a bad hash to be invalid")
+	}
+}
+
+func TestRefreshCache(t *testing.T) {
+
+	key, pubKey := newTestKey(t)
+	_, otherPub := newTestKey(t)
+
+	bc := newRewardChain(int(blockchain.RewardMaturity)+2, pubKey, 0)
+	wal := Init(bc)
+	walCopy := wal
+
+	reward := bc.GetBlockReward(0)
+
+	if balance := wal.ScanChainForBalance(otherPub); balance != 0 {
+
+		t.Fatalf("Expected no balance before the tx, got %d", balance)
+	}
+
+	if _, cached := wal.balances.balances[otherPub]; !cached {
+
+		t.Fatal("Expected the balance to be cached")
+	}
+
+	// A new block with a tx to the cached key
+	block := blockchain.Block{Miner: "someoneElse"}
+	block.Txs = append(block.Txs, newSignedTx(key, otherPub, 5000, 1000))
+	bc.AddBlock(&block)
+
+	if balance := wal.ScanChainForBalance(otherPub); balance != 5000 {
+
+		t.Errorf("Expected the new tx to refresh the cached balance to 5000, got %d", balance)
+	}
+
+	// Copies of the wallet share the cache
+	if balance := walCopy.ScanChainForBalance(otherPub); balance != 5000 {
+
+		t.Errorf("Expected the copy of the wallet to see the new tx, got %d", balance)
+	}
+
+	// Removing the block in a reorg takes the tx back out
+	bc.RemoveBlock()
+
+	if balance := wal.ScanChainForBalance(otherPub); balance != 0 {
+
+		t.Errorf("Expected the removed tx to refresh the cached balance to 0, got %d", balance)
+	}
+
+	if balance := wal.ScanChainForBalance(pubKey); balance != reward {
+
+		t.Errorf("Expected a balance of %d, got %d", reward, balance)
+	}
+
+	wal.RefreshCache()
+
+	if len(wal.balances.balances) != 0 {
+
+		t.Error("Expected RefreshCache to forget every cached balance")
+	}
+
+	// Blocks added without AddBlock are noticed as well
+	bc.Blocks = append(bc.Blocks, block)
+
+	if balance := wal.ScanChainForBalance(otherPub); balance != 5000 {
+
+		t.Errorf("Expected a block added without AddBlock to refresh the cached balance to 5000, got %d", balance)
+	}
+}
+
+func TestWalletClose(t *testing.T) {
+
+	bc := newMinedChain(t)
+
+	params := blockchain.MainnetParams
+	params.GenesisTarget = testTarget
+	bc.SetParams(params)
+
+	open := Init(bc)
+	closed := Init(bc)
+
+	for _, wal := range []Wallet{open, closed} {
+
+		if !wal.VerifyBlockchain() {
+
+			t.Fatal("Expected the blockchain to be valid")
+		}
+	}
+
+	// Closing a copy closes the wallet, and closing it again does nothing
+	closedCopy := closed
+	closedCopy.Close()
+	closed.Close()
+
+	// Only the hooks of the open wallet move back how far VerifyBlockchain got
+	bc.RemoveBlock()
+
+	if open.verified.blocks != bc.Len() {
+
+		t.Errorf("Expected the open wallet to verify the removed block again, got %d verified blocks", open.verified.blocks)
+	}
+
+	if closed.verified.blocks != bc.Len()+1 {
+
+		t.Errorf("Expected the closed wallet to have no hooks, got %d verified blocks", closed.verified.blocks)
+	}
+}